@@ -0,0 +1,230 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	// Additional signature algorithms that may be accepted from the IDP. SHA-1 algorithms
+	// are not allowed unless enabled with WithAllowedAlgorithms.
+	SignatureMethodRSASHA1     = dsig.RSASHA1SignatureMethod
+	SignatureMethodRSASHA384   = dsig.RSASHA384SignatureMethod
+	SignatureMethodECDSASHA1   = dsig.ECDSASHA1SignatureMethod
+	SignatureMethodECDSASHA384 = dsig.ECDSASHA384SignatureMethod
+
+	// Digest algorithms used in XML signature references.
+	// See https://www.w3.org/TR/xmldsig-core1/#sec-AlgID
+	DigestMethodSHA1   = "http://www.w3.org/2000/09/xmldsig#sha1"
+	DigestMethodSHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	DigestMethodSHA384 = "http://www.w3.org/2001/04/xmldsig-more#sha384"
+	DigestMethodSHA512 = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+var (
+	// ErrAlgorithmNotAllowed occurs when a message from the IDP is signed with an algorithm
+	// that is not in the allow-list.
+	ErrAlgorithmNotAllowed = errors.New("algorithm not allowed")
+
+	// DefaultSignatureMethods are the signature algorithms accepted if WithAllowedAlgorithms
+	// is not supplied.
+	DefaultSignatureMethods = []string{
+		SignatureMethodRSASHA256,
+		SignatureMethodRSASHA384,
+		SignatureMethodRSASHA512,
+		SignatureMethodECDSASHA256,
+		SignatureMethodECDSASHA384,
+		SignatureMethodECDSASHA512,
+	}
+	// DefaultDigestMethods are the digest algorithms accepted if WithAllowedAlgorithms
+	// is not supplied.
+	DefaultDigestMethods = []string{
+		DigestMethodSHA256,
+		DigestMethodSHA384,
+		DigestMethodSHA512,
+	}
+)
+
+// signatureKeyAlgorithms maps signature algorithms to the type of key that makes them.
+var signatureKeyAlgorithms = map[string]x509.PublicKeyAlgorithm{
+	SignatureMethodRSASHA1:     x509.RSA,
+	SignatureMethodRSASHA256:   x509.RSA,
+	SignatureMethodRSASHA384:   x509.RSA,
+	SignatureMethodRSASHA512:   x509.RSA,
+	SignatureMethodECDSASHA1:   x509.ECDSA,
+	SignatureMethodECDSASHA256: x509.ECDSA,
+	SignatureMethodECDSASHA384: x509.ECDSA,
+	SignatureMethodECDSASHA512: x509.ECDSA,
+}
+
+// signatureHashes maps signature algorithms to the hash used to compute them.
+var signatureHashes = map[string]crypto.Hash{
+	SignatureMethodRSASHA1:     crypto.SHA1,
+	SignatureMethodRSASHA256:   crypto.SHA256,
+	SignatureMethodRSASHA384:   crypto.SHA384,
+	SignatureMethodRSASHA512:   crypto.SHA512,
+	SignatureMethodECDSASHA1:   crypto.SHA1,
+	SignatureMethodECDSASHA256: crypto.SHA256,
+	SignatureMethodECDSASHA384: crypto.SHA384,
+	SignatureMethodECDSASHA512: crypto.SHA512,
+}
+
+type allowedAlgorithms struct {
+	signatureMethods []string
+	digestMethods    []string
+}
+
+// WithAllowedAlgorithms is an optional parameter to NewSingleSignOnProfile and NewSingleLogOutProfile
+// that replaces the signature and digest algorithms accepted from the IDP. The allow-list applies to
+// XML signatures and to the SigAlg of messages sent with the redirect binding. If not supplied
+// DefaultSignatureMethods and DefaultDigestMethods are used.
+func WithAllowedAlgorithms(signatureMethods, digestMethods []string) func() interface{} {
+	return func() interface{} {
+		return allowedAlgorithms{
+			signatureMethods: signatureMethods,
+			digestMethods:    digestMethods,
+		}
+	}
+}
+
+func defaultAllowedAlgorithms() allowedAlgorithms {
+	return allowedAlgorithms{
+		signatureMethods: DefaultSignatureMethods,
+		digestMethods:    DefaultDigestMethods,
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (a allowedAlgorithms) checkSignatureMethod(method string) error {
+	if !contains(a.signatureMethods, method) {
+		return errors.Wrapf(ErrAlgorithmNotAllowed, "signature method %q", method)
+	}
+	return nil
+}
+
+func (a allowedAlgorithms) checkDigestMethod(method string) error {
+	if !contains(a.digestMethods, method) {
+		return errors.Wrapf(ErrAlgorithmNotAllowed, "digest method %q", method)
+	}
+	return nil
+}
+
+// checkXMLSignatures checks the algorithms of every signature in the document rooted at root.
+func (a allowedAlgorithms) checkXMLSignatures(root *etree.Element) error {
+	for _, signature := range root.FindElements("//Signature") {
+		if signature.NamespaceURI() != dsig.Namespace {
+			continue
+		}
+		signedInfo := signature.SelectElement("SignedInfo")
+		if signedInfo == nil {
+			return errors.New("signature is missing SignedInfo")
+		}
+		method := signedInfo.SelectElement("SignatureMethod")
+		if method == nil {
+			return errors.New("signature is missing SignatureMethod")
+		}
+		err := a.checkSignatureMethod(method.SelectAttrValue("Algorithm", ""))
+		if err != nil {
+			return err
+		}
+		for _, reference := range signedInfo.SelectElements("Reference") {
+			digest := reference.SelectElement("DigestMethod")
+			if digest == nil {
+				return errors.New("signature reference is missing DigestMethod")
+			}
+			err = a.checkDigestMethod(digest.SelectAttrValue("Algorithm", ""))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifyRedirectSignature verifies the detached signature of a message sent with the redirect
+// binding. The signature covers the message, RelayState and SigAlg query parameters exactly as
+// they appear in the raw query. Queries with more than one of any binding parameter are rejected,
+// otherwise the value that was verified may not be the value used. Only certificates with the
+// type of key named by SigAlg are tried, ECDSA signatures are the concatenation of r and s.
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf Section 3.4.4.1
+func (a allowedAlgorithms) verifyRedirectSignature(rawQuery string, certs []*x509.Certificate) error {
+	raw := map[string]string{}
+	for _, param := range strings.Split(rawQuery, "&") {
+		key := param
+		if i := strings.Index(param, "="); i >= 0 {
+			key = param[:i]
+		}
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			return errors.Wrap(err, "parsing query")
+		}
+		if _, ok := raw[key]; ok && isBindingParameter(key) {
+			return errors.Errorf("more than one %s parameter", key)
+		}
+		raw[key] = param
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return errors.Wrap(err, "parsing query")
+	}
+	sigAlg := query.Get(SigAlgQueryKey)
+	err = a.checkSignatureMethod(sigAlg)
+	if err != nil {
+		return err
+	}
+	hash, ok := signatureHashes[sigAlg]
+	if !ok {
+		return errors.Errorf("unsupported signature method %q", sigAlg)
+	}
+	signature, err := base64.StdEncoding.DecodeString(query.Get(SignatureQueryKey))
+	if err != nil {
+		return errors.Wrap(err, "decoding signature")
+	}
+	var signed []string
+	for _, key := range []string{RequestQueryKey, ResponseQueryKey, RelayStateQueryKey, SigAlgQueryKey} {
+		if param, ok := raw[key]; ok {
+			signed = append(signed, param)
+		}
+	}
+	h := hash.New()
+	h.Write([]byte(strings.Join(signed, "&")))
+	digest := h.Sum(nil)
+	keyAlgorithm := signatureKeyAlgorithms[sigAlg]
+	for _, cert := range certs {
+		switch key := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if keyAlgorithm == x509.RSA && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if keyAlgorithm != x509.ECDSA || len(signature) != 2*size {
+				continue
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("redirect binding signature could not be verified")
+}
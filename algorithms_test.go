@@ -0,0 +1,158 @@
+package saml
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckXMLSignatures(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	doc := etree.NewDocument()
+	err := doc.ReadFromBytes(getSignedAuthResponse(t, idp, true, true))
+	require.Nil(t, err)
+
+	err = defaultAllowedAlgorithms().checkXMLSignatures(doc.Root())
+	assert.Nil(t, err)
+
+	onlySHA512 := allowedAlgorithms{
+		signatureMethods: []string{SignatureMethodRSASHA512},
+		digestMethods:    DefaultDigestMethods,
+	}
+	err = onlySHA512.checkXMLSignatures(doc.Root())
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
+
+	onlySHA1Digest := allowedAlgorithms{
+		signatureMethods: DefaultSignatureMethods,
+		digestMethods:    []string{DigestMethodSHA1},
+	}
+	err = onlySHA1Digest.checkXMLSignatures(doc.Root())
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
+}
+
+func getRedirectQuery(t *testing.T, sp *ServiceProvider) string {
+	location, err := sp.redirectBindingURL("https://sp.example.com/slo", RequestQueryKey, bytes.NewBufferString("<foo/>"), "a b&c")
+	require.Nil(t, err)
+	parsed, err := url.Parse(location)
+	require.Nil(t, err)
+	return parsed.RawQuery
+}
+
+func TestVerifyRedirectSignature(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	certs := []*x509.Certificate{getRSAServiceProvider(t).SigningCertificate, idp.SigningCertificate}
+	query := getRedirectQuery(t, idp)
+
+	err := defaultAllowedAlgorithms().verifyRedirectSignature(query, certs)
+	assert.Nil(t, err)
+
+	err = defaultAllowedAlgorithms().verifyRedirectSignature(query, certs[:1])
+	assert.NotNil(t, err)
+
+	tampered := strings.Replace(query, RelayStateQueryKey+"=a", RelayStateQueryKey+"=b", 1)
+	err = defaultAllowedAlgorithms().verifyRedirectSignature(tampered, certs)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyRedirectSignatureDuplicates(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	certs := []*x509.Certificate{idp.SigningCertificate}
+	query := getRedirectQuery(t, idp)
+	for _, extra := range []string{
+		RequestQueryKey + "=PGJhci8%2B",
+		RelayStateQueryKey + "=b",
+		"Relay%53tate=b",
+		SigAlgQueryKey + "=" + url.QueryEscape(SignatureMethodRSASHA256),
+		SignatureQueryKey + "=AAAA",
+	} {
+		for _, duplicated := range []string{query + "&" + extra, extra + "&" + query} {
+			err := defaultAllowedAlgorithms().verifyRedirectSignature(duplicated, certs)
+			assert.NotNil(t, err, duplicated)
+		}
+	}
+	// parameters that are not part of the binding may be repeated
+	err := defaultAllowedAlgorithms().verifyRedirectSignature(query+"&x=1&x=2", certs)
+	assert.Nil(t, err)
+}
+
+func TestVerifyRedirectSignatureDisallowed(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	idp.SignatureMethod = SignatureMethodRSASHA1
+	query := getRedirectQuery(t, idp)
+	certs := []*x509.Certificate{idp.SigningCertificate}
+
+	err := defaultAllowedAlgorithms().verifyRedirectSignature(query, certs)
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
+
+	allowed := allowedAlgorithms{signatureMethods: []string{SignatureMethodRSASHA1}}
+	err = allowed.verifyRedirectSignature(query, certs)
+	assert.Nil(t, err)
+}
+
+// The ECDSA interop vectors were signed with OpenSSL through Node.js, which encodes the
+// signatures as the concatenation of r and s, rather than with this package.
+const interopECDSACertificate = `-----BEGIN CERTIFICATE-----
+MIIBkzCCATmgAwIBAgIUX/qFphdXTpjLU7MxstFj8ORXmdgwCgYIKoZIzj0EAwIw
+HjEcMBoGA1UEAwwTaW50ZXJvcC5leGFtcGxlLmNvbTAgFw0yNjEwMTkxMDIxMjBa
+GA8yMTI2MDkyNTEwMjEyMFowHjEcMBoGA1UEAwwTaW50ZXJvcC5leGFtcGxlLmNv
+bTBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABITfc8D+fkmP5hBg9Pghs4zsY9qP
+KnfbJcsUbzPuz2sXwKJGlrPWhmIobXWNBwd3zh22VUk9ry1hk5zySMxZgAGjUzBR
+MB0GA1UdDgQWBBQs+DnLeXFzseUByxDfWpVS7/74ojAfBgNVHSMEGDAWgBQs+DnL
+eXFzseUByxDfWpVS7/74ojAPBgNVHRMBAf8EBTADAQH/MAoGCCqGSM49BAMCA0gA
+MEUCIQCIpQq/1nIJh4xQ4nIHYTg6C46J9i4YG/7fnOs8V/4ASwIgEtw25PLBWhVP
+NrQLZ3Q35EAgpxSTAsIdwEstlzOJlMA=
+-----END CERTIFICATE-----`
+
+const interopECDSARedirectQuery = `SAMLRequest=fZJNb4MwDIb%2FCvI9JKS0g6hUqtbLpE6T1mmHXaYAhkaChMVm6v79KG213naz%2Feq1n9dej7rvBrWf%2BORf8WtC4uDct57U1SlhCl55TY6U1z2S4koddy8HJSOhhuA5VL6D4GGM6i4zAjZfbwN%2F3a8%2Fu4fZ&RelayState=token&SigAlg=http%3A%2F%2Fwww.w3.org%2F2001%2F04%2Fxmldsig-more%23ecdsa-sha256&Signature=R1KXLYiJTowmMp8Iu6v81Dpo06FldKEx9vsRkoq%2F4Wv9G9L03gTmF6WOsHL4P93Fyo%2Bekq909%2BbU%2Fj6gTXzP%2Bg%3D%3D`
+
+// interopECDSALogoutRequest is written in exclusive canonical form so that its digest and the
+// signature of its SignedInfo could be computed over the literal text.
+const interopECDSALogoutRequest = `<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_interop" IssueInstant="2020-01-01T00:00:00Z" Version="2.0"><saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">https://idp.example.com</saml:Issuer><ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo><ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:CanonicalizationMethod><ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"></ds:SignatureMethod><ds:Reference URI="#_interop"><ds:Transforms><ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"></ds:Transform><ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:Transform></ds:Transforms><ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></ds:DigestMethod><ds:DigestValue>9qUG8T5XXpwgoU4G61FHHEbNOpIJvMxfqv8VaWraXEw=</ds:DigestValue></ds:Reference></ds:SignedInfo><ds:SignatureValue>Lwd43cJNoLvARipAorP16WTNcwC3O2Y85BXXWJYGjDMRXLWGYDuL0fZqNQLe86DdC7MCQ5Jbxkgi1V3fzcWBdQ==</ds:SignatureValue></ds:Signature><saml:NameID xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">john@kolide.co</saml:NameID></samlp:LogoutRequest>`
+
+func getInteropECDSACertificate(t *testing.T) *x509.Certificate {
+	block, _ := pem.Decode([]byte(interopECDSACertificate))
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.Nil(t, err)
+	return cert
+}
+
+func TestVerifyRedirectSignatureECDSAInterop(t *testing.T) {
+	cert := getInteropECDSACertificate(t)
+	algorithms := defaultAllowedAlgorithms()
+	err := algorithms.verifyRedirectSignature(interopECDSARedirectQuery, []*x509.Certificate{cert})
+	assert.Nil(t, err)
+
+	// the key type must match SigAlg
+	rsaCert := getRSAServiceProvider(t).SigningCertificate
+	err = algorithms.verifyRedirectSignature(interopECDSARedirectQuery, []*x509.Certificate{rsaCert})
+	assert.NotNil(t, err)
+	query := strings.Replace(interopECDSARedirectQuery, url.QueryEscape(SignatureMethodECDSASHA256), url.QueryEscape(SignatureMethodRSASHA256), 1)
+	err = algorithms.verifyRedirectSignature(query, []*x509.Certificate{cert})
+	assert.NotNil(t, err)
+}
+
+func TestValidateECDSASignatureInterop(t *testing.T) {
+	doc := etree.NewDocument()
+	require.Nil(t, doc.ReadFromString(interopECDSALogoutRequest))
+	err := defaultAllowedAlgorithms().checkXMLSignatures(doc.Root())
+	require.Nil(t, err)
+	validator := &signatureValidator{certs: []*x509.Certificate{getInteropECDSACertificate(t)}, thisInstant: time.Now()}
+	validated, err := validator.Validate(doc.Root())
+	require.Nil(t, err)
+	assert.Equal(t, "john@kolide.co", validated.SelectElement("NameID").Text())
+
+	other := getRSAServiceProvider(t)
+	validator = &signatureValidator{certs: []*x509.Certificate{other.SigningCertificate}, thisInstant: time.Now()}
+	_, err = validator.Validate(doc.Root())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,363 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sort"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	// DefaultAssertionLifetime is the time an assertion may be used for after it is issued, if
+	// the IdentityProvider AssertionLifetime is zero.
+	DefaultAssertionLifetime = 5 * time.Minute
+
+	// AuthnContextPasswordProtectedTransport is the authentication context class of users that
+	// logged in with a password over a secure connection.
+	// See http://docs.oasis-open.org/security/saml/v2.0/saml-authn-context-2.0-os.pdf Section 3.4.
+	AuthnContextPasswordProtectedTransport = "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"
+	// AttributeNameFormatBasic is the name format of attributes with simple string names.
+	AttributeNameFormatBasic = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+
+	subjectConfirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	xsNamespace               = "http://www.w3.org/2001/XMLSchema"
+	xsiNamespace              = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// Principal is an authenticated user that the IDP issues an assertion for.
+type Principal struct {
+	// NameID identifies the user to the service provider.
+	NameID string
+	// NameIDFormat is the format of NameID. If empty the format requested by the service
+	// provider is used, or NameIDUnspecified if none was requested.
+	NameIDFormat string
+	// SessionIndex identifies the IDP session of the user, it is used for single logout.
+	SessionIndex string
+	// AuthnInstant is the time the user authenticated. If zero the issue instant of the
+	// response is used.
+	AuthnInstant time.Time
+	// AuthnContextClassRef describes how the user authenticated. If empty
+	// AuthnContextPasswordProtectedTransport is used.
+	AuthnContextClassRef string
+	// Attributes are sent to the service provider in the AttributeStatement of the assertion,
+	// each with the basic name format and string values.
+	Attributes map[string][]string
+}
+
+// NewResponse returns a successful Response to request containing an assertion about principal.
+// The response may be modified before it is sent with ResponsePostForm.
+func (idp *IdentityProvider) NewResponse(request *ReceivedAuthnRequest, principal *Principal, thisInstant time.Time) (*Response, error) {
+	response, err := idp.newResponse(request, thisInstant, newStatus(Success))
+	if err != nil {
+		return nil, err
+	}
+	assertionID, err := getUniqueID()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting id for assertion")
+	}
+	format := principal.NameIDFormat
+	if format == "" && request.Request.NameIDPolicy != nil {
+		format = request.Request.NameIDPolicy.Format
+	}
+	if format == "" {
+		format = NameIDUnspecified
+	}
+	authnInstant := principal.AuthnInstant
+	if authnInstant.IsZero() {
+		authnInstant = thisInstant
+	}
+	classRef := principal.AuthnContextClassRef
+	if classRef == "" {
+		classRef = AuthnContextPasswordProtectedTransport
+	}
+	notOnOrAfter := NewDateTime(thisInstant.Add(idp.assertionLifetime()))
+	recipient := request.AssertionConsumerService.Location
+
+	response.Assertion = Assertion{
+		XMLName: xml.Name{
+			Local: "saml:Assertion",
+		},
+		ID:           "_" + assertionID,
+		Version:      samlVersion,
+		XS:           xsNamespace,
+		XSI:          xsiNamespace,
+		SAML:         samlNamespace,
+		IssueInstant: response.IssueInstant,
+		Issuer:       idp.issuer(),
+		Subject: Subject{
+			XMLName: xml.Name{Local: "saml:Subject"},
+			NameID: NameID{
+				XMLName: xml.Name{Local: "saml:NameID"},
+				Format:  format,
+				Value:   escapeText(principal.NameID),
+			},
+			SubjectConfirmation: SubjectConfirmation{
+				XMLName: xml.Name{Local: "saml:SubjectConfirmation"},
+				Method:  subjectConfirmationBearer,
+				SubjectConfirmationData: SubjectConfirmationData{
+					XMLName:      xml.Name{Local: "saml:SubjectConfirmationData"},
+					InResponseTo: request.Request.ID,
+					NotOnOrAfter: notOnOrAfter,
+					Recipient:    recipient,
+				},
+			},
+		},
+		Conditions: Conditions{
+			XMLName:      xml.Name{Local: "saml:Conditions"},
+			NotBefore:    response.IssueInstant,
+			NotOnOrAfter: notOnOrAfter,
+			AudienceRestrictions: []AudienceRestriction{
+				{
+					XMLName:   xml.Name{Local: "saml:AudienceRestriction"},
+					Audiences: []string{request.ServiceProvider.EntityID},
+				},
+			},
+		},
+		AuthnStatement: AuthnStatement{
+			XMLName:      xml.Name{Local: "saml:AuthnStatement"},
+			AuthnInstant: NewDateTime(authnInstant),
+			SessionIndex: principal.SessionIndex,
+			AuthnContext: AuthnContext{
+				XMLName: xml.Name{Local: "saml:AuthnContext"},
+				AuthnContextClassRef: AuthnContextClassRef{
+					XMLName:   xml.Name{Local: "saml:AuthnContextClassRef"},
+					Transport: escapeText(classRef),
+				},
+			},
+		},
+		AttributeStatement: newAttributeStatement(principal.Attributes),
+	}
+	return response, nil
+}
+
+// NewErrorResponse returns a Response to request that reports a failure, for example
+// NewErrorResponse(request, thisInstant, Responder, NoPassive) if the user must log in but the
+// request IsPassive. The response does not contain an assertion.
+func (idp *IdentityProvider) NewErrorResponse(request *ReceivedAuthnRequest, thisInstant time.Time, code int, subCode ...int) (*Response, error) {
+	return idp.newResponse(request, thisInstant, newStatus(code, subCode...))
+}
+
+func (idp *IdentityProvider) newResponse(request *ReceivedAuthnRequest, thisInstant time.Time, status Status) (*Response, error) {
+	responseID, err := getUniqueID()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting id for response")
+	}
+	return &Response{
+		XMLName: xml.Name{
+			Local: "samlp:Response",
+		},
+		SAMLP: samlProtocalNamespace,
+		SAML:  samlNamespace,
+		// IDs are prefixed because an xs:ID must not start with a digit
+		ID:           "_" + responseID,
+		Version:      samlVersion,
+		IssueInstant: NewDateTime(thisInstant),
+		Destination:  request.AssertionConsumerService.Location,
+		InResponseTo: request.Request.ID,
+		Issuer:       idp.issuer(),
+		Status:       status,
+	}, nil
+}
+
+// ResponsePostForm signs the response and, if required, encrypts its assertion, returning the
+// form that delivers it to the assertion consumer service of the request. The assertion is signed
+// if ResponseSignatures includes WantAssertionsSigned or the service provider metadata says that
+// WantAssertionsSigned. Responses with a status other than success are sent without an assertion,
+// so they only need SigningKey if ResponseSignatures includes WantResponseSigned.
+// The session established by a successful response is recorded in Sessions.
+func (idp *IdentityProvider) ResponsePostForm(request *ReceivedAuthnRequest, response *Response) (*PostForm, error) {
+	encoded, err := idp.encodeResponse(response, request.ServiceProvider)
+	if err != nil {
+		return nil, err
+	}
+	if idp.Sessions != nil && isStatusSuccess(response.Status.StatusCode.Value) {
+		nameID := response.Assertion.Subject.NameID
+		nameID.XMLName = xml.Name{Local: "saml:NameID"}
+		err = idp.Sessions.AddSession(&ParticipantSession{
+			ServiceProvider: request.ServiceProvider.EntityID,
+			NameID:          nameID,
+			SessionIndex:    response.Assertion.AuthnStatement.SessionIndex,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "recording session")
+		}
+	}
+	return postBindingForm(request.AssertionConsumerService.Location, ResponseQueryKey, encoded, request.RelayState), nil
+}
+
+func (idp *IdentityProvider) encodeResponse(response *Response, sp *SPEntityDescriptor) (*bytes.Buffer, error) {
+	var encoded bytes.Buffer
+	err := xml.NewEncoder(&encoded).Encode(response)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding response")
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(encoded.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "reading encoded response")
+	}
+	root := doc.Root()
+	qualify(root)
+	assertion := root.SelectElement(assertionTag)
+	policy := idp.responseSignatures()
+	success := isStatusSuccess(response.Status.StatusCode.Value)
+	signAssertion := success && (policy&WantAssertionsSigned != 0 || sp.SPSSODescriptor.WantAssertionsSigned)
+	signResponse := policy&WantResponseSigned != 0
+	// the signing key is only needed if something is signed, error responses carry no assertion
+	var context *dsig.SigningContext
+	if signAssertion || signResponse {
+		context, err = idp.getSigningContext()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !success {
+		root.RemoveChild(assertion)
+	} else {
+		if signAssertion {
+			signed, err := signEnveloped(context, assertion)
+			if err != nil {
+				return nil, err
+			}
+			replaceChild(root, assertion, signed)
+			assertion = signed
+		}
+		if idp.EncryptAssertions {
+			encrypted, err := idp.encryptAssertion(assertion, sp)
+			if err != nil {
+				return nil, err
+			}
+			replaceChild(root, assertion, encrypted)
+		}
+	}
+	if signResponse {
+		root, err = signEnveloped(context, root)
+		if err != nil {
+			return nil, err
+		}
+		doc.SetRoot(root)
+	}
+	var signed bytes.Buffer
+	_, err = doc.WriteTo(&signed)
+	if err != nil {
+		return nil, errors.Wrap(err, "writing signed response")
+	}
+	return &signed, nil
+}
+
+// encryptAssertion returns an EncryptedAssertion containing assertion encrypted for sp.
+func (idp *IdentityProvider) encryptAssertion(assertion *etree.Element, sp *SPEntityDescriptor) (*etree.Element, error) {
+	cert, err := encryptionCertificate(sp.SPSSODescriptor.KeyDescriptors)
+	if err != nil {
+		return nil, err
+	}
+	method := idp.EncryptionMethod
+	if method == "" {
+		method = EncryptionMethodAES256GCM
+	}
+	data, err := encryptElement(assertion, cert, method)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypting assertion")
+	}
+	encrypted := etree.NewElement("saml:" + encryptedAssertionTag)
+	encrypted.CreateAttr("xmlns:saml", samlNamespace)
+	encrypted.AddChild(data)
+	return encrypted, nil
+}
+
+func (idp *IdentityProvider) getSigningContext() (*dsig.SigningContext, error) {
+	if idp.SigningKey == nil {
+		return nil, ErrIDPSigningNotConfigured
+	}
+	context, err := newSigningContext(idp.SigningKey, idp.SigningCertificate, idp.SignatureMethod)
+	if err != nil {
+		return nil, err
+	}
+	// assertions are signed inside the response, so namespaces declared on the response must
+	// not be part of their signatures
+	context.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	return context, nil
+}
+
+func (idp *IdentityProvider) issuer() Issuer {
+	return Issuer{
+		XMLName: xml.Name{
+			Local: "saml:Issuer",
+		},
+		Url: escapeText(idp.EntityID),
+	}
+}
+
+func (idp *IdentityProvider) assertionLifetime() time.Duration {
+	if idp.AssertionLifetime == 0 {
+		return DefaultAssertionLifetime
+	}
+	return idp.AssertionLifetime
+}
+
+func (idp *IdentityProvider) responseSignatures() SignaturePolicy {
+	if idp.ResponseSignatures == 0 {
+		return WantAssertionsSigned
+	}
+	return idp.ResponseSignatures
+}
+
+// newAttributeStatement returns an AttributeStatement with string values, or nil if there are no
+// attributes. Attributes are sorted by name.
+func newAttributeStatement(attributes map[string][]string) *AttributeStatement {
+	if len(attributes) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statement := &AttributeStatement{
+		XMLName: xml.Name{Local: "saml:AttributeStatement"},
+	}
+	for _, name := range names {
+		attribute := Attribute{
+			XMLName:    xml.Name{Local: "saml:Attribute"},
+			Name:       name,
+			NameFormat: AttributeNameFormatBasic,
+		}
+		for _, value := range attributes[name] {
+			attribute.AttributeValues = append(attribute.AttributeValues, AttributeValue{
+				XMLName: xml.Name{Local: "saml:AttributeValue"},
+				Type:    "xs:string",
+				Value:   escapeText(value),
+			})
+		}
+		statement.Attributes = append(statement.Attributes, attribute)
+	}
+	return statement
+}
+
+// escapeText escapes s for use in fields encoded as innerxml.
+func escapeText(s string) string {
+	var buff bytes.Buffer
+	xml.EscapeText(&buff, []byte(s))
+	return buff.String()
+}
+
+// qualify adds the prefix of its parent to each element without a prefix. Fields of the SAML types
+// such as Audience are encoded without a prefix, and so without a namespace.
+func qualify(el *etree.Element) {
+	for _, child := range el.ChildElements() {
+		if child.Space == "" {
+			child.Space = el.Space
+		}
+		qualify(child)
+	}
+}
+
+// replaceChild replaces the child old of parent with new.
+func replaceChild(parent, old, new *etree.Element) {
+	parent.InsertChildAt(old.Index(), new)
+	parent.RemoveChild(old)
+}
@@ -0,0 +1,208 @@
+package saml
+
+import (
+	"crypto"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getResponseTest returns an IDP and the profile of a service provider that trusts it, along with
+// an AuthnRequest received by the IDP from the service provider.
+func getResponseTest(t *testing.T, thisInstant time.Time, spOpts ...func() interface{}) (*IdentityProvider, *SingleSignOnProfile, *ReceivedAuthnRequest) {
+	signer := getRSAServiceProvider(t)
+	sp := getRSAServiceProvider(t)
+	sp.AssertionConsumerServiceURL = "https://sp.example.com/acs"
+	sp.DecryptionKey = sp.SigningKey.(crypto.Decrypter)
+	sp.EncryptionCertificate = sp.SigningCertificate
+	idp := getTestIdentityProvider(sp)
+	idp.SigningKey = signer.SigningKey
+	idp.SigningCertificate = signer.SigningCertificate
+
+	descriptor := &IDPSSODescriptor{
+		KeyDescriptors: []KeyDescriptor{newKeyDescriptor(KeyUseSigning, signer.SigningCertificate)},
+	}
+	profile := NewSingleSignOnProfile(sp, descriptor, spOpts...)
+
+	r := getRedirectAuthnRequest(t, sp, getTestAuthnRequest(sp, thisInstant))
+	received, err := idp.HandleAuthnRequest(r, thisInstant)
+	require.Nil(t, err)
+	return idp, profile, received
+}
+
+func getTestPrincipal() *Principal {
+	return &Principal{
+		NameID:       "john@kolide.co",
+		NameIDFormat: NameIDEmail,
+		SessionIndex: "session-1",
+		Attributes: map[string][]string{
+			"groups": {"admins", "r&d"},
+			"name":   {"John"},
+		},
+	}
+}
+
+func postResponse(t *testing.T, idp *IdentityProvider, request *ReceivedAuthnRequest, response *Response) (string, []byte) {
+	form, err := idp.ResponsePostForm(request, response)
+	require.Nil(t, err)
+	assert.Equal(t, "https://sp.example.com/acs", form.URL)
+	assert.Equal(t, "state", form.Values.Get(RelayStateQueryKey))
+	encoded := form.Values.Get(ResponseQueryKey)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	require.Nil(t, err)
+	return encoded, decoded
+}
+
+func TestNewResponse(t *testing.T) {
+	thisInstant := time.Now()
+	idp, profile, request := getResponseTest(t, thisInstant)
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	encoded, decoded := postResponse(t, idp, request, response)
+
+	doc := etree.NewDocument()
+	require.Nil(t, doc.ReadFromBytes(decoded))
+	assertion := doc.Root().SelectElement("Assertion")
+	require.NotNil(t, assertion)
+	assert.Equal(t, samlNamespace, assertion.NamespaceURI())
+	assert.NotNil(t, assertion.SelectElement("Signature"))
+	assert.Nil(t, doc.Root().SelectElement("Signature"))
+	audience := assertion.FindElement("Conditions/AudienceRestriction/Audience")
+	require.NotNil(t, audience)
+	assert.Equal(t, samlNamespace, audience.NamespaceURI())
+	values := assertion.FindElements("AttributeStatement/Attribute[@Name='groups']/AttributeValue")
+	require.Len(t, values, 2)
+	assert.Equal(t, "r&d", values[1].Text())
+
+	cbr, err := profile.HandlePostResponse(encoded, thisInstant)
+	require.Nil(t, err)
+	assert.Equal(t, "john@kolide.co", cbr.Identity.UserID)
+	assert.Equal(t, "https://sp.example.com/acs", cbr.Identity.Recipient)
+
+	// the assertion must not be accepted once it expires
+	_, err = profile.HandlePostResponse(encoded, thisInstant.Add(DefaultAssertionLifetime+DefaultClockSkew))
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrExpired))
+}
+
+func TestNewResponseSignedResponse(t *testing.T) {
+	thisInstant := time.Now()
+	idp, profile, request := getResponseTest(t, thisInstant, WithSignaturePolicy(WantBothSigned))
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+
+	encoded, _ := postResponse(t, idp, request, response)
+	_, err = profile.HandlePostResponse(encoded, thisInstant)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrBadSignature))
+
+	idp.ResponseSignatures = WantBothSigned
+	encoded, _ = postResponse(t, idp, request, response)
+	_, err = profile.HandlePostResponse(encoded, thisInstant)
+	require.Nil(t, err)
+}
+
+func TestNewResponseEncrypted(t *testing.T) {
+	thisInstant := time.Now()
+	for _, method := range []string{EncryptionMethodAES128CBC, EncryptionMethodAES256GCM} {
+		idp, profile, request := getResponseTest(t, thisInstant, WithCBCDecryption(true))
+		idp.EncryptAssertions = true
+		idp.EncryptionMethod = method
+		response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+		require.Nil(t, err)
+
+		encoded, decoded := postResponse(t, idp, request, response)
+		assert.NotContains(t, string(decoded), "john@kolide.co", method)
+		assert.Contains(t, string(decoded), "EncryptedAssertion", method)
+		cbr, err := profile.HandlePostResponse(encoded, thisInstant)
+		require.Nil(t, err, method)
+		assert.Equal(t, "john@kolide.co", cbr.Identity.UserID, method)
+	}
+}
+
+func TestNewResponseEncryptedCBCNotAllowed(t *testing.T) {
+	thisInstant := time.Now()
+	idp, profile, request := getResponseTest(t, thisInstant)
+	idp.EncryptAssertions = true
+	idp.EncryptionMethod = EncryptionMethodAES256CBC
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+
+	encoded, _ := postResponse(t, idp, request, response)
+	_, err = profile.HandlePostResponse(encoded, thisInstant)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
+}
+
+func TestNewResponseEncryptedWithoutDecryptionKey(t *testing.T) {
+	thisInstant := time.Now()
+	idp, profile, request := getResponseTest(t, thisInstant)
+	idp.EncryptAssertions = true
+	profile.serviceProvder.DecryptionKey = nil
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+
+	encoded, _ := postResponse(t, idp, request, response)
+	_, err = profile.HandlePostResponse(encoded, thisInstant)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrDecryptionNotConfigured))
+}
+
+func TestNewResponseEncryptedNoCertificate(t *testing.T) {
+	thisInstant := time.Now()
+	idp, _, request := getResponseTest(t, thisInstant)
+	idp.EncryptAssertions = true
+	request.ServiceProvider.SPSSODescriptor.KeyDescriptors = nil
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	_, err = idp.ResponsePostForm(request, response)
+	assert.Equal(t, ErrNoEncryptionCertificate, err)
+}
+
+func TestNewErrorResponse(t *testing.T) {
+	thisInstant := time.Now()
+	idp, profile, request := getResponseTest(t, thisInstant)
+	response, err := idp.NewErrorResponse(request, thisInstant, Responder, NoPassive)
+	require.Nil(t, err)
+
+	encoded, decoded := postResponse(t, idp, request, response)
+	assert.NotContains(t, string(decoded), "Assertion")
+	_, err = profile.HandlePostResponse(encoded, thisInstant)
+	require.NotNil(t, err)
+	var se *StatusError
+	require.True(t, errors.As(err, &se))
+	assert.True(t, se.HasCode(NoPassive))
+}
+
+func TestResponseWithoutSigningKey(t *testing.T) {
+	thisInstant := time.Now()
+	idp, _, request := getResponseTest(t, thisInstant)
+	idp.SigningKey = nil
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	_, err = idp.ResponsePostForm(request, response)
+	assert.Equal(t, ErrIDPSigningNotConfigured, err)
+}
+
+func TestErrorResponseWithoutSigningKey(t *testing.T) {
+	thisInstant := time.Now()
+	idp, _, request := getResponseTest(t, thisInstant)
+	idp.SigningKey = nil
+	response, err := idp.NewErrorResponse(request, thisInstant, Responder)
+	require.Nil(t, err)
+	form, err := idp.ResponsePostForm(request, response)
+	require.Nil(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(form.Values.Get(ResponseQueryKey))
+	require.Nil(t, err)
+	assert.NotContains(t, string(decoded), "Signature")
+
+	// the response itself must still be signed if the policy says so
+	idp.ResponseSignatures = WantResponseSigned
+	_, err = idp.ResponsePostForm(request, response)
+	assert.Equal(t, ErrIDPSigningNotConfigured, err)
+}
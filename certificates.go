@@ -0,0 +1,271 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	// KeyUseSigning is the KeyDescriptor use of keys that sign messages.
+	KeyUseSigning = "signing"
+	// KeyUseEncryption is the KeyDescriptor use of keys that encrypt messages.
+	KeyUseEncryption = "encryption"
+)
+
+var (
+	// ErrNoSigningCertificate occurs when the metadata of the IDP, or of a service provider, does
+	// not contain a certificate that can be used to verify signatures.
+	ErrNoSigningCertificate = errors.New("no usable signing certificate")
+)
+
+// CertificateExpiryFunc is called with an IDP signing certificate that expires within the
+// warning period supplied to WithCertificateExpiryWarning. Remaining is negative if the
+// certificate has already expired.
+type CertificateExpiryFunc func(cert *x509.Certificate, remaining time.Duration)
+
+type certificateValidity bool
+
+// WithCertificateValidity is an optional parameter to NewSingleSignOnProfile and NewSingleLogOutProfile.
+// By default signatures made with IDP certificates outside their NotBefore and NotAfter dates are
+// rejected. If enforce is false certificates from metadata are trusted regardless of their dates,
+// this should only be used while an IDP that does not rotate expired certificates is fixed.
+func WithCertificateValidity(enforce bool) func() interface{} {
+	return func() interface{} {
+		return certificateValidity(enforce)
+	}
+}
+
+type certificateExpiryWarning struct {
+	within time.Duration
+	warn   CertificateExpiryFunc
+}
+
+// WithCertificateExpiryWarning is an optional parameter to NewSingleSignOnProfile and NewSingleLogOutProfile.
+// Each time a message from the IDP is handled, warn is called for every IDP signing certificate that
+// expires within the given duration, so that the IDP can be asked to rotate it before logins fail.
+func WithCertificateExpiryWarning(within time.Duration, warn CertificateExpiryFunc) func() interface{} {
+	return func() interface{} {
+		return certificateExpiryWarning{within: within, warn: warn}
+	}
+}
+
+// certificatePolicy determines which IDP certificates are trusted. The zero value only trusts
+// certificates within their validity dates.
+type certificatePolicy struct {
+	ignoreValidity bool
+	expiryWarning  certificateExpiryWarning
+}
+
+// signatureValidator validates XML signatures made with any of the signing certificates of an entity.
+type signatureValidator struct {
+	certs []*x509.Certificate
+	// thisInstant is the time certificate dates are checked at, it is zero if they are not checked.
+	thisInstant time.Time
+}
+
+// newSignatureValidator returns a validator that trusts the signing certificates in keys allowed by
+// the policy at thisInstant.
+func (p certificatePolicy) newSignatureValidator(keys []KeyDescriptor, thisInstant time.Time) (*signatureValidator, error) {
+	certs, err := signingCertificates(keys)
+	if err != nil {
+		return nil, err
+	}
+	validator := &signatureValidator{}
+	if !p.ignoreValidity {
+		validator.thisInstant = thisInstant
+	}
+	for _, cert := range certs {
+		if cert.NotAfter.IsZero() {
+			// raw public keys have no validity period
+			validator.certs = append(validator.certs, cert)
+			continue
+		}
+		remaining := cert.NotAfter.Sub(thisInstant)
+		if p.expiryWarning.warn != nil && remaining < p.expiryWarning.within {
+			p.expiryWarning.warn(cert, remaining)
+		}
+		if !p.ignoreValidity && (thisInstant.Before(cert.NotBefore) || thisInstant.After(cert.NotAfter)) {
+			continue
+		}
+		validator.certs = append(validator.certs, cert)
+	}
+	if len(validator.certs) == 0 {
+		return nil, ErrNoSigningCertificate
+	}
+	return validator, nil
+}
+
+// Validate validates the enveloped signature of el, returning the signed content. Each trusted key
+// is tried in turn, which allows for IDP key rollover. Keys are only taken from metadata so KeyInfo
+// in the signature is removed before validation. Unless the policy ignores certificate dates
+// goxmldsig checks them again at the time the validator was created.
+func (v *signatureValidator) Validate(el *etree.Element) (*etree.Element, error) {
+	el = el.Copy()
+	for _, signature := range el.ChildElements() {
+		if signature.Tag == dsig.SignatureTag && signature.NamespaceURI() == dsig.Namespace {
+			if keyInfo := signature.SelectElement(dsig.KeyInfoTag); keyInfo != nil {
+				signature.RemoveChild(keyInfo)
+			}
+			err := asn1SignatureValue(signature)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	var err error
+	for _, cert := range v.certs {
+		context := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+			Roots: []*x509.Certificate{cert},
+		})
+		// raw public keys have no validity period, nor do certificates if dates are ignored
+		at := v.thisInstant
+		if at.IsZero() || cert.NotAfter.IsZero() {
+			at = cert.NotBefore
+		}
+		context.Clock = dsig.NewFakeClockAt(at)
+		var validated *etree.Element
+		validated, err = context.Validate(el)
+		if err == nil || err == dsig.ErrMissingSignature {
+			return validated, err
+		}
+	}
+	return nil, err
+}
+
+// asn1SignatureValue rewrites the SignatureValue of an ECDSA signature, the concatenation of r and
+// s, to the ASN.1 form goxmldsig verifies. The SignatureValue is not covered by the signature.
+func asn1SignatureValue(signature *etree.Element) error {
+	method := signature.FindElement("SignedInfo/SignatureMethod")
+	value := signature.SelectElement("SignatureValue")
+	if method == nil || value == nil || signatureKeyAlgorithms[method.SelectAttrValue("Algorithm", "")] != x509.ECDSA {
+		return nil
+	}
+	raw, err := decodeBase64(value.Text())
+	if err != nil {
+		return errors.Wrap(err, "decoding SignatureValue")
+	}
+	encoded, err := asn1ECDSASignature(raw)
+	if err != nil {
+		return err
+	}
+	value.SetText(base64.StdEncoding.EncodeToString(encoded))
+	return nil
+}
+
+// signingCertificates returns the certificates and public keys found in key descriptors that may
+// be used for signing. Keys without a use may be used for signing and encryption. Raw public keys
+// are returned as certificates without validity dates.
+func signingCertificates(keys []KeyDescriptor) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, key := range keys {
+		if key.Use != "" && key.Use != KeyUseSigning {
+			continue
+		}
+		keyCerts, err := key.KeyInfo.certificates()
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, keyCerts...)
+	}
+	return certs, nil
+}
+
+// encryptionCertificate returns the first certificate with an RSA key in key descriptors that
+// may be used for encryption. Keys without a use may be used for signing and encryption.
+func encryptionCertificate(keys []KeyDescriptor) (*x509.Certificate, error) {
+	for _, key := range keys {
+		if key.Use != "" && key.Use != KeyUseEncryption {
+			continue
+		}
+		certs, err := key.KeyInfo.certificates()
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			if _, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+				return cert, nil
+			}
+		}
+	}
+	return nil, ErrNoEncryptionCertificate
+}
+
+// certificates parses every certificate and public key in the KeyInfo.
+func (ki *KeyInfo) certificates() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, encoded := range ki.X509Data.X509Certificates {
+		certData, err := decodeBase64(encoded.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding x509 cert")
+		}
+		cert, err := x509.ParseCertificate(certData)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing x509 cert")
+		}
+		certs = append(certs, cert)
+	}
+	if ki.KeyValue != nil {
+		publicKey, err := ki.KeyValue.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, &x509.Certificate{PublicKey: publicKey})
+	}
+	return certs, nil
+}
+
+var namedCurves = map[string]elliptic.Curve{
+	"urn:oid:1.2.840.10045.3.1.7": elliptic.P256(),
+	"urn:oid:1.3.132.0.34":        elliptic.P384(),
+	"urn:oid:1.3.132.0.35":        elliptic.P521(),
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+}
+
+// publicKey returns the RSA or EC public key in the KeyValue.
+func (kv *KeyValue) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case kv.RSAKeyValue != nil:
+		modulus, err := decodeBase64(kv.RSAKeyValue.Modulus)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding RSA modulus")
+		}
+		exponent, err := decodeBase64(kv.RSAKeyValue.Exponent)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding RSA exponent")
+		}
+		e := new(big.Int).SetBytes(exponent)
+		if len(modulus) == 0 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key value")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(e.Int64())}, nil
+	case kv.ECKeyValue != nil:
+		curve, ok := namedCurves[kv.ECKeyValue.NamedCurve.URI]
+		if !ok {
+			return nil, errors.Errorf("unsupported named curve %q", kv.ECKeyValue.NamedCurve.URI)
+		}
+		point, err := decodeBase64(kv.ECKeyValue.PublicKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding EC public key")
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("invalid EC key value")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported key value")
+}
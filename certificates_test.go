@@ -0,0 +1,220 @@
+package saml
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getCertificate(t *testing.T, notBefore, notAfter time.Time) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "uri:myidp",
+		},
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert
+}
+
+func TestIDPCertificatesKeyUse(t *testing.T) {
+	now := time.Now()
+	signing := getKeyDescriptor(getCertificate(t, now, now.Add(time.Hour)))
+	unspecified := getKeyDescriptor(getCertificate(t, now, now.Add(time.Hour)))
+	unspecified.Use = ""
+	encryption := getKeyDescriptor(getCertificate(t, now, now.Add(time.Hour)))
+	encryption.Use = KeyUseEncryption
+
+	certs, err := signingCertificates([]KeyDescriptor{signing, unspecified, encryption})
+	require.Nil(t, err)
+	assert.Len(t, certs, 2)
+
+	_, err = certificatePolicy{}.newSignatureValidator([]KeyDescriptor{encryption}, now)
+	assert.Equal(t, ErrNoSigningCertificate, errors.Cause(err))
+}
+
+func TestSignatureValidatorValidity(t *testing.T) {
+	now := time.Now()
+	idp := &IDPSSODescriptor{
+		KeyDescriptors: []KeyDescriptor{
+			getKeyDescriptor(getCertificate(t, now.Add(-2*time.Hour), now.Add(-time.Hour))),
+			getKeyDescriptor(getCertificate(t, now.Add(-time.Hour), now.Add(time.Hour))),
+			getKeyDescriptor(getCertificate(t, now.Add(time.Hour), now.Add(2*time.Hour))),
+		},
+	}
+	validator, err := certificatePolicy{}.newSignatureValidator(idp.KeyDescriptors, now)
+	require.Nil(t, err)
+	require.Len(t, validator.certs, 1)
+	assert.Equal(t, now.Add(time.Hour).Unix(), validator.certs[0].NotAfter.Unix())
+
+	validator, err = certificatePolicy{ignoreValidity: true}.newSignatureValidator(idp.KeyDescriptors, now)
+	require.Nil(t, err)
+	assert.Len(t, validator.certs, 3)
+}
+
+func TestCertificateExpiryWarning(t *testing.T) {
+	now := time.Now()
+	expiring := getCertificate(t, now.Add(-time.Hour), now.Add(24*time.Hour))
+	idp := &IDPSSODescriptor{
+		KeyDescriptors: []KeyDescriptor{
+			getKeyDescriptor(expiring),
+			getKeyDescriptor(getCertificate(t, now.Add(-time.Hour), now.Add(90*24*time.Hour))),
+		},
+	}
+	var warned []*x509.Certificate
+	var remaining time.Duration
+	policy := certificatePolicy{
+		expiryWarning: certificateExpiryWarning{
+			within: 30 * 24 * time.Hour,
+			warn: func(cert *x509.Certificate, r time.Duration) {
+				warned = append(warned, cert)
+				remaining = r
+			},
+		},
+	}
+	_, err := policy.newSignatureValidator(idp.KeyDescriptors, now)
+	require.Nil(t, err)
+	require.Len(t, warned, 1)
+	assert.Equal(t, expiring.Raw, warned[0].Raw)
+	assert.True(t, remaining > 23*time.Hour && remaining <= 24*time.Hour)
+}
+
+func TestSignatureValidatorExpiredCertificate(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	raw := getSignedAuthResponse(t, idp, true, false)
+	profile := getSigningIDPProfile(idp)
+	_, _, err := profile.validateSignature(raw, time.Now().Add(2*time.Hour))
+	assert.Equal(t, ErrNoSigningCertificate, errors.Cause(err))
+
+	profile = getSigningIDPProfile(idp, WithCertificateValidity(false))
+	_, _, err = profile.validateSignature(raw, time.Now().Add(2*time.Hour))
+	assert.Nil(t, err)
+}
+
+func TestSignatureValidatorValidateDates(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	doc := etree.NewDocument()
+	require.Nil(t, doc.ReadFromBytes(getSignedAuthResponse(t, idp, false, true)))
+	certs := []*x509.Certificate{idp.SigningCertificate}
+
+	_, err := (&signatureValidator{certs: certs, thisInstant: time.Now()}).Validate(doc.Root())
+	assert.Nil(t, err)
+	// dates are checked again when the signature is validated
+	_, err = (&signatureValidator{certs: certs, thisInstant: time.Now().Add(2 * time.Hour)}).Validate(doc.Root())
+	assert.NotNil(t, err)
+	_, err = (&signatureValidator{certs: certs}).Validate(doc.Root())
+	assert.Nil(t, err)
+}
+
+func TestKeyInfoMultipleCertificates(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	old := getCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	descriptor := getKeyDescriptor(old)
+	current := base64.StdEncoding.EncodeToString(idp.SigningCertificate.Raw)
+	// certificates in metadata are frequently wrapped and indented
+	var wrapped string
+	for len(current) > 64 {
+		wrapped += current[:64] + "\n    "
+		current = current[64:]
+	}
+	descriptor.KeyInfo.X509Data.X509Certificates = append(descriptor.KeyInfo.X509Data.X509Certificates, X509Certificate{Data: wrapped + current})
+
+	profile := NewSingleSignOnProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, &IDPSSODescriptor{
+		KeyDescriptors: []KeyDescriptor{descriptor},
+	})
+	validator, err := profile.getSignatureValidator(time.Now())
+	require.Nil(t, err)
+	assert.Len(t, validator.certs, 2)
+	_, _, err = profile.validateSignature(getSignedAuthResponse(t, idp, true, false), time.Now())
+	assert.Nil(t, err)
+}
+
+func getKeyValueDescriptor(kv *KeyValue) KeyDescriptor {
+	return KeyDescriptor{
+		Use: KeyUseSigning,
+		KeyInfo: KeyInfo{
+			KeyNames: []string{"idp key"},
+			KeyValue: kv,
+		},
+	}
+}
+
+func TestKeyInfoRSAKeyValue(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	key := idp.SigningKey.Public().(*rsa.PublicKey)
+	descriptor := getKeyValueDescriptor(&KeyValue{
+		RSAKeyValue: &RSAKeyValue{
+			Modulus:  base64.StdEncoding.EncodeToString(key.N.Bytes()),
+			Exponent: base64.StdEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		},
+	})
+	raw, err := xml.Marshal(descriptor)
+	require.Nil(t, err)
+	var parsed KeyDescriptor
+	err = xml.Unmarshal(raw, &parsed)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"idp key"}, parsed.KeyInfo.KeyNames)
+	require.NotNil(t, parsed.KeyInfo.KeyValue)
+
+	profile := NewSingleSignOnProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, &IDPSSODescriptor{
+		KeyDescriptors: []KeyDescriptor{parsed},
+	})
+	_, _, err = profile.validateSignature(getSignedAuthResponse(t, idp, true, false), time.Now())
+	assert.Nil(t, err)
+
+	other := getRSAServiceProvider(t)
+	_, _, err = profile.validateSignature(getSignedAuthResponse(t, other, true, false), time.Now())
+	assert.NotNil(t, err)
+}
+
+func TestKeyInfoECKeyValue(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	idp := getSigningServiceProvider(t, key)
+	descriptor := getKeyValueDescriptor(&KeyValue{
+		ECKeyValue: &ECKeyValue{
+			NamedCurve: NamedCurve{URI: "urn:oid:1.2.840.10045.3.1.7"},
+			PublicKey:  base64.StdEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), key.X, key.Y)),
+		},
+	})
+	raw, err := xml.Marshal(descriptor)
+	require.Nil(t, err)
+	var parsed KeyDescriptor
+	err = xml.Unmarshal(raw, &parsed)
+	require.Nil(t, err)
+
+	profile := NewSingleSignOnProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, &IDPSSODescriptor{
+		KeyDescriptors: []KeyDescriptor{parsed},
+	})
+	_, _, err = profile.validateSignature(getSignedAuthResponse(t, idp, true, false), time.Now())
+	assert.Nil(t, err)
+}
+
+func TestKeyValueInvalid(t *testing.T) {
+	_, err := (&KeyValue{}).publicKey()
+	assert.NotNil(t, err)
+	_, err = (&KeyValue{ECKeyValue: &ECKeyValue{NamedCurve: NamedCurve{URI: "urn:oid:1.2.3"}}}).publicKey()
+	assert.NotNil(t, err)
+	_, err = (&KeyValue{RSAKeyValue: &RSAKeyValue{Modulus: "AQAB", Exponent: ""}}).publicKey()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/beevik/etree"
+	"github.com/murphybytes/saml"
+	"github.com/pkg/errors"
+)
+
+const decodeUsage = `usage: samltool decode [-summary | -xml] [message]
+
+The message may be a SAMLRequest or SAMLResponse value, base64 encoded or deflated and base64
+encoded, a redirect URL containing one, or a captured form body such as SAMLResponse=...&RelayState=...
+If message is omitted or is "-" it is read from stdin.
+`
+
+// capturedMessage is a SAML message extracted from the input, with the parameters that were
+// sent alongside it.
+type capturedMessage struct {
+	// Parameter is SAMLRequest or SAMLResponse, or empty if the input was a bare message.
+	Parameter  string
+	XML        string
+	RelayState string
+	SigAlg     string
+	// QuerySigned is true if the message was sent with a redirect binding signature.
+	QuerySigned bool
+}
+
+func runDecode(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	summaryOnly := flags.Bool("summary", false, "print only the summary")
+	xmlOnly := flags.Bool("xml", false, "print only the XML")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), decodeUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	input, err := readInput(flags.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+	msg, err := extractMessage(input)
+	if err != nil {
+		return err
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromString(msg.XML)
+	if err != nil {
+		return errors.Wrap(err, "parsing message")
+	}
+	if doc.Root() == nil {
+		return errors.New("message is empty")
+	}
+	if !*summaryOnly {
+		doc.Indent(2)
+		_, err = doc.WriteTo(stdout)
+		if err != nil {
+			return errors.Wrap(err, "writing message")
+		}
+	}
+	if !*xmlOnly {
+		if !*summaryOnly {
+			fmt.Fprintln(stdout)
+		}
+		return summarize(stdout, msg, doc.Root())
+	}
+	return nil
+}
+
+// readInput returns arg, or the content of stdin if arg is empty or "-".
+func readInput(arg string, stdin io.Reader) (string, error) {
+	if arg != "" && arg != "-" {
+		return arg, nil
+	}
+	input, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return "", errors.Wrap(err, "reading stdin")
+	}
+	return string(input), nil
+}
+
+// extractMessage finds and decodes the SAML message in input, which may be a URL, a form body
+// or the message itself.
+func extractMessage(input string) (*capturedMessage, error) {
+	input = strings.TrimSpace(input)
+	var values url.Values
+	switch {
+	case strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://"):
+		u, err := url.Parse(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing URL")
+		}
+		values = u.Query()
+	case strings.Contains(input, saml.RequestQueryKey+"=") || strings.Contains(input, saml.ResponseQueryKey+"="):
+		var err error
+		values, err = url.ParseQuery(strings.TrimPrefix(input, "?"))
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing form")
+		}
+	default:
+		encoded := input
+		if strings.Contains(encoded, "%") {
+			// a value copied from a URL or form body, '+' is left alone because it is far more
+			// likely to be part of the base64 encoding than an escaped space
+			unescaped, err := url.PathUnescape(encoded)
+			if err != nil {
+				return nil, errors.Wrap(err, "unescaping message")
+			}
+			encoded = unescaped
+		}
+		decoded, err := decode(encoded)
+		if err != nil {
+			return nil, err
+		}
+		return &capturedMessage{XML: decoded}, nil
+	}
+
+	msg := &capturedMessage{
+		RelayState:  values.Get(saml.RelayStateQueryKey),
+		SigAlg:      values.Get(saml.SigAlgQueryKey),
+		QuerySigned: values.Get(saml.SignatureQueryKey) != "",
+	}
+	for _, key := range []string{saml.ResponseQueryKey, saml.RequestQueryKey} {
+		if values.Get(key) != "" {
+			msg.Parameter = key
+			break
+		}
+	}
+	if msg.Parameter == "" {
+		return nil, errors.New("input does not contain a SAMLRequest or SAMLResponse")
+	}
+	var err error
+	msg.XML, err = decode(values.Get(msg.Parameter))
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// decode decodes a message value, restoring '+' characters that were turned into spaces when
+// an unescaped value was parsed as a form.
+func decode(encoded string) (string, error) {
+	encoded = strings.Replace(strings.TrimSpace(encoded), " ", "+", -1)
+	decoded, err := saml.DecodeMessage(encoded)
+	if err != nil {
+		return "", errors.Wrap(err, "decoding message")
+	}
+	return decoded, nil
+}
+
+// summarize writes the parts of a message that matter when diagnosing a failed login.
+func summarize(w io.Writer, msg *capturedMessage, root *etree.Element) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	line := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", label, value)
+		}
+	}
+	line("Message", root.Tag)
+	line("Parameter", msg.Parameter)
+	line("ID", root.SelectAttrValue("ID", ""))
+	line("IssueInstant", root.SelectAttrValue("IssueInstant", ""))
+	line("Destination", root.SelectAttrValue("Destination", ""))
+	line("InResponseTo", root.SelectAttrValue("InResponseTo", ""))
+	line("Issuer", childText(root, "Issuer"))
+	line("RelayState", msg.RelayState)
+	line("Signature", signatureSummary(msg, root))
+
+	switch root.Tag {
+	case "AuthnRequest":
+		line("AssertionConsumerServiceURL", root.SelectAttrValue("AssertionConsumerServiceURL", ""))
+		line("ProtocolBinding", root.SelectAttrValue("ProtocolBinding", ""))
+		if policy := root.SelectElement("NameIDPolicy"); policy != nil {
+			line("NameIDPolicy", policy.SelectAttrValue("Format", ""))
+		}
+	case "LogoutRequest":
+		line("NameID", nameIDSummary(root))
+		for _, index := range root.SelectElements("SessionIndex") {
+			line("SessionIndex", index.Text())
+		}
+	case "Assertion":
+		summarizeAssertion(line, root)
+	}
+	if status := root.SelectElement("Status"); status != nil {
+		var codes []string
+		for code := status.SelectElement("StatusCode"); code != nil; code = code.SelectElement("StatusCode") {
+			codes = append(codes, code.SelectAttrValue("Value", ""))
+		}
+		line("Status", strings.Join(codes, " / "))
+		line("StatusMessage", childText(status, "StatusMessage"))
+	}
+	for _, assertion := range root.SelectElements("Assertion") {
+		fmt.Fprintln(tw)
+		line("Assertion", assertion.SelectAttrValue("ID", ""))
+		line("Issuer", childText(assertion, "Issuer"))
+		if assertion.SelectElement("Signature") != nil {
+			line("Signature", "assertion is signed")
+		} else {
+			line("Signature", "assertion is not signed")
+		}
+		summarizeAssertion(line, assertion)
+	}
+	for range root.SelectElements("EncryptedAssertion") {
+		fmt.Fprintln(tw)
+		line("Assertion", "encrypted, the contents can not be shown")
+	}
+	return tw.Flush()
+}
+
+// summarizeAssertion writes the subject, conditions and attributes of an assertion.
+func summarizeAssertion(line func(label, value string), assertion *etree.Element) {
+	if subject := assertion.SelectElement("Subject"); subject != nil {
+		line("NameID", nameIDSummary(subject))
+		if data := subject.FindElement("SubjectConfirmation/SubjectConfirmationData"); data != nil {
+			line("Recipient", data.SelectAttrValue("Recipient", ""))
+			line("SubjectInResponseTo", data.SelectAttrValue("InResponseTo", ""))
+			line("SubjectNotOnOrAfter", data.SelectAttrValue("NotOnOrAfter", ""))
+		}
+	}
+	if conditions := assertion.SelectElement("Conditions"); conditions != nil {
+		line("NotBefore", conditions.SelectAttrValue("NotBefore", ""))
+		line("NotOnOrAfter", conditions.SelectAttrValue("NotOnOrAfter", ""))
+		for _, audience := range conditions.FindElements("AudienceRestriction/Audience") {
+			line("Audience", audience.Text())
+		}
+	}
+	if statement := assertion.SelectElement("AuthnStatement"); statement != nil {
+		line("AuthnInstant", statement.SelectAttrValue("AuthnInstant", ""))
+		line("SessionIndex", statement.SelectAttrValue("SessionIndex", ""))
+		line("SessionNotOnOrAfter", statement.SelectAttrValue("SessionNotOnOrAfter", ""))
+		if classRef := statement.FindElement("AuthnContext/AuthnContextClassRef"); classRef != nil {
+			line("AuthnContext", strings.TrimSpace(classRef.Text()))
+		}
+	}
+	for _, attribute := range assertion.FindElements("AttributeStatement/Attribute") {
+		var values []string
+		for _, value := range attribute.SelectElements("AttributeValue") {
+			values = append(values, value.Text())
+		}
+		line("Attribute", fmt.Sprintf("%s = %s", attribute.SelectAttrValue("Name", ""), strings.Join(values, ", ")))
+	}
+}
+
+func signatureSummary(msg *capturedMessage, root *etree.Element) string {
+	var signatures []string
+	if msg.QuerySigned {
+		signatures = append(signatures, "query signed with "+msg.SigAlg)
+	}
+	if root.SelectElement("Signature") != nil {
+		signatures = append(signatures, strings.ToLower(root.Tag)+" is signed")
+	}
+	if len(signatures) == 0 {
+		return strings.ToLower(root.Tag) + " is not signed"
+	}
+	return strings.Join(signatures, ", ")
+}
+
+func nameIDSummary(parent *etree.Element) string {
+	nameID := parent.SelectElement("NameID")
+	if nameID == nil {
+		if parent.SelectElement("EncryptedID") != nil {
+			return "encrypted"
+		}
+		return ""
+	}
+	if format := nameID.SelectAttrValue("Format", ""); format != "" {
+		return fmt.Sprintf("%s (%s)", strings.TrimSpace(nameID.Text()), format)
+	}
+	return strings.TrimSpace(nameID.Text())
+}
+
+func childText(parent *etree.Element, tag string) string {
+	child := parent.SelectElement(tag)
+	if child == nil {
+		return ""
+	}
+	return strings.TrimSpace(child.Text())
+}
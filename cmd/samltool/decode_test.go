@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/murphybytes/saml/generated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const authnRequest = `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_request" Version="2.0" IssueInstant="2017-06-11T20:29:27Z" Destination="https://idp.example.com/sso" AssertionConsumerServiceURL="https://sp.example.com/acs"><saml:Issuer>https://sp.example.com</saml:Issuer><samlp:NameIDPolicy Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"/></samlp:AuthnRequest>`
+
+func deflated(t *testing.T, message string) string {
+	var buff bytes.Buffer
+	w, err := flate.NewWriter(&buff, flate.DefaultCompression)
+	require.Nil(t, err)
+	_, err = w.Write([]byte(message))
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+	return base64.StdEncoding.EncodeToString(buff.Bytes())
+}
+
+func TestDecodeResponse(t *testing.T) {
+	buff, err := generated.Asset("test_data/authresponse")
+	require.Nil(t, err)
+	escaped := strings.TrimSpace(string(buff))
+
+	inputs := map[string]string{
+		"value": escaped,
+		"form":  "SAMLResponse=" + escaped + "&RelayState=%2Fhome",
+	}
+	for name, input := range inputs {
+		var out bytes.Buffer
+		err = runDecode([]string{input}, nil, &out)
+		require.Nil(t, err, name)
+		assert.Contains(t, out.String(), "<samlp:Response", name)
+		assert.Regexp(t, `Message:\s+Response`, out.String(), name)
+		assert.Regexp(t, `NameID:\s+john@kolide.co`, out.String(), name)
+		assert.Contains(t, out.String(), "Status:", name)
+	}
+}
+
+func TestDecodeRedirect(t *testing.T) {
+	values := url.Values{}
+	values.Set("SAMLRequest", deflated(t, authnRequest))
+	values.Set("RelayState", "/home")
+	location := "https://idp.example.com/sso?" + values.Encode()
+
+	var out bytes.Buffer
+	err := runDecode([]string{"-summary"}, strings.NewReader(location), &out)
+	require.Nil(t, err)
+	summary := out.String()
+	assert.NotContains(t, summary, "<samlp:AuthnRequest")
+	assert.Regexp(t, `Message:\s+AuthnRequest`, summary)
+	assert.Regexp(t, `Parameter:\s+SAMLRequest`, summary)
+	assert.Regexp(t, `Issuer:\s+https://sp.example.com`, summary)
+	assert.Regexp(t, `RelayState:\s+/home`, summary)
+	assert.Regexp(t, `AssertionConsumerServiceURL:\s+https://sp.example.com/acs`, summary)
+	assert.Regexp(t, `Signature:\s+authnrequest is not signed`, summary)
+
+	out.Reset()
+	err = runDecode([]string{"-xml", deflated(t, authnRequest)}, nil, &out)
+	require.Nil(t, err)
+	assert.Contains(t, out.String(), "<samlp:AuthnRequest")
+	assert.NotContains(t, out.String(), "Message:")
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	inputs := map[string]string{
+		"not base64":    "not a saml message!",
+		"missing value": "RelayState=%2Fhome&SAMLRequest=",
+		"not xml":       base64.StdEncoding.EncodeToString([]byte("plain text")),
+	}
+	for name, input := range inputs {
+		err := runDecode([]string{input}, nil, &bytes.Buffer{})
+		assert.NotNil(t, err, name)
+	}
+}
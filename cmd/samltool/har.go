@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/murphybytes/saml/har"
+	"github.com/pkg/errors"
+)
+
+const harUsage = `usage: samltool har [file.har]
+
+Finds the SAML messages in a HAR file exported from the developer tools of a browser and prints
+a timeline of the flow, linking requests to their responses and noting problems found in each
+message. If the file is omitted or is "-" it is read from stdin.
+`
+
+func runHAR(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("har", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), harUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	input := stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "opening HAR")
+		}
+		defer file.Close()
+		input = file
+	}
+	messages, err := har.Analyze(input)
+	if err != nil {
+		return err
+	}
+	return har.WriteTimeline(stdout, messages)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHAR(t *testing.T) {
+	f, _ := getValidateTest(t)
+	encoded, err := f.Response(validateInstant)
+	require.Nil(t, err)
+	form := url.Values{}
+	form.Set("SAMLResponse", encoded)
+	trace, err := json.Marshal(map[string]interface{}{
+		"log": map[string]interface{}{
+			"entries": []interface{}{
+				map[string]interface{}{
+					"startedDateTime": validateInstant.Format(time.RFC3339),
+					"request": map[string]interface{}{
+						"method": "POST",
+						"url":    "https://sp.example.com/acs",
+						"postData": map[string]interface{}{
+							"mimeType": "application/x-www-form-urlencoded",
+							"text":     form.Encode(),
+						},
+					},
+				},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	var out bytes.Buffer
+	err = runHAR(nil, bytes.NewReader(trace), &out)
+	require.Nil(t, err)
+	assert.Contains(t, out.String(), "[1] 2017-06-11T20:29:27.000Z POST https://sp.example.com/acs (form)")
+	assert.Contains(t, out.String(), "! InResponseTo _request does not match a request in the trace")
+
+	err = runHAR([]string{"missing.har"}, nil, &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const keygenUsage = `usage: samltool keygen [-type rsa|ecdsa] [-cn name] [-days n] [-out prefix]
+
+Generates a private key and a self signed certificate for a service provider, written to
+<prefix>.key and <prefix>.crt. Existing files are not overwritten. Only RSA keys can decrypt
+assertions that the IDP encrypts.
+`
+
+func runKeygen(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	keyType := flags.String("type", "rsa", "key type: rsa or ecdsa")
+	bits := flags.Int("bits", 2048, "size of RSA keys in bits")
+	commonName := flags.String("cn", "SAML Service Provider", "common name of the certificate subject")
+	days := flags.Int("days", 3650, "number of days the certificate is valid")
+	out := flags.String("out", "sp", "prefix of the files written")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), keygenUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *days <= 0 {
+		return errors.New("-days must be positive")
+	}
+	key, err := generateKey(*keyType, *bits)
+	if err != nil {
+		return err
+	}
+	certDER, err := selfSignedCertificate(key, *commonName, time.Now(), time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return errors.Wrap(err, "encoding private key")
+	}
+
+	keyPath, certPath := *out+".key", *out+".crt"
+	err = writePEM(keyPath, 0600, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err != nil {
+		return err
+	}
+	err = writePEM(certPath, 0644, &pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "wrote %s key to %s and certificate to %s\n", *keyType, keyPath, certPath)
+	return nil
+}
+
+func generateKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa":
+		if bits < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, errors.Wrap(err, "generating RSA key")
+		}
+		return key, nil
+	case "ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "generating ECDSA key")
+		}
+		return key, nil
+	}
+	return nil, errors.Errorf("unknown key type %q", keyType)
+}
+
+// selfSignedCertificate returns a DER encoded certificate for key valid from notBefore for validFor.
+func selfSignedCertificate(key crypto.Signer, commonName string, notBefore time.Time, validFor time.Duration) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, errors.Wrap(err, "generating serial number")
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		NotBefore:             notBefore.Add(-time.Minute),
+		NotAfter:              notBefore.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating certificate")
+	}
+	return der, nil
+}
+
+// writePEM writes block to a new file at path.
+func writePEM(path string, perm os.FileMode, block *pem.Block) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return errors.Wrap(err, "creating file")
+	}
+	err = pem.Encode(file, block)
+	if err != nil {
+		file.Close()
+		return errors.Wrapf(err, "writing %s", path)
+	}
+	return file.Close()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/murphybytes/saml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tempDir returns a directory that is removed when the test completes.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "samltool")
+	require.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestKeygen(t *testing.T) {
+	dir := tempDir(t)
+	for _, keyType := range []string{"rsa", "ecdsa"} {
+		prefix := filepath.Join(dir, keyType)
+		err := runKeygen([]string{"-type", keyType, "-cn", "sp.example.com", "-days", "30", "-out", prefix}, nil, &bytes.Buffer{})
+		require.Nil(t, err, keyType)
+
+		pemBytes, err := ioutil.ReadFile(prefix + ".key")
+		require.Nil(t, err)
+		key, err := saml.ParsePrivateKeyPEM(pemBytes)
+		require.Nil(t, err)
+		cert, err := readCertificate(prefix + ".crt")
+		require.Nil(t, err)
+		assert.Equal(t, "sp.example.com", cert.Subject.CommonName)
+		assert.Equal(t, key.Public(), cert.PublicKey)
+		switch keyType {
+		case "rsa":
+			assert.IsType(t, &rsa.PublicKey{}, cert.PublicKey)
+		case "ecdsa":
+			assert.IsType(t, &ecdsa.PublicKey{}, cert.PublicKey)
+		}
+
+		info, err := os.Stat(prefix + ".key")
+		require.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// existing keys are not overwritten
+	err := runKeygen([]string{"-out", filepath.Join(dir, "rsa")}, nil, &bytes.Buffer{})
+	assert.NotNil(t, err)
+	err = runKeygen([]string{"-type", "dsa", "-out", filepath.Join(dir, "dsa")}, nil, &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
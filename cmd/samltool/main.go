@@ -0,0 +1,85 @@
+// Command samltool inspects SAML messages locally, so that messages containing personal
+// information do not need to be pasted into third party websites when diagnosing a failed login.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const exitNonSuccess = 1
+
+// command is a samltool subcommand. Commands read input that is not supplied as an argument
+// from stdin, and write their results to stdout.
+type command struct {
+	summary string
+	run     func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"decode": {
+		summary: "decode, pretty-print and summarize a SAML message",
+		run:     runDecode,
+	},
+	"har": {
+		summary: "print a timeline of the SAML messages in a HAR file",
+		run:     runHAR,
+	},
+	"keygen": {
+		summary: "generate a service provider key and self signed certificate",
+		run:     runKeygen,
+	},
+	"lint": {
+		summary: "check IDP metadata for problems that prevent logins",
+		run:     runLint,
+	},
+	"sp-metadata": {
+		summary: "write the metadata of a service provider",
+		run:     runSPMetadata,
+	},
+	"validate": {
+		summary: "validate a SAMLResponse against IDP metadata and report every check",
+		run:     runValidate,
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(exitNonSuccess)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "samltool: unknown command %q\n", name)
+		usage(os.Stderr)
+		os.Exit(exitNonSuccess)
+	}
+	err := cmd.run(os.Args[2:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "samltool %s: %v\n", name, err)
+		os.Exit(exitNonSuccess)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: samltool <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "samltool <command> -h" for the arguments of a command.`)
+}
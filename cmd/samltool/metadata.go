@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/murphybytes/saml"
+	"github.com/pkg/errors"
+)
+
+const spMetadataUsage = `usage: samltool sp-metadata -entity id -acs url [-slo url] [-cert file] [flags]
+
+Writes the metadata of a service provider to stdout, for the IDP administrator to import.
+`
+
+const lintUsage = `usage: samltool lint [-at time] idp.xml
+
+Checks that service providers using this package can log in with the IDP described by the
+metadata, reporting missing signing keys, expired certificates and unsupported bindings.
+`
+
+func runSPMetadata(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("sp-metadata", flag.ContinueOnError)
+	entityID := flags.String("entity", "", "entity ID of the service provider (required)")
+	acsURL := flags.String("acs", "", "assertion consumer service URL (required)")
+	sloURL := flags.String("slo", "", "single logout service URL")
+	certPath := flags.String("cert", "", "PEM certificate of the signing key")
+	encryptionCertPath := flags.String("encryption-cert", "", "PEM certificate of the decryption key, defaults to -cert if it is an RSA certificate")
+	nameIDFormat := flags.String("nameid-format", "", "NameID format requested from the IDP")
+	signatures := flags.String("signatures", "assertion", "signatures required: assertion, response or both")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), spMetadataUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *entityID == "" || *acsURL == "" {
+		return errors.New("-entity and -acs are required")
+	}
+	policy, ok := signaturePolicies[*signatures]
+	if !ok {
+		return errors.Errorf("unknown signature policy %q", *signatures)
+	}
+	sp := &saml.ServiceProvider{
+		IssuerURI:                   *entityID,
+		AssertionConsumerServiceURL: *acsURL,
+		SingleLogoutServiceURL:      *sloURL,
+	}
+	if *nameIDFormat != "" {
+		sp.NameIDFormats = []string{*nameIDFormat}
+	}
+	if *certPath != "" {
+		sp.SigningCertificate, err = readCertificate(*certPath)
+		if err != nil {
+			return err
+		}
+		if _, ok := sp.SigningCertificate.PublicKey.(*rsa.PublicKey); ok && *encryptionCertPath == "" {
+			sp.EncryptionCertificate = sp.SigningCertificate
+		}
+	}
+	if *encryptionCertPath != "" {
+		sp.EncryptionCertificate, err = readCertificate(*encryptionCertPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	metadata := saml.NewSingleSignOnProfile(sp, &saml.IDPSSODescriptor{}, saml.WithSignaturePolicy(policy)).Metadata()
+	encoded, err := xml.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding metadata")
+	}
+	fmt.Fprint(stdout, xml.Header)
+	_, err = stdout.Write(encoded)
+	if err != nil {
+		return errors.Wrap(err, "writing metadata")
+	}
+	fmt.Fprintln(stdout)
+	return nil
+}
+
+func runLint(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	at := flags.String("at", "", "RFC 3339 time to check certificates at, defaults to now")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), lintUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("the metadata file is required")
+	}
+	thisInstant := time.Now()
+	if *at != "" {
+		thisInstant, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return errors.Wrap(err, "parsing -at")
+		}
+	}
+	metadata, err := saml.GetMetadataFromFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	problems := saml.LintMetadata(metadata, thisInstant)
+	fatal := 0
+	for _, problem := range problems {
+		fmt.Fprintln(stdout, problem)
+		if problem.Fatal {
+			fatal++
+		}
+	}
+	if fatal > 0 {
+		return errors.Errorf("%d errors found in %s", fatal, flags.Arg(0))
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(stdout, "no problems found")
+	}
+	return nil
+}
+
+// readCertificate reads the first certificate in a PEM file.
+func readCertificate(path string) (*x509.Certificate, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading certificate")
+	}
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			return nil, errors.Errorf("no certificate found in %s", path)
+		}
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing certificate in %s", path)
+			}
+			return cert, nil
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/murphybytes/saml"
+	"github.com/murphybytes/saml/generated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPMetadata(t *testing.T) {
+	dir := tempDir(t)
+	prefix := filepath.Join(dir, "sp")
+	require.Nil(t, runKeygen([]string{"-out", prefix}, nil, &bytes.Buffer{}))
+
+	var out bytes.Buffer
+	err := runSPMetadata([]string{
+		"-entity", "https://sp.example.com",
+		"-acs", "https://sp.example.com/acs",
+		"-slo", "https://sp.example.com/slo",
+		"-cert", prefix + ".crt",
+		"-nameid-format", saml.NameIDEmail,
+	}, nil, &out)
+	require.Nil(t, err)
+
+	var metadata saml.SPEntityDescriptor
+	require.Nil(t, xml.Unmarshal(out.Bytes(), &metadata))
+	assert.Equal(t, "https://sp.example.com", metadata.EntityID)
+	descriptor := metadata.SPSSODescriptor
+	assert.True(t, descriptor.WantAssertionsSigned)
+	require.Len(t, descriptor.AssertionConsumerServices, 1)
+	assert.Equal(t, "https://sp.example.com/acs", descriptor.AssertionConsumerServices[0].Location)
+	assert.Len(t, descriptor.SingleLogoutService, 2)
+	require.Len(t, descriptor.KeyDescriptors, 2)
+	assert.Equal(t, saml.KeyUseSigning, descriptor.KeyDescriptors[0].Use)
+	assert.Equal(t, saml.KeyUseEncryption, descriptor.KeyDescriptors[1].Use)
+	require.Len(t, descriptor.NameIDFormats, 1)
+	assert.Equal(t, saml.NameIDEmail, descriptor.NameIDFormats[0].Value)
+
+	err = runSPMetadata([]string{"-entity", "https://sp.example.com"}, nil, &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
+
+func TestLint(t *testing.T) {
+	buff, err := generated.Asset("test_data/metadata.xml")
+	require.Nil(t, err)
+	path := filepath.Join(tempDir(t), "idp.xml")
+	require.Nil(t, ioutil.WriteFile(path, buff, 0600))
+
+	var out bytes.Buffer
+	err = runLint([]string{"-at", "2018-01-01T00:00:00Z", path}, nil, &out)
+	require.Nil(t, err)
+	assert.Contains(t, out.String(), "warning: SingleSignOnService binding urn:oasis:names:tc:SAML:2.0:bindings:SOAP is not used")
+
+	out.Reset()
+	// logins fail once the only signing certificate has expired
+	err = runLint([]string{"-at", "2023-01-01T00:00:00Z", path}, nil, &out)
+	require.NotNil(t, err)
+	assert.Contains(t, out.String(), "expired at 2022-04-18T22:40:18Z")
+	assert.Contains(t, out.String(), "error: no signing certificate is currently valid")
+
+	// metadata without signing keys can not be used
+	require.Nil(t, ioutil.WriteFile(path, []byte(`<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com">
+<IDPSSODescriptor><SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso"/></IDPSSODescriptor>
+</EntityDescriptor>`), 0600))
+	out.Reset()
+	err = runLint([]string{path}, nil, &out)
+	require.NotNil(t, err)
+	assert.Contains(t, out.String(), "error: no signing keys")
+}
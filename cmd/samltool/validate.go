@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+
+	"github.com/murphybytes/saml"
+	"github.com/pkg/errors"
+)
+
+const validateUsage = `usage: samltool validate -metadata idp.xml -sp-entity id [-acs url] [-at time] [flags] [response]
+
+Validates a SAMLResponse as the service provider does when it is posted to the assertion
+consumer service, and reports the outcome of every check. The response may be given in any of
+the forms accepted by decode. If it is omitted or is "-" it is read from stdin. Flags must
+precede the response.
+`
+
+// signaturePolicies maps the values of the -signatures flag to policies.
+var signaturePolicies = map[string]saml.SignaturePolicy{
+	"assertion": saml.WantAssertionsSigned,
+	"response":  saml.WantResponseSigned,
+	"both":      saml.WantBothSigned,
+}
+
+func runValidate(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	metadataPath := flags.String("metadata", "", "path of the IDP metadata (required)")
+	entityID := flags.String("sp-entity", "", "entity ID of the service provider (required)")
+	acsURL := flags.String("acs", "", "assertion consumer service URL of the service provider")
+	at := flags.String("at", "", "RFC 3339 time to validate the response at, defaults to now")
+	keyPath := flags.String("key", "", "path of the PEM private key that decrypts assertions")
+	signatures := flags.String("signatures", "assertion", "signatures required: assertion, response or both")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), validateUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *metadataPath == "" || *entityID == "" {
+		return errors.New("-metadata and -sp-entity are required")
+	}
+	policy, ok := signaturePolicies[*signatures]
+	if !ok {
+		return errors.Errorf("unknown signature policy %q", *signatures)
+	}
+	thisInstant := time.Now()
+	if *at != "" {
+		thisInstant, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return errors.Wrap(err, "parsing -at")
+		}
+	}
+	metadata, err := saml.GetMetadataFromFile(*metadataPath)
+	if err != nil {
+		return err
+	}
+	sp := &saml.ServiceProvider{
+		IssuerURI:                   *entityID,
+		AssertionConsumerServiceURL: *acsURL,
+	}
+	if *keyPath != "" {
+		pemBytes, err := ioutil.ReadFile(*keyPath)
+		if err != nil {
+			return errors.Wrap(err, "reading key")
+		}
+		key, err := saml.ParsePrivateKeyPEM(pemBytes)
+		if err != nil {
+			return err
+		}
+		decrypter, ok := key.(crypto.Decrypter)
+		if !ok {
+			return errors.New("key can not decrypt assertions, an RSA key is required")
+		}
+		sp.DecryptionKey = decrypter
+	}
+
+	input, err := readInput(flags.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+	msg, err := extractMessage(input)
+	if err != nil {
+		return err
+	}
+	if msg.Parameter == saml.RequestQueryKey {
+		return errors.New("input is a SAMLRequest, validate checks responses")
+	}
+	profile := saml.NewSingleSignOnProfile(sp, &metadata.IDPSSODescriptor,
+		saml.WithSignaturePolicy(policy), saml.WithIDPEntityID(metadata.EntityID))
+	checks := profile.DiagnosePostResponse(base64.StdEncoding.EncodeToString([]byte(msg.XML)), thisInstant)
+	return report(stdout, checks, thisInstant)
+}
+
+// report writes the outcome of each check, it returns an error if any check failed.
+func report(w io.Writer, checks []saml.Check, thisInstant time.Time) error {
+	fmt.Fprintf(w, "Validated at %s\n\n", thisInstant.UTC().Format(time.RFC3339))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	failed := 0
+	for _, check := range checks {
+		outcome, reason := "PASS", ""
+		if !check.Passed() {
+			outcome, reason = "FAIL", check.Err.Error()
+			failed++
+		}
+		if check.Unverified {
+			reason += " (checked on unverified content)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", outcome, check.Name, reason)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	fmt.Fprintln(w, "\nThe response is valid.")
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/saml"
+	"github.com/murphybytes/saml/samltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var validateInstant = time.Date(2017, 6, 11, 20, 29, 27, 0, time.UTC)
+
+// getValidateTest returns fixtures for a service provider and the arguments that validate
+// responses sent to it at validateInstant, using the IDP metadata written to a temporary file.
+func getValidateTest(t *testing.T) (*samltest.Fixtures, []string) {
+	f, err := samltest.NewFixtures(&saml.ServiceProvider{
+		IssuerURI:                   "https://sp.example.com",
+		AssertionConsumerServiceURL: "https://sp.example.com/acs",
+	})
+	require.Nil(t, err)
+	metadata, err := f.IdentityProvider.EncodeMetadata(false, time.Now())
+	require.Nil(t, err)
+	path := filepath.Join(tempDir(t), "idp.xml")
+	require.Nil(t, ioutil.WriteFile(path, metadata, 0600))
+	return f, []string{
+		"-metadata", path,
+		"-sp-entity", "https://sp.example.com",
+		"-acs", "https://sp.example.com/acs",
+		"-at", validateInstant.Format(time.RFC3339),
+	}
+}
+
+func TestValidate(t *testing.T) {
+	f, args := getValidateTest(t)
+	encoded, err := f.Response(validateInstant)
+	require.Nil(t, err)
+
+	var out bytes.Buffer
+	err = runValidate(args, strings.NewReader("SAMLResponse="+encoded), &out)
+	require.Nil(t, err)
+	for _, check := range []string{saml.CheckSignature, saml.CheckTiming, saml.CheckIssuer, saml.CheckAudience, saml.CheckRecipient, saml.CheckStatus} {
+		assert.Regexp(t, `PASS\s+`+check, out.String())
+	}
+	assert.NotContains(t, out.String(), "FAIL")
+}
+
+func TestValidateFailures(t *testing.T) {
+	f, args := getValidateTest(t)
+	encoded, err := f.Response(validateInstant, func(r *saml.Response) {
+		r.Assertion.Conditions.AudienceRestrictions[0].Audiences = []string{"uri:other"}
+	})
+	require.Nil(t, err)
+
+	// validated an hour later the response has also expired
+	args[len(args)-1] = validateInstant.Add(time.Hour).Format(time.RFC3339)
+	var out bytes.Buffer
+	err = runValidate(append(args, encoded), nil, &out)
+	require.NotNil(t, err)
+	assert.Equal(t, "2 of 7 checks failed", err.Error())
+	assert.Regexp(t, `PASS\s+signature`, out.String())
+	assert.Regexp(t, `FAIL\s+timing\s+expired`, out.String())
+	assert.Regexp(t, `FAIL\s+audience\s+wrong audience: expected "https://sp.example.com" got "uri:other"`, out.String())
+	assert.Regexp(t, `PASS\s+recipient`, out.String())
+}
+
+func TestValidateArguments(t *testing.T) {
+	_, args := getValidateTest(t)
+	tests := map[string][]string{
+		"missing metadata": {"-sp-entity", "https://sp.example.com", "x"},
+		"bad time":         append(append([]string{}, args...), "-at", "yesterday", "x"),
+		"bad policy":       append(append([]string{}, args...), "-signatures", "none", "x"),
+	}
+	for name, args := range tests {
+		err := runValidate(args, nil, &bytes.Buffer{})
+		assert.NotNil(t, err, name)
+	}
+}
@@ -0,0 +1,71 @@
+package saml
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Layouts accepted when parsing xs:dateTime values. SAML requires UTC but IDPs vary in how they
+// express it, ADFS and Azure AD for example use seven digit fractional seconds. Values without a
+// timezone are treated as UTC.
+var dateTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// DateTime is an xs:dateTime value used for SAML timestamps such as IssueInstant and NotOnOrAfter.
+// A zero DateTime represents an optional timestamp that is not present, it is omitted when encoded.
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf Section 1.3.3
+type DateTime struct {
+	time.Time
+}
+
+// NewDateTime returns a DateTime for t in UTC.
+func NewDateTime(t time.Time) DateTime {
+	return DateTime{t.UTC()}
+}
+
+// ParseDateTime parses an xs:dateTime with optional fractional seconds and timezone. An empty
+// value returns a zero DateTime.
+func ParseDateTime(value string) (DateTime, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return DateTime{}, nil
+	}
+	for _, layout := range dateTimeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return NewDateTime(t), nil
+		}
+	}
+	return DateTime{}, errors.Errorf("invalid xs:dateTime %q", value)
+}
+
+// String formats the time in UTC, fractional seconds are only included if present.
+func (dt DateTime) String() string {
+	if dt.IsZero() {
+		return ""
+	}
+	return dt.UTC().Format(time.RFC3339Nano)
+}
+
+// MarshalXMLAttr implements xml.MarshalerAttr, zero values are omitted.
+func (dt DateTime) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	if dt.IsZero() {
+		return xml.Attr{}, nil
+	}
+	return xml.Attr{Name: name, Value: dt.String()}, nil
+}
+
+// UnmarshalXMLAttr implements xml.UnmarshalerAttr.
+func (dt *DateTime) UnmarshalXMLAttr(attr xml.Attr) error {
+	parsed, err := ParseDateTime(attr.Value)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", attr.Name.Local)
+	}
+	*dt = parsed
+	return nil
+}
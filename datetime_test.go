@@ -0,0 +1,68 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDateTime(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected time.Time
+	}{
+		{"2017-05-29T00:06:42Z", time.Date(2017, 5, 29, 0, 6, 42, 0, time.UTC)},
+		// ADFS and Azure AD
+		{"2017-05-29T00:06:42.1234567Z", time.Date(2017, 5, 29, 0, 6, 42, 123456700, time.UTC)},
+		{"2017-05-29T00:06:42.123Z", time.Date(2017, 5, 29, 0, 6, 42, 123000000, time.UTC)},
+		{"2017-05-29T02:06:42+02:00", time.Date(2017, 5, 29, 0, 6, 42, 0, time.UTC)},
+		{"2017-05-28T19:06:42.5-05:00", time.Date(2017, 5, 29, 0, 6, 42, 500000000, time.UTC)},
+		{"2017-05-29T00:06:42", time.Date(2017, 5, 29, 0, 6, 42, 0, time.UTC)},
+		{" 2017-05-29T00:06:42Z\n", time.Date(2017, 5, 29, 0, 6, 42, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		dt, err := ParseDateTime(tt.value)
+		require.Nil(t, err, tt.value)
+		assert.True(t, tt.expected.Equal(dt.Time), tt.value)
+		assert.Equal(t, time.UTC, dt.Location())
+	}
+
+	dt, err := ParseDateTime("")
+	require.Nil(t, err)
+	assert.True(t, dt.IsZero())
+
+	_, err = ParseDateTime("29/05/2017")
+	assert.NotNil(t, err)
+}
+
+func TestDateTimeString(t *testing.T) {
+	assert.Equal(t, "2017-05-29T00:06:42Z", NewDateTime(time.Date(2017, 5, 29, 0, 6, 42, 0, time.UTC)).String())
+	assert.Equal(t, "2017-05-29T00:06:42.25Z", NewDateTime(time.Date(2017, 5, 29, 0, 6, 42, 250000000, time.UTC)).String())
+	offset := time.FixedZone("CDT", -5*60*60)
+	assert.Equal(t, "2017-05-29T00:06:42Z", NewDateTime(time.Date(2017, 5, 28, 19, 6, 42, 0, offset)).String())
+	assert.Equal(t, "", DateTime{}.String())
+}
+
+func TestDateTimeXML(t *testing.T) {
+	var conditions Conditions
+	conditions.XMLName.Local = "Conditions"
+	conditions.NotOnOrAfter = NewDateTime(time.Date(2017, 5, 29, 0, 6, 42, 0, time.UTC))
+	var buff bytes.Buffer
+	err := xml.NewEncoder(&buff).Encode(conditions)
+	require.Nil(t, err)
+	// zero values are omitted
+	assert.Equal(t, `<Conditions NotOnOrAfter="2017-05-29T00:06:42Z"></Conditions>`, buff.String())
+
+	var decoded Conditions
+	err = xml.Unmarshal([]byte(`<Conditions NotBefore="2017-05-29T00:03:42.1234567Z"/>`), &decoded)
+	require.Nil(t, err)
+	assert.Equal(t, 123456700, decoded.NotBefore.Nanosecond())
+	assert.True(t, decoded.NotOnOrAfter.IsZero())
+
+	err = xml.Unmarshal([]byte(`<Conditions NotBefore="yesterday"/>`), &decoded)
+	assert.NotNil(t, err)
+}
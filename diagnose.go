@@ -0,0 +1,76 @@
+package saml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Names of the checks reported by DiagnosePostResponse, in the order they are performed.
+const (
+	CheckDecode    = "decode"
+	CheckStatus    = "status"
+	CheckSignature = "signature"
+	CheckTiming    = "timing"
+	CheckIssuer    = "issuer"
+	CheckAudience  = "audience"
+	CheckRecipient = "recipient"
+)
+
+// Check is the outcome of one of the checks HandlePostResponse performs on a response.
+type Check struct {
+	// Name is one of the Check* constants.
+	Name string
+	// Err is the reason the check failed, or nil if it passed.
+	Err error
+	// Unverified is true if the check was performed on content whose signature could not be
+	// verified. HandlePostResponse would not get as far as this check.
+	Unverified bool
+}
+
+// Passed returns true if the check passed.
+func (c Check) Passed() bool {
+	return c.Err == nil
+}
+
+// DiagnosePostResponse performs the checks of HandlePostResponse on samlResponse at
+// thisInstant and returns the outcome of each, rather than stopping at the first failure.
+// It is intended for diagnosing failed logins, the identity in a response must only be
+// trusted if HandlePostResponse accepts it. The assertion is not recorded as used, so
+// responses that HandlePostResponse would reject as a replay pass every check.
+//
+// If the signature can not be verified the remaining checks are performed on the unverified
+// content of the response. If the response can not be decoded only the decode check is
+// returned.
+func (sp *SingleSignOnProfile) DiagnosePostResponse(samlResponse string, thisInstant time.Time) []Check {
+	decoded, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		err = newValidationError(ReasonMalformed, errors.Wrap(err, "decoding saml response"))
+		return []Check{{Name: CheckDecode, Err: err}}
+	}
+	var unverified Response
+	err = xml.Unmarshal(decoded, &unverified)
+	if err != nil {
+		err = newValidationError(ReasonMalformed, errors.Wrap(err, "decoding post response xml"))
+		return []Check{{Name: CheckDecode, Err: err}}
+	}
+	checks := []Check{
+		{Name: CheckDecode},
+		{Name: CheckStatus, Err: checkStatus(unverified.Status)},
+	}
+	response, err := sp.verifyResponse(decoded, thisInstant)
+	checks = append(checks, Check{Name: CheckSignature, Err: err})
+	if err != nil {
+		response = &unverified
+	}
+	unverifiedContent := err != nil
+	checks = append(checks,
+		Check{Name: CheckTiming, Err: sp.validateTiming(response, thisInstant), Unverified: unverifiedContent},
+		Check{Name: CheckIssuer, Err: sp.validateIssuer(response), Unverified: unverifiedContent},
+		Check{Name: CheckAudience, Err: sp.validateAudience(response), Unverified: unverifiedContent},
+		Check{Name: CheckRecipient, Err: sp.validateRecipient(response), Unverified: unverifiedContent},
+	)
+	return checks
+}
@@ -0,0 +1,78 @@
+package saml
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkErrors returns the error of each check by name.
+func checkErrors(checks []Check) map[string]error {
+	errs := map[string]error{}
+	for _, check := range checks {
+		errs[check.Name] = check.Err
+	}
+	return errs
+}
+
+func TestDiagnosePostResponse(t *testing.T) {
+	thisInstant := time.Now()
+	idp, profile, request := getResponseTest(t, thisInstant)
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	encoded, _ := postResponse(t, idp, request, response)
+
+	checks := profile.DiagnosePostResponse(encoded, thisInstant)
+	require.Len(t, checks, 7)
+	for _, check := range checks {
+		assert.True(t, check.Passed(), check.Name)
+		assert.False(t, check.Unverified, check.Name)
+	}
+	// diagnosis does not use up the assertion
+	_, err = profile.HandlePostResponse(encoded, thisInstant)
+	assert.Nil(t, err)
+
+	// every failing check is reported, not only the first
+	later := thisInstant.Add(DefaultAssertionLifetime + DefaultClockSkew)
+	response, err = idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	response.Assertion.Conditions.AudienceRestrictions[0].Audiences = []string{"uri:other"}
+	encoded, _ = postResponse(t, idp, request, response)
+	errs := checkErrors(profile.DiagnosePostResponse(encoded, later))
+	assert.Nil(t, errs[CheckSignature])
+	assert.True(t, errors.Is(errs[CheckTiming], ErrExpired))
+	assert.Nil(t, errs[CheckIssuer])
+	assert.True(t, errors.Is(errs[CheckAudience], ErrWrongAudience))
+	assert.Nil(t, errs[CheckRecipient])
+}
+
+func TestDiagnosePostResponseUnverified(t *testing.T) {
+	thisInstant := time.Now()
+	idp, profile, request := getResponseTest(t, thisInstant)
+	// the profile does not trust the key of this IDP
+	other, _, _ := getResponseTest(t, thisInstant)
+	idp.SigningKey = other.SigningKey
+	idp.SigningCertificate = other.SigningCertificate
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	response.Destination = "https://other.example.com/acs"
+	encoded, _ := postResponse(t, idp, request, response)
+
+	checks := profile.DiagnosePostResponse(encoded, thisInstant)
+	errs := checkErrors(checks)
+	assert.Nil(t, errs[CheckStatus])
+	assert.True(t, errors.Is(errs[CheckSignature], ErrBadSignature))
+	assert.Nil(t, errs[CheckTiming])
+	assert.True(t, errors.Is(errs[CheckRecipient], ErrWrongDestination))
+	for _, check := range checks[3:] {
+		assert.True(t, check.Unverified, check.Name)
+	}
+
+	checks = profile.DiagnosePostResponse("not base64!", thisInstant)
+	require.Len(t, checks, 1)
+	assert.Equal(t, CheckDecode, checks[0].Name)
+	assert.True(t, errors.Is(checks[0].Err, ErrMalformed))
+}
@@ -0,0 +1,323 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	// Block encryption algorithms that may be used for encrypted assertions.
+	// See https://www.w3.org/TR/xmlenc-core1/#sec-Alg-Block
+	EncryptionMethodAES128CBC = "http://www.w3.org/2001/04/xmlenc#aes128-cbc"
+	EncryptionMethodAES256CBC = "http://www.w3.org/2001/04/xmlenc#aes256-cbc"
+	EncryptionMethodAES128GCM = "http://www.w3.org/2009/xmlenc11#aes128-gcm"
+	EncryptionMethodAES256GCM = "http://www.w3.org/2009/xmlenc11#aes256-gcm"
+
+	// key transport algorithms, the content encryption key is encrypted with the RSA key of
+	// the recipient
+	keyTransportRSAOAEPMGF1P = "http://www.w3.org/2001/04/xmlenc#rsa-oaep-mgf1p"
+	keyTransportRSAOAEP      = "http://www.w3.org/2009/xmlenc11#rsa-oaep"
+
+	// mask generation functions for keyTransportRSAOAEP, if none is given MGF1 with SHA-1 is used
+	mgf1SHA1   = "http://www.w3.org/2009/xmlenc11#mgf1sha1"
+	mgf1SHA224 = "http://www.w3.org/2009/xmlenc11#mgf1sha224"
+	mgf1SHA256 = "http://www.w3.org/2009/xmlenc11#mgf1sha256"
+	mgf1SHA384 = "http://www.w3.org/2009/xmlenc11#mgf1sha384"
+	mgf1SHA512 = "http://www.w3.org/2009/xmlenc11#mgf1sha512"
+
+	xmlencNamespace       = "http://www.w3.org/2001/04/xmlenc#"
+	xmlenc11Namespace     = "http://www.w3.org/2009/xmlenc11#"
+	xmlencElementType     = "http://www.w3.org/2001/04/xmlenc#Element"
+	encryptedAssertionTag = "EncryptedAssertion"
+	gcmNonceSize          = 12
+)
+
+var (
+	// ErrNoEncryptionCertificate occurs when an assertion must be encrypted but the metadata of
+	// the service provider does not contain an RSA encryption certificate.
+	ErrNoEncryptionCertificate = errors.New("no usable encryption certificate")
+	// ErrDecryptionNotConfigured occurs when an encrypted assertion is received but the
+	// ServiceProvider does not have a decryption key.
+	ErrDecryptionNotConfigured = errors.New("service provider decryption key not configured")
+	// ErrDecryptionFailed occurs when an encrypted assertion can not be decrypted with the
+	// DecryptionKey of the service provider or does not decrypt to an assertion. The cause is
+	// deliberately not reported so that failures can not be used as a padding oracle.
+	ErrDecryptionFailed = errors.New("assertion could not be decrypted")
+)
+
+// encryptionKeySizes are the key sizes in bytes of the supported block encryption algorithms.
+var encryptionKeySizes = map[string]int{
+	EncryptionMethodAES128CBC: 16,
+	EncryptionMethodAES256CBC: 32,
+	EncryptionMethodAES128GCM: 16,
+	EncryptionMethodAES256GCM: 32,
+}
+
+// oaepDigests maps the digest algorithms of RSA-OAEP key transport to their hash.
+var oaepDigests = map[string]crypto.Hash{
+	DigestMethodSHA1:   crypto.SHA1,
+	DigestMethodSHA256: crypto.SHA256,
+	DigestMethodSHA384: crypto.SHA384,
+	DigestMethodSHA512: crypto.SHA512,
+}
+
+// mgfHashes maps the mask generation functions of RSA-OAEP key transport to their hash.
+var mgfHashes = map[string]crypto.Hash{
+	mgf1SHA1:   crypto.SHA1,
+	mgf1SHA224: crypto.SHA224,
+	mgf1SHA256: crypto.SHA256,
+	mgf1SHA384: crypto.SHA384,
+	mgf1SHA512: crypto.SHA512,
+}
+
+type cbcDecryption bool
+
+// WithCBCDecryption is an optional parameter to NewSingleSignOnProfile that accepts assertions
+// encrypted with AES-CBC. CBC is not authenticated, so by default only assertions encrypted with
+// AES-GCM are decrypted. Only allow CBC if the IDP can not be configured to use GCM.
+func WithCBCDecryption(allow bool) func() interface{} {
+	return func() interface{} {
+		return cbcDecryption(allow)
+	}
+}
+
+func isCBC(method string) bool {
+	return method == EncryptionMethodAES128CBC || method == EncryptionMethodAES256CBC
+}
+
+// encryptElement encrypts el for the holder of the private key of cert, returning an
+// EncryptedData element. The content encryption key is encrypted with RSA-OAEP.
+// See https://www.w3.org/TR/xmlenc-core1/
+func encryptElement(el *etree.Element, cert *x509.Certificate, method string) (*etree.Element, error) {
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("encryption certificate does not contain an RSA key")
+	}
+	keySize, ok := encryptionKeySizes[method]
+	if !ok {
+		return nil, errors.Errorf("unsupported encryption method %q", method)
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(el.Copy())
+	plaintext, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "serializing element for encryption")
+	}
+	key := make([]byte, keySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating encryption key")
+	}
+	ciphertext, err := encryptBlock(method, key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypting key")
+	}
+
+	data := etree.NewElement("xenc:EncryptedData")
+	data.CreateAttr("xmlns:xenc", xmlencNamespace)
+	data.CreateAttr("Type", xmlencElementType)
+	data.CreateElement("xenc:EncryptionMethod").CreateAttr("Algorithm", method)
+	keyInfo := data.CreateElement("ds:KeyInfo")
+	keyInfo.CreateAttr("xmlns:ds", dsig.Namespace)
+	encKey := keyInfo.CreateElement("xenc:EncryptedKey")
+	keyMethod := encKey.CreateElement("xenc:EncryptionMethod")
+	keyMethod.CreateAttr("Algorithm", keyTransportRSAOAEPMGF1P)
+	keyMethod.CreateElement("ds:DigestMethod").CreateAttr("Algorithm", DigestMethodSHA1)
+	encKey.CreateElement("ds:KeyInfo").
+		CreateElement("ds:X509Data").
+		CreateElement("ds:X509Certificate").
+		SetText(base64.StdEncoding.EncodeToString(cert.Raw))
+	encKey.CreateElement("xenc:CipherData").
+		CreateElement("xenc:CipherValue").
+		SetText(base64.StdEncoding.EncodeToString(encryptedKey))
+	data.CreateElement("xenc:CipherData").
+		CreateElement("xenc:CipherValue").
+		SetText(base64.StdEncoding.EncodeToString(ciphertext))
+	return data, nil
+}
+
+// decryptElement decrypts the EncryptedData child of container with key, returning the
+// plaintext. The EncryptedKey may be in the KeyInfo of the EncryptedData or, as some IDPs
+// send it, a sibling of the EncryptedData. AES-CBC is refused unless allowCBC is set. Once
+// decryption has started every failure is reported as ErrDecryptionFailed.
+func decryptElement(container *etree.Element, key crypto.Decrypter, allowCBC bool) ([]byte, error) {
+	data := container.SelectElement("EncryptedData")
+	if data == nil || data.NamespaceURI() != xmlencNamespace {
+		return nil, errors.New("missing EncryptedData")
+	}
+	method, err := encryptionMethod(data)
+	if err != nil {
+		return nil, err
+	}
+	keySize, ok := encryptionKeySizes[method]
+	if !ok {
+		return nil, errors.Errorf("unsupported encryption method %q", method)
+	}
+	if isCBC(method) && !allowCBC {
+		return nil, errors.Wrapf(ErrAlgorithmNotAllowed, "encryption method %q", method)
+	}
+	var encKey *etree.Element
+	if keyInfo := data.SelectElement("KeyInfo"); keyInfo != nil {
+		encKey = keyInfo.SelectElement("EncryptedKey")
+	}
+	if encKey == nil {
+		encKey = container.SelectElement("EncryptedKey")
+	}
+	if encKey == nil {
+		return nil, errors.New("missing EncryptedKey")
+	}
+	opts, err := oaepOptions(encKey)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := cipherValue(encKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := cipherValue(data)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, err := key.Decrypt(rand.Reader, encrypted, opts)
+	if err != nil || len(contentKey) != keySize {
+		return nil, ErrDecryptionFailed
+	}
+	plaintext, err := decryptBlock(method, contentKey, ciphertext)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// oaepOptions returns the RSA-OAEP parameters of the EncryptedKey encKey. The digest defaults to
+// SHA-1, as does the mask generation function unless an xenc11 MGF element names another.
+func oaepOptions(encKey *etree.Element) (*rsa.OAEPOptions, error) {
+	method, err := encryptionMethod(encKey)
+	if err != nil {
+		return nil, err
+	}
+	if method != keyTransportRSAOAEPMGF1P && method != keyTransportRSAOAEP {
+		return nil, errors.Errorf("unsupported key transport method %q", method)
+	}
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+	if digest := encKey.FindElement("EncryptionMethod/DigestMethod"); digest != nil {
+		algorithm := digest.SelectAttrValue("Algorithm", "")
+		hash, ok := oaepDigests[algorithm]
+		if !ok {
+			return nil, errors.Errorf("unsupported key transport digest %q", algorithm)
+		}
+		opts.Hash = hash
+	}
+	// rsa-oaep-mgf1p always uses MGF1 with SHA-1
+	if mgf := encKey.FindElement("EncryptionMethod/MGF"); mgf != nil && method == keyTransportRSAOAEP {
+		algorithm := mgf.SelectAttrValue("Algorithm", "")
+		hash, ok := mgfHashes[algorithm]
+		if !ok || mgf.NamespaceURI() != xmlenc11Namespace {
+			return nil, errors.Errorf("unsupported mask generation function %q", algorithm)
+		}
+		opts.MGFHash = hash
+	}
+	return opts, nil
+}
+
+func encryptionMethod(el *etree.Element) (string, error) {
+	method := el.SelectElement("EncryptionMethod")
+	if method == nil {
+		return "", errors.Errorf("%s is missing EncryptionMethod", el.Tag)
+	}
+	return method.SelectAttrValue("Algorithm", ""), nil
+}
+
+func cipherValue(el *etree.Element) ([]byte, error) {
+	value := el.FindElement("CipherData/CipherValue")
+	if value == nil {
+		return nil, errors.Errorf("%s is missing CipherValue", el.Tag)
+	}
+	decoded, err := decodeBase64(value.Text())
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding CipherValue")
+	}
+	return decoded, nil
+}
+
+// encryptBlock encrypts plaintext with key. The IV or nonce is prepended to the ciphertext.
+func encryptBlock(method string, key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher")
+	}
+	switch method {
+	case EncryptionMethodAES128GCM, EncryptionMethodAES256GCM:
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating GCM cipher")
+		}
+		nonce := make([]byte, gcmNonceSize)
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return nil, errors.Wrap(err, "generating nonce")
+		}
+		return aead.Seal(nonce, nonce, plaintext, nil), nil
+	default:
+		// the last byte of the padding is its length, xmlenc does not require the other
+		// padding bytes to have any particular value
+		padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+		padded := append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+		ciphertext := make([]byte, aes.BlockSize+len(padded))
+		_, err = rand.Read(ciphertext[:aes.BlockSize])
+		if err != nil {
+			return nil, errors.Wrap(err, "generating IV")
+		}
+		cipher.NewCBCEncrypter(block, ciphertext[:aes.BlockSize]).CryptBlocks(ciphertext[aes.BlockSize:], padded)
+		return ciphertext, nil
+	}
+}
+
+// decryptBlock reverses encryptBlock.
+func decryptBlock(method string, key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher")
+	}
+	switch method {
+	case EncryptionMethodAES128GCM, EncryptionMethodAES256GCM:
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating GCM cipher")
+		}
+		if len(ciphertext) < gcmNonceSize {
+			return nil, errors.New("ciphertext too short")
+		}
+		plaintext, err := aead.Open(nil, ciphertext[:gcmNonceSize], ciphertext[gcmNonceSize:], nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "decrypting")
+		}
+		return plaintext, nil
+	default:
+		if len(ciphertext) < 2*aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, errors.New("invalid ciphertext length")
+		}
+		plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+		cipher.NewCBCDecrypter(block, ciphertext[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext[aes.BlockSize:])
+		padding := int(plaintext[len(plaintext)-1])
+		if padding == 0 || padding > aes.BlockSize {
+			return nil, errors.New("invalid padding")
+		}
+		return plaintext[:len(plaintext)-padding], nil
+	}
+}
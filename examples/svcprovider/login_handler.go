@@ -40,9 +40,9 @@ type loginCallbackHandler struct {
 	loginProfile *saml.SingleSignOnProfile
 }
 
-func newLoginCallbackHandler(sp saml.ServiceProvider, metadata saml.IDPSSODescriptor) http.Handler {
+func newLoginCallbackHandler(sp saml.ServiceProvider, metadata *saml.EntityDescriptor) http.Handler {
 	return &loginCallbackHandler{
-		loginProfile: saml.NewSingleSignOnProfile(&sp, &metadata),
+		loginProfile: saml.NewSingleSignOnProfile(&sp, &metadata.IDPSSODescriptor, saml.WithIDPEntityID(metadata.EntityID)),
 	}
 }
 
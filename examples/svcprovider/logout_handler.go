@@ -11,9 +11,9 @@ type logoutHandler struct {
 	logoutProfile *saml.SingleLogOutProfile
 }
 
-func newLogoutHandler(sp saml.ServiceProvider, metadata *saml.EntityDescriptor) http.Handler {
+func newLogoutHandler(sp saml.ServiceProvider, metadata *saml.EntityDescriptor, tracker saml.RequestTracker) http.Handler {
 	return &logoutHandler{
-		logoutProfile: saml.NewSingleLogOutProfile(&sp, metadata),
+		logoutProfile: saml.NewSingleLogOutProfile(&sp, metadata, saml.WithRequestTracker(tracker)),
 	}
 }
 
@@ -25,8 +25,23 @@ func (h *logoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	email := r.FormValue(keyUID)
 	redirectURL, err := h.logoutProfile.RedirectBinding(email)
+	if err == saml.ErrBindingNotSupported {
+		// IDP only accepts logout requests using the post binding
+		form, err := h.logoutProfile.PostBinding(email)
+		if err != nil {
+			writeServerError(w, err, "building post binding")
+			return
+		}
+		contentTypeHeader(w)
+		err = form.Write(w)
+		if err != nil {
+			writeServerError(w, err, "writing logout form")
+		}
+		return
+	}
 	if err != nil {
 		writeServerError(w, err, "building redirect binding")
+		return
 	}
 	// Trigger redirect in browser
 	w.Header().Set("Location", redirectURL)
@@ -37,9 +52,9 @@ type logoutCallbackHandler struct {
 	logoutProfile *saml.SingleLogOutProfile
 }
 
-func newLogoutCallbackHandler(sp saml.ServiceProvider, metadata *saml.EntityDescriptor) http.Handler {
+func newLogoutCallbackHandler(sp saml.ServiceProvider, metadata *saml.EntityDescriptor, tracker saml.RequestTracker) http.Handler {
 	return &logoutCallbackHandler{
-		logoutProfile: saml.NewSingleLogOutProfile(&sp, metadata),
+		logoutProfile: saml.NewSingleLogOutProfile(&sp, metadata, saml.WithRequestTracker(tracker)),
 	}
 }
 
@@ -47,12 +62,22 @@ func (h *logoutCallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	cb, err := h.logoutProfile.HandlePostResponse(r, time.Now())
 	if err != nil {
 		writeServerError(w, err, "logout callback")
+		return
 	}
 	var location string
 	if cb.SelfInitiatedLogout != nil {
 		location = cb.SelfInitiatedLogout.RelayURL
 	}
 	if cb.ExternallyInitiatedLogout != nil {
+		// IDP only supports the post binding so the response is sent using a form
+		if cb.ExternallyInitiatedLogout.PostForm != nil {
+			contentTypeHeader(w)
+			err = cb.ExternallyInitiatedLogout.PostForm.Write(w)
+			if err != nil {
+				writeServerError(w, err, "writing logout response form")
+			}
+			return
+		}
 		location = cb.ExternallyInitiatedLogout.RedirectURL
 	}
 
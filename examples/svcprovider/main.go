@@ -2,10 +2,12 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/murphybytes/saml"
 	"github.com/murphybytes/saml/examples/svcprovider/generated"
@@ -54,6 +56,12 @@ func main() {
 		Certificates: []tls.Certificate{tlsCert},
 	}
 
+	signingCert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	errHandler(err, "parsing signing cert")
+
+	signingKey, err := saml.ParsePrivateKeyPEM(key)
+	errHandler(err, "parsing signing key")
+
 	metadata, err := saml.GetMetadataFromFile(metadataPath)
 	errHandler(err, fmt.Sprintf("reading from %q", metadataPath))
 	sp := saml.ServiceProvider{
@@ -62,8 +70,15 @@ func main() {
 			saml.NameIDEmail,
 		},
 		AssertionConsumerServiceURL: "https://localhost:8080/callback",
+		SingleLogoutServiceURL:      "https://localhost:8080/logout/callback",
+		// Logout messages are signed with the same key used for TLS
+		SigningKey:         signingKey,
+		SigningCertificate: signingCert,
 	}
 
+	// logout requests are tracked so the logout callback can match them to responses
+	logoutRequests := saml.NewMemoryRequestTracker(10 * time.Minute)
+
 	server := http.Server{
 		Addr:      ":8080",
 		TLSConfig: &config,
@@ -71,9 +86,9 @@ func main() {
 			mux := http.NewServeMux()
 			mux.Handle("/", newHomepageHandler())
 			mux.Handle("/login", newLoginHandler(sp, metadata.IDPSSODescriptor))
-			mux.Handle("/login/callback", newLoginCallbackHandler(sp, metadata.IDPSSODescriptor))
-			mux.Handle("/logout", newLogoutHandler(sp, metadata))
-			mux.Handle("/logout/callback", newLogoutCallbackHandler(sp, metadata))
+			mux.Handle("/login/callback", newLoginCallbackHandler(sp, metadata))
+			mux.Handle("/logout", newLogoutHandler(sp, metadata, logoutRequests))
+			mux.Handle("/logout/callback", newLogoutCallbackHandler(sp, metadata, logoutRequests))
 			return mux
 		}(),
 	}
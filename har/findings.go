@@ -0,0 +1,150 @@
+package har
+
+import (
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/murphybytes/saml"
+)
+
+// check adds findings for problems that can be detected without the keys of the IDP. The
+// time the browser sent a message stands in for the time the service provider received it.
+func check(m *Message) {
+	if m.doc == nil {
+		return
+	}
+	if m.Destination != "" && m.Destination != m.URL {
+		m.addFinding("Destination %s does not match the URL the message was sent to", m.Destination)
+	}
+	if !m.IssueInstant.IsZero() && m.IssueInstant.After(m.Time.Add(saml.DefaultClockSkew)) {
+		m.addFinding("issued %s after it was sent, the clock of the issuer is ahead", m.IssueInstant.Sub(m.Time).Round(time.Second))
+	}
+	if !m.IsResponse() {
+		if len(m.Responses) == 0 && strings.HasSuffix(m.Tag, "Request") {
+			m.addFinding("no response to this request was captured")
+		}
+		return
+	}
+
+	switch {
+	case m.InResponseTo == "" && m.Tag == "Response":
+		m.addFinding("unsolicited response, it does not answer an AuthnRequest")
+	case m.InResponseTo != "" && m.Request == nil:
+		m.addFinding("InResponseTo %s does not match a request in the trace", m.InResponseTo)
+	}
+	if m.Request != nil {
+		if m.RelayState != m.Request.RelayState {
+			m.addFinding("RelayState %q differs from %q sent with the request", m.RelayState, m.Request.RelayState)
+		}
+		acsURL := m.Request.doc.SelectAttrValue("AssertionConsumerServiceURL", "")
+		if acsURL != "" && acsURL != m.URL {
+			m.addFinding("response was sent to %s, the request asked for %s", m.URL, acsURL)
+		}
+	}
+	if m.Status != "" && m.Status != statusSuccess {
+		finding := "status is " + statusNames(m.doc)
+		if message := m.doc.FindElement("Status/StatusMessage"); message != nil {
+			finding += ": " + strings.TrimSpace(message.Text())
+		}
+		m.addFinding(finding)
+	}
+	if m.Tag == "Response" {
+		checkAssertions(m)
+	}
+}
+
+// checkAssertions adds findings for the signatures, timing, audience and recipient of the
+// assertions in a response.
+func checkAssertions(m *Message) {
+	assertions := m.doc.SelectElements("Assertion")
+	encrypted := m.doc.SelectElements("EncryptedAssertion")
+	if len(encrypted) > 0 {
+		m.addFinding("assertion is encrypted, its conditions can not be checked")
+	}
+	if m.Status == statusSuccess && len(assertions)+len(encrypted) == 0 {
+		m.addFinding("successful response does not contain an assertion")
+	}
+	if len(assertions)+len(encrypted) > 1 {
+		m.addFinding("response contains %d assertions, only one is accepted", len(assertions)+len(encrypted))
+	}
+	responseSigned := m.doc.SelectElement("Signature") != nil
+	for _, assertion := range assertions {
+		if !responseSigned && assertion.SelectElement("Signature") == nil {
+			m.addFinding("neither the response nor the assertion is signed")
+		}
+		if issuer := childText(assertion, "Issuer"); m.Issuer != "" && issuer != "" && issuer != m.Issuer {
+			m.addFinding("assertion Issuer %s differs from the response Issuer %s", issuer, m.Issuer)
+		}
+
+		conditions := assertion.SelectElement("Conditions")
+		data := assertion.FindElement("Subject/SubjectConfirmation/SubjectConfirmationData")
+		if conditions != nil {
+			checkNotBefore(m, "Conditions", conditions)
+			checkNotOnOrAfter(m, "Conditions", conditions)
+			if m.Request != nil && m.Request.Issuer != "" {
+				checkAudience(m, conditions, m.Request.Issuer)
+			}
+		}
+		if data != nil {
+			checkNotOnOrAfter(m, "SubjectConfirmationData", data)
+			if recipient := data.SelectAttrValue("Recipient", ""); recipient != "" && recipient != m.URL {
+				m.addFinding("Recipient %s does not match the URL the response was sent to", recipient)
+			}
+			if inResponseTo := data.SelectAttrValue("InResponseTo", ""); inResponseTo != "" && inResponseTo != m.InResponseTo {
+				m.addFinding("SubjectConfirmationData InResponseTo %s differs from the response InResponseTo %s", inResponseTo, m.InResponseTo)
+			}
+		}
+	}
+}
+
+func checkNotBefore(m *Message, name string, el *etree.Element) {
+	notBefore, err := saml.ParseDateTime(el.SelectAttrValue("NotBefore", ""))
+	if err != nil {
+		m.addFinding("%s NotBefore is invalid: %s", name, err)
+		return
+	}
+	if !notBefore.IsZero() && m.Time.Before(notBefore.Add(-saml.DefaultClockSkew)) {
+		m.addFinding("%s are not valid until %s, %s after it was sent", name, notBefore, notBefore.Sub(m.Time).Round(time.Second))
+	}
+}
+
+func checkNotOnOrAfter(m *Message, name string, el *etree.Element) {
+	notOnOrAfter, err := saml.ParseDateTime(el.SelectAttrValue("NotOnOrAfter", ""))
+	if err != nil {
+		m.addFinding("%s NotOnOrAfter is invalid: %s", name, err)
+		return
+	}
+	if !notOnOrAfter.IsZero() && !m.Time.Before(notOnOrAfter.Add(saml.DefaultClockSkew)) {
+		m.addFinding("%s expired at %s, before it was sent", name, notOnOrAfter)
+	}
+}
+
+// checkAudience adds a finding if an audience restriction excludes the service provider.
+func checkAudience(m *Message, conditions *etree.Element, entityID string) {
+	for _, restriction := range conditions.SelectElements("AudienceRestriction") {
+		var audiences []string
+		for _, audience := range restriction.SelectElements("Audience") {
+			audiences = append(audiences, strings.TrimSpace(audience.Text()))
+		}
+		found := false
+		for _, audience := range audiences {
+			if audience == entityID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			m.addFinding("audience %s does not include the service provider %s", strings.Join(audiences, " "), entityID)
+		}
+	}
+}
+
+// statusNames returns the status codes of a response without the common URI prefix.
+func statusNames(root *etree.Element) string {
+	var names []string
+	for code := root.FindElement("Status/StatusCode"); code != nil; code = code.SelectElement("StatusCode") {
+		names = append(names, strings.TrimPrefix(code.SelectAttrValue("Value", ""), "urn:oasis:names:tc:SAML:2.0:status:"))
+	}
+	return strings.Join(names, "/")
+}
@@ -0,0 +1,258 @@
+// Package har finds the SAML messages in a HAR file recorded by a browser and correlates them
+// into a timeline of the login or logout flow, noting problems that commonly cause SSO to fail.
+package har
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/murphybytes/saml"
+	"github.com/pkg/errors"
+)
+
+const statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+
+// Where a message was found in a request.
+const (
+	InQuery = "query"
+	InForm  = "form"
+)
+
+// Message is a SAML message sent by the browser.
+type Message struct {
+	// Entry is the index of the HAR entry containing the message.
+	Entry int
+	// Time is when the browser sent the message.
+	Time   time.Time
+	Method string
+	// URL is the URL the message was sent to, without its query.
+	URL string
+	// Location is InQuery or InForm.
+	Location string
+	// Parameter is SAMLRequest or SAMLResponse.
+	Parameter  string
+	RelayState string
+	// XML is the decoded message, it is empty if the message could not be decoded.
+	XML string
+
+	// Tag is the name of the root element, such as AuthnRequest or Response.
+	Tag          string
+	ID           string
+	InResponseTo string
+	Issuer       string
+	Destination  string
+	IssueInstant saml.DateTime
+	// Status is the top level status code of responses.
+	Status string
+
+	// Request is the message answered by a response, if it is in the trace.
+	Request *Message
+	// Responses are the messages that answer a request.
+	Responses []*Message
+	// Findings are problems found with the message.
+	Findings []string
+
+	doc *etree.Element
+}
+
+// IsResponse returns true if the message was sent in a SAMLResponse parameter.
+func (m *Message) IsResponse() bool {
+	return m.Parameter == saml.ResponseQueryKey
+}
+
+func (m *Message) addFinding(format string, args ...interface{}) {
+	m.Findings = append(m.Findings, fmt.Sprintf(format, args...))
+}
+
+// archive is the part of the HAR format used to find messages.
+// See http://www.softwareishard.com/blog/har-12-spec/
+type archive struct {
+	Log struct {
+		Entries []entry `json:"entries"`
+	} `json:"log"`
+}
+
+type entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Request         struct {
+		Method   string `json:"method"`
+		URL      string `json:"url"`
+		PostData *struct {
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+			Params   []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"params"`
+		} `json:"postData"`
+	} `json:"request"`
+}
+
+// Analyze reads a HAR file and returns the SAML messages sent in query strings and form posts,
+// in the order they were sent. Requests are linked to the responses that answer them by ID
+// and InResponseTo. Messages that can not be decoded are returned with a finding rather than
+// an error, an error is only returned if the HAR file can not be read.
+func Analyze(r io.Reader) ([]*Message, error) {
+	var har archive
+	err := json.NewDecoder(r).Decode(&har)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding HAR")
+	}
+	var messages []*Message
+	for i, e := range har.Log.Entries {
+		found, err := entryMessages(i, &e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading HAR entry %d", i)
+		}
+		messages = append(messages, found...)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Time.Before(messages[j].Time)
+	})
+	correlate(messages)
+	for _, m := range messages {
+		check(m)
+	}
+	return messages, nil
+}
+
+// entryMessages returns the messages in the query string and form post of an entry.
+func entryMessages(index int, e *entry) ([]*Message, error) {
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing request URL")
+	}
+	target := *u
+	target.RawQuery = ""
+	target.Fragment = ""
+	newMessage := func(location string, values url.Values) *Message {
+		for _, key := range []string{saml.RequestQueryKey, saml.ResponseQueryKey} {
+			if values.Get(key) == "" {
+				continue
+			}
+			m := &Message{
+				Entry:      index,
+				Time:       e.StartedDateTime,
+				Method:     e.Request.Method,
+				URL:        target.String(),
+				Location:   location,
+				Parameter:  key,
+				RelayState: values.Get(saml.RelayStateQueryKey),
+			}
+			parse(m, values.Get(key))
+			return m
+		}
+		return nil
+	}
+
+	var messages []*Message
+	if m := newMessage(InQuery, u.Query()); m != nil {
+		messages = append(messages, m)
+	}
+	if e.Request.PostData != nil {
+		if m := newMessage(InForm, formValues(e)); m != nil {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+// formValues returns the values posted in an entry. Browsers disagree about whether params are
+// unescaped, so the text of the post is preferred.
+func formValues(e *entry) url.Values {
+	post := e.Request.PostData
+	if strings.HasPrefix(post.MimeType, "application/x-www-form-urlencoded") && post.Text != "" {
+		values, err := url.ParseQuery(post.Text)
+		if err == nil {
+			return values
+		}
+	}
+	values := url.Values{}
+	for _, param := range post.Params {
+		value := param.Value
+		if unescaped, err := url.QueryUnescape(value); err == nil && strings.Contains(value, "%") {
+			value = unescaped
+		}
+		values.Add(param.Name, value)
+	}
+	return values
+}
+
+// parse decodes the message value and extracts the fields used to correlate and check it.
+func parse(m *Message, value string) {
+	// '+' in a value that was not escaped is turned into a space when it is parsed
+	value = strings.Replace(strings.TrimSpace(value), " ", "+", -1)
+	decoded, err := saml.DecodeMessage(value)
+	if err != nil {
+		m.addFinding("message can not be decoded: %s", err)
+		return
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromString(decoded)
+	if err != nil || doc.Root() == nil {
+		m.addFinding("message is not XML")
+		return
+	}
+	m.XML = decoded
+	root := doc.Root()
+	m.doc = root
+	m.Tag = root.Tag
+	m.ID = root.SelectAttrValue("ID", "")
+	m.InResponseTo = root.SelectAttrValue("InResponseTo", "")
+	m.Destination = root.SelectAttrValue("Destination", "")
+	m.Issuer = childText(root, "Issuer")
+	m.IssueInstant, err = saml.ParseDateTime(root.SelectAttrValue("IssueInstant", ""))
+	if err != nil {
+		m.addFinding("IssueInstant is invalid: %s", err)
+	}
+	if code := root.FindElement("Status/StatusCode"); code != nil {
+		m.Status = code.SelectAttrValue("Value", "")
+	}
+}
+
+// correlate links responses to the requests they answer.
+func correlate(messages []*Message) {
+	requests := map[string]*Message{}
+	for _, m := range messages {
+		if m.IsResponse() || m.ID == "" {
+			continue
+		}
+		if previous, ok := requests[m.ID]; ok {
+			m.addFinding("request ID %s was already used by the message sent at %s", m.ID, timestamp(previous.Time))
+		}
+		requests[m.ID] = m
+	}
+	responses := map[string]*Message{}
+	for _, m := range messages {
+		if !m.IsResponse() {
+			continue
+		}
+		if previous, ok := responses[m.ID]; ok && m.ID != "" {
+			m.addFinding("response ID %s was already sent at %s, it is rejected as a replay", m.ID, timestamp(previous.Time))
+		}
+		responses[m.ID] = m
+		if request, ok := requests[m.InResponseTo]; ok {
+			m.Request = request
+			request.Responses = append(request.Responses, m)
+		}
+	}
+}
+
+func childText(parent *etree.Element, tag string) string {
+	child := parent.SelectElement(tag)
+	if child == nil {
+		return ""
+	}
+	return strings.TrimSpace(child.Text())
+}
+
+// timestamp formats t for findings and timelines.
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
@@ -0,0 +1,167 @@
+package har
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/saml"
+	"github.com/murphybytes/saml/samltest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var flowInstant = time.Date(2017, 6, 11, 20, 29, 27, 0, time.UTC)
+
+const authnRequest = `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_request" Version="2.0" IssueInstant="2017-06-11T20:29:27Z" Destination="https://idp.example.com/sso" AssertionConsumerServiceURL="https://sp.example.com/acs"><saml:Issuer>https://sp.example.com</saml:Issuer></samlp:AuthnRequest>`
+
+func getFixtures(t *testing.T) *samltest.Fixtures {
+	f, err := samltest.NewFixtures(&saml.ServiceProvider{
+		IssuerURI:                   "https://sp.example.com",
+		AssertionConsumerServiceURL: "https://sp.example.com/acs",
+	})
+	require.Nil(t, err)
+	return f
+}
+
+// harEntry is an entry of a HAR file as recorded by a browser.
+type harEntry struct {
+	StartedDateTime time.Time              `json:"startedDateTime"`
+	Request         map[string]interface{} `json:"request"`
+}
+
+func redirectEntry(t *testing.T, at time.Time, location, message, relayState string) harEntry {
+	var buff bytes.Buffer
+	w, err := flate.NewWriter(&buff, flate.DefaultCompression)
+	require.Nil(t, err)
+	_, err = w.Write([]byte(message))
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+	values := url.Values{}
+	values.Set(saml.RequestQueryKey, base64.StdEncoding.EncodeToString(buff.Bytes()))
+	values.Set(saml.RelayStateQueryKey, relayState)
+	return harEntry{
+		StartedDateTime: at,
+		Request: map[string]interface{}{
+			"method": "GET",
+			"url":    location + "?" + values.Encode(),
+		},
+	}
+}
+
+func postEntry(at time.Time, location, encoded, relayState string) harEntry {
+	values := url.Values{}
+	values.Set(saml.ResponseQueryKey, encoded)
+	values.Set(saml.RelayStateQueryKey, relayState)
+	return harEntry{
+		StartedDateTime: at,
+		Request: map[string]interface{}{
+			"method": "POST",
+			"url":    location,
+			"postData": map[string]interface{}{
+				"mimeType": "application/x-www-form-urlencoded",
+				"text":     values.Encode(),
+			},
+		},
+	}
+}
+
+func encodeHAR(t *testing.T, entries ...harEntry) *bytes.Buffer {
+	var buff bytes.Buffer
+	err := json.NewEncoder(&buff).Encode(map[string]interface{}{
+		"log": map[string]interface{}{
+			"version": "1.2",
+			"entries": entries,
+		},
+	})
+	require.Nil(t, err)
+	return &buff
+}
+
+func TestAnalyze(t *testing.T) {
+	f := getFixtures(t)
+	response, err := f.Response(flowInstant)
+	require.Nil(t, err)
+	trace := encodeHAR(t,
+		redirectEntry(t, flowInstant, "https://idp.example.com/sso", authnRequest, "/home"),
+		harEntry{StartedDateTime: flowInstant, Request: map[string]interface{}{"method": "GET", "url": "https://idp.example.com/login"}},
+		postEntry(flowInstant.Add(5*time.Second), "https://sp.example.com/acs", response, "/home"),
+	)
+
+	messages, err := Analyze(trace)
+	require.Nil(t, err)
+	require.Len(t, messages, 2)
+	request, resp := messages[0], messages[1]
+	assert.Equal(t, "AuthnRequest", request.Tag)
+	assert.Equal(t, InQuery, request.Location)
+	assert.Equal(t, "https://idp.example.com/sso", request.URL)
+	assert.Equal(t, "https://sp.example.com", request.Issuer)
+	assert.Equal(t, []*Message{resp}, request.Responses)
+
+	assert.Equal(t, "Response", resp.Tag)
+	assert.Equal(t, InForm, resp.Location)
+	assert.Equal(t, 2, resp.Entry)
+	assert.Equal(t, samltest.FixtureIssuer, resp.Issuer)
+	assert.Equal(t, request, resp.Request)
+	assert.Equal(t, statusSuccess, resp.Status)
+
+	assert.Empty(t, request.Findings)
+	assert.Empty(t, resp.Findings)
+}
+
+func TestAnalyzeFindings(t *testing.T) {
+	f := getFixtures(t)
+	response, err := f.Response(flowInstant, func(r *saml.Response) {
+		r.Assertion.Conditions.AudienceRestrictions[0].Audiences = []string{"uri:other"}
+	})
+	require.Nil(t, err)
+	unsolicited, err := f.Response(flowInstant, func(r *saml.Response) {
+		r.ID = "_unsolicited"
+		r.InResponseTo = ""
+		r.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.InResponseTo = ""
+	})
+	require.Nil(t, err)
+	later := flowInstant.Add(time.Hour)
+	trace := encodeHAR(t,
+		redirectEntry(t, flowInstant, "https://idp.example.com/sso", authnRequest, "/home"),
+		postEntry(later, "https://sp.example.com/acs", response, "/other"),
+		postEntry(later.Add(time.Second), "https://sp.example.com/acs", response, "/other"),
+		redirectEntry(t, later, "https://idp.example.com/sso", strings.Replace(authnRequest, "_request", "_unanswered", 1), ""),
+		postEntry(flowInstant, "https://sp.example.com/acs", unsolicited, ""),
+	)
+
+	messages, err := Analyze(trace)
+	require.Nil(t, err)
+	require.Len(t, messages, 5)
+	// messages are ordered by the time they were sent
+	assert.Equal(t, []int{0, 4, 1, 3, 2}, []int{messages[0].Entry, messages[1].Entry, messages[2].Entry, messages[3].Entry, messages[4].Entry})
+
+	assert.Empty(t, messages[0].Findings)
+	assert.Equal(t, []string{"unsolicited response, it does not answer an AuthnRequest"}, messages[1].Findings)
+
+	findings := strings.Join(messages[2].Findings, "\n")
+	assert.Contains(t, findings, `RelayState "/other" differs from "/home" sent with the request`)
+	assert.Contains(t, findings, "audience uri:other does not include the service provider https://sp.example.com")
+	assert.Contains(t, findings, "Conditions expired at")
+	assert.Equal(t, []string{"no response to this request was captured"}, messages[3].Findings)
+	assert.Contains(t, strings.Join(messages[4].Findings, "\n"), "it is rejected as a replay")
+}
+
+func TestAnalyzeInvalid(t *testing.T) {
+	trace := encodeHAR(t,
+		postEntry(flowInstant, "https://sp.example.com/acs", "not a message", ""),
+	)
+	messages, err := Analyze(trace)
+	require.Nil(t, err)
+	require.Len(t, messages, 1)
+	require.Len(t, messages[0].Findings, 1)
+	assert.True(t, strings.HasPrefix(messages[0].Findings[0], "message can not be decoded"))
+
+	_, err = Analyze(strings.NewReader("not json"))
+	assert.NotNil(t, err)
+}
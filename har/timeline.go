@@ -0,0 +1,52 @@
+package har
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteTimeline writes messages returned by Analyze in the order they were sent, with the
+// requests and responses they are correlated with and their findings.
+func WriteTimeline(w io.Writer, messages []*Message) error {
+	bw := bufio.NewWriter(w)
+	number := map[*Message]int{}
+	for i, m := range messages {
+		number[m] = i + 1
+	}
+	for i, m := range messages {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "[%d] %s %s %s (%s)\n", number[m], timestamp(m.Time), m.Method, m.URL, m.Location)
+		tag := m.Tag
+		if tag == "" {
+			tag = m.Parameter
+		}
+		description := strings.TrimSpace(tag + " " + m.ID)
+		if m.Issuer != "" {
+			description += " from " + m.Issuer
+		}
+		if m.Status != "" {
+			description += ", status " + statusNames(m.doc)
+		}
+		fmt.Fprintf(bw, "    %s\n", description)
+		if m.RelayState != "" {
+			fmt.Fprintf(bw, "    RelayState: %s\n", m.RelayState)
+		}
+		if m.Request != nil {
+			fmt.Fprintf(bw, "    answers [%d]\n", number[m.Request])
+		}
+		for _, response := range m.Responses {
+			fmt.Fprintf(bw, "    answered by [%d]\n", number[response])
+		}
+		for _, finding := range m.Findings {
+			fmt.Fprintf(bw, "    ! %s\n", finding)
+		}
+	}
+	if len(messages) == 0 {
+		fmt.Fprintln(bw, "no SAML messages found")
+	}
+	return bw.Flush()
+}
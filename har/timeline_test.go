@@ -0,0 +1,38 @@
+package har
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTimeline(t *testing.T) {
+	f := getFixtures(t)
+	response, err := f.Response(flowInstant)
+	require.Nil(t, err)
+	trace := encodeHAR(t,
+		redirectEntry(t, flowInstant, "https://idp.example.com/sso", authnRequest, "/home"),
+		postEntry(flowInstant.Add(5*time.Second), "https://sp.example.com/acs", response, "/elsewhere"),
+	)
+	messages, err := Analyze(trace)
+	require.Nil(t, err)
+
+	var out bytes.Buffer
+	require.Nil(t, WriteTimeline(&out, messages))
+	timeline := out.String()
+	assert.Contains(t, timeline, "[1] 2017-06-11T20:29:27.000Z GET https://idp.example.com/sso (query)\n"+
+		"    AuthnRequest _request from https://sp.example.com\n"+
+		"    RelayState: /home\n"+
+		"    answered by [2]\n")
+	assert.Contains(t, timeline, "[2] 2017-06-11T20:29:32.000Z POST https://sp.example.com/acs (form)\n")
+	assert.Contains(t, timeline, "from https://idp.example.com, status Success\n")
+	assert.Contains(t, timeline, "    answers [1]\n")
+	assert.Contains(t, timeline, `    ! RelayState "/elsewhere" differs from "/home" sent with the request`)
+
+	out.Reset()
+	require.Nil(t, WriteTimeline(&out, nil))
+	assert.Equal(t, "no SAML messages found\n", out.String())
+}
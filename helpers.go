@@ -19,10 +19,40 @@ const (
 	ResponseQueryKey   = "SAMLResponse"
 	RequestQueryKey    = "SAMLRequest"
 	RelayStateQueryKey = "RelayState"
+	SigAlgQueryKey     = "SigAlg"
+	SignatureQueryKey  = "Signature"
 	alphabet           = "abcdefghijklmnopqrstuvwzyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	idSize             = 10
 )
 
+// bindingParameters are the parameters used by the redirect and post bindings.
+var bindingParameters = []string{RequestQueryKey, ResponseQueryKey, RelayStateQueryKey, SigAlgQueryKey, SignatureQueryKey}
+
+func isBindingParameter(key string) bool {
+	for _, parameter := range bindingParameters {
+		if key == parameter {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBindingForm parses the form of a message sent with the redirect or post binding. If a
+// binding parameter has more than one value, in the query, the body or both, the message is
+// rejected so that the values used are always the values whose signature was verified.
+func parseBindingForm(r *http.Request) error {
+	err := r.ParseForm()
+	if err != nil {
+		return errors.Wrap(err, "parsing form")
+	}
+	for _, key := range bindingParameters {
+		if len(r.Form[key]) > 1 {
+			return errors.Errorf("more than one %s parameter", key)
+		}
+	}
+	return nil
+}
+
 type httpClientTimeout time.Duration
 
 // WithTimeout pass an optional timeout to GetMetadataURL
@@ -86,12 +116,16 @@ func deflate(inflated *bytes.Buffer) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	defer writer.Close()
 	_, err = writer.Write(inflated.Bytes())
 	if err != nil {
 		return "", err
 	}
-	writer.Flush()
+	// close rather than flush so the final block is written, otherwise
+	// the receiver sees a truncated stream
+	err = writer.Close()
+	if err != nil {
+		return "", err
+	}
 	return base64.StdEncoding.EncodeToString(deflated.Bytes()), nil
 }
 
@@ -111,14 +145,68 @@ func inflate(deflated string) (string, error) {
 	return inflated.String(), nil
 }
 
-func timestampValid(response *Response, thisInstant time.Time) (bool, error) {
-	notOnOrAfter, err := time.Parse(time.RFC3339, response.Assertion.Conditions.NotOnOrAfter)
-	if err != nil {
-		return false, errors.Wrap(err, "validating response timestamp")
+// timestampValid checks the times in the response and its assertion, allowing for a difference
+// of up to skew between our clock and the IDP's. Optional timestamps that are not present are not
+// checked.
+func timestampValid(response *Response, thisInstant time.Time, skew time.Duration) (bool, error) {
+	for _, issueInstant := range []DateTime{response.IssueInstant, response.Assertion.IssueInstant} {
+		ok, err := issueInstantValid(issueInstant, thisInstant, skew)
+		if err != nil || !ok {
+			return false, err
+		}
 	}
-	notBefore, err := time.Parse(time.RFC3339, response.Assertion.Conditions.NotBefore)
-	if err != nil {
-		return false, errors.Wrap(err, "validating response timestamp")
+	notBefore := []DateTime{
+		response.Assertion.Conditions.NotBefore,
+	}
+	for _, instant := range notBefore {
+		if !instant.IsZero() && thisInstant.Before(instant.Add(-skew)) {
+			return false, nil
+		}
+	}
+	notOnOrAfter := []DateTime{
+		response.Assertion.Conditions.NotOnOrAfter,
+		response.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.NotOnOrAfter,
+		response.Assertion.AuthnStatement.SessionNotOnOrAfter,
+	}
+	for _, instant := range notOnOrAfter {
+		if !instant.IsZero() && !thisInstant.Before(instant.Add(skew)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// issueInstantValid checks that a message was issued recently, allowing for clock skew
+// between the service provider and IDP.
+func issueInstantValid(issueInstant DateTime, thisInstant time.Time, skew time.Duration) (bool, error) {
+	if issueInstant.IsZero() {
+		return false, errors.New("missing issue instant")
+	}
+	if issueInstant.After(thisInstant.Add(skew)) {
+		return false, nil
+	}
+	return !thisInstant.After(issueInstant.Add(maxIssueDelay + skew)), nil
+}
+
+// DecodeMessage decodes the value of a SAMLRequest or SAMLResponse parameter. The message may
+// be deflated and base64 encoded as it is when sent with the redirect binding, or only base64
+// encoded as it is with the post binding.
+func DecodeMessage(encoded string) (string, error) {
+	return decodeBindingMessage(encoded, postBinding)
+}
+
+// decodeBindingMessage decodes a message sent using binding. Messages sent with the post binding
+// are only base64 encoded, but some senders deflate them anyway so if the decoded message is not XML
+// it is inflated.
+func decodeBindingMessage(encoded, binding string) (string, error) {
+	if binding == postBinding {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", errors.Wrap(err, "base 64 decode form")
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(decoded), []byte("<")) {
+			return string(decoded), nil
+		}
 	}
-	return thisInstant.After(notBefore) && thisInstant.Before(notOnOrAfter), nil
+	return inflate(encoded)
 }
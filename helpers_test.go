@@ -1,6 +1,8 @@
 package saml
 
 import (
+	"bytes"
+	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
@@ -54,3 +56,99 @@ func TestGetMetadataURLTimeout(t *testing.T) {
 	require.NotNil(t, err)
 	assert.Regexp(t, regexp.MustCompile(`^getting metadata`), err.Error())
 }
+
+func TestIssueInstantValid(t *testing.T) {
+	now := time.Date(2017, 6, 11, 20, 30, 0, 0, time.UTC)
+	var tests = []struct {
+		issueInstant string
+		valid        bool
+	}{
+		{"2017-06-11T20:30:00Z", true},
+		{"2017-06-11T20:29:00Z", true},
+		{"2017-06-11T20:31:00Z", true},
+		{"2017-06-11T20:35:00Z", false},
+		{"2017-06-11T20:25:00Z", false},
+	}
+	for _, tt := range tests {
+		issueInstant, err := ParseDateTime(tt.issueInstant)
+		require.Nil(t, err)
+		valid, err := issueInstantValid(issueInstant, now, DefaultClockSkew)
+		require.Nil(t, err)
+		assert.Equal(t, tt.valid, valid, tt.issueInstant)
+	}
+	_, err := issueInstantValid(DateTime{}, now, DefaultClockSkew)
+	assert.NotNil(t, err)
+}
+
+func TestTimestampValid(t *testing.T) {
+	response, err := decodeAuthResponse(getFormAuthResponse(t))
+	require.Nil(t, err)
+	var tests = []struct {
+		instant time.Time
+		skew    time.Duration
+		valid   bool
+	}{
+		{time.Date(2017, 5, 29, 0, 6, 0, 0, time.UTC), DefaultClockSkew, true},
+		// response issued in the future
+		{time.Date(2017, 5, 29, 0, 6, 0, 0, time.UTC), 0, false},
+		{time.Date(2017, 5, 29, 0, 7, 0, 0, time.UTC), 0, true},
+		// response issued too long ago
+		{time.Date(2017, 5, 29, 0, 8, 13, 0, time.UTC), 0, false},
+		{time.Date(2017, 5, 29, 0, 12, 0, 0, time.UTC), DefaultClockSkew, false},
+	}
+	for _, tt := range tests {
+		valid, err := timestampValid(response, tt.instant, tt.skew)
+		require.Nil(t, err)
+		assert.Equal(t, tt.valid, valid, tt.instant.String())
+	}
+}
+
+func TestTimestampValidOptionalConditions(t *testing.T) {
+	now := time.Date(2017, 5, 29, 0, 6, 0, 0, time.UTC)
+	var response Response
+	response.IssueInstant = NewDateTime(now)
+	response.Assertion.IssueInstant = NewDateTime(now)
+	valid, err := timestampValid(&response, now, 0)
+	require.Nil(t, err)
+	assert.True(t, valid)
+
+	// NotBefore is inclusive
+	response.Assertion.Conditions.NotBefore = NewDateTime(now)
+	valid, err = timestampValid(&response, now, 0)
+	require.Nil(t, err)
+	assert.True(t, valid)
+
+	// NotOnOrAfter is exclusive
+	response.Assertion.Conditions.NotOnOrAfter = NewDateTime(now)
+	valid, err = timestampValid(&response, now, 0)
+	require.Nil(t, err)
+	assert.False(t, valid)
+	valid, err = timestampValid(&response, now, time.Second)
+	require.Nil(t, err)
+	assert.True(t, valid)
+
+	response.Assertion.AuthnStatement.SessionNotOnOrAfter = NewDateTime(now.Add(-time.Minute))
+	valid, err = timestampValid(&response, now, time.Second)
+	require.Nil(t, err)
+	assert.False(t, valid)
+
+	response.Assertion.IssueInstant = DateTime{}
+	_, err = timestampValid(&response, now, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeMessage(t *testing.T) {
+	message := `<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"/>`
+	deflated, err := deflate(bytes.NewBufferString(message))
+	require.Nil(t, err)
+	for name, encoded := range map[string]string{
+		"redirect": deflated,
+		"post":     base64.StdEncoding.EncodeToString([]byte(message)),
+	} {
+		decoded, err := DecodeMessage(encoded)
+		require.Nil(t, err, name)
+		assert.Equal(t, message, decoded, name)
+	}
+	_, err = DecodeMessage("not base64!")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,301 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/x509"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+)
+
+// IdentityProvider describes this identity provider and the service providers that are
+// allowed to use it. It is intended for internal and test environments where the IDP is
+// implemented with this package. The IdentityProvider must not be modified while it is in use.
+type IdentityProvider struct {
+	// EntityID uniquely identifies the IDP, it is the Issuer of messages the IDP sends.
+	EntityID string
+	// SingleSignOnServiceURL is the URL of the IDP handler for AuthnRequests.
+	SingleSignOnServiceURL string
+	// SingleLogoutServiceURL is the URL of the IDP handler for logout requests and responses.
+	SingleLogoutServiceURL string
+	// NameIDFormats are the user identifier formats supported by the IDP.
+	NameIDFormats []string
+	// SigningKey is the private key used to sign messages sent to service providers. Only the
+	// crypto.Signer interface is used so the key may be held in an HSM or KMS.
+	SigningKey crypto.Signer
+	// SigningCertificate is the certificate corresponding to SigningKey.
+	SigningCertificate *x509.Certificate
+	// EncryptionCertificate, if present, is published in metadata for service providers that
+	// encrypt messages sent to the IDP.
+	EncryptionCertificate *x509.Certificate
+	// Attributes are the names of the attributes the IDP may include in assertions, they are
+	// published in metadata.
+	Attributes []string
+	// SignatureMethod is the algorithm used to sign messages. If empty SHA-256 is used with the
+	// algorithm matching SigningKey.
+	SignatureMethod string
+	// ResponseSignatures determines which elements of responses are signed. If zero only
+	// assertions are signed.
+	ResponseSignatures SignaturePolicy
+	// EncryptAssertions encrypts assertions with the encryption certificate of the service provider.
+	EncryptAssertions bool
+	// EncryptionMethod is the algorithm used to encrypt assertions. If empty AES-256-GCM is used.
+	// Service providers using this package only accept AES-CBC if WithCBCDecryption is supplied.
+	EncryptionMethod string
+	// AssertionLifetime is the time an assertion may be used for after it is issued. If zero
+	// DefaultAssertionLifetime is used.
+	AssertionLifetime time.Duration
+	// WantAuthnRequestsSigned rejects AuthnRequests that are not signed. Requests are always
+	// rejected if they are unsigned and the SP metadata says that AuthnRequestsSigned.
+	WantAuthnRequestsSigned bool
+	// ServiceProviders contains the metadata of the service providers known to the IDP.
+	ServiceProviders []*SPEntityDescriptor
+	// Sessions, if not nil, records the sessions established by responses so that service
+	// providers can be asked to end them when the principal logs out.
+	Sessions SessionStore
+	// ClockSkew is the allowance made for differences between the clocks of the IDP and service
+	// providers. If zero DefaultClockSkew is used.
+	ClockSkew time.Duration
+	// MetadataValidity is how long signed metadata is valid for, it is published as validUntil. If
+	// zero DefaultMetadataValidity is used.
+	MetadataValidity time.Duration
+	// MetadataCacheDuration is published in signed metadata as cacheDuration, the time service
+	// providers may cache the metadata before fetching it again. If zero
+	// DefaultMetadataCacheDuration is used.
+	MetadataCacheDuration time.Duration
+	// SignatureMethods and DigestMethods are the algorithms accepted in signed requests, if nil
+	// DefaultSignatureMethods and DefaultDigestMethods are used.
+	SignatureMethods []string
+	DigestMethods    []string
+}
+
+// ReceivedAuthnRequest is a validated AuthnRequest.
+type ReceivedAuthnRequest struct {
+	// Request is the AuthnRequest sent by the service provider.
+	Request *AuthnRequest
+	// ServiceProvider is the metadata of the service provider that sent the request.
+	ServiceProvider *SPEntityDescriptor
+	// AssertionConsumerService is the endpoint the response must be sent to.
+	AssertionConsumerService *AssertionConsumerService
+	// RelayState must be returned unaltered with the response.
+	RelayState string
+	// Binding is the binding used to send the request.
+	Binding string
+	// Signed is true if the request signature was verified.
+	Signed bool
+}
+
+var (
+	// ErrUnknownServiceProvider occurs when a message is received from a service provider that
+	// is not registered with the IDP.
+	ErrUnknownServiceProvider = errors.New("unknown service provider")
+	// ErrNoSession occurs when a service provider asks to log out a principal that does not have
+	// a session at that service provider.
+	ErrNoSession = errors.New("service provider does not have a session for the principal")
+	// ErrIDPSigningNotConfigured occurs when a message must be signed but the IdentityProvider
+	// does not have a signing key.
+	ErrIDPSigningNotConfigured = errors.New("identity provider signing key not configured")
+)
+
+// HandleAuthnRequest decodes and validates an AuthnRequest sent using the redirect or post
+// binding. The issuer must be a registered service provider, signatures are verified using the
+// service provider metadata and the assertion consumer service must be one of its registered
+// endpoints. Errors that are the result of an invalid request are ValidationErrors.
+func (idp *IdentityProvider) HandleAuthnRequest(r *http.Request, thisInstant time.Time) (*ReceivedAuthnRequest, error) {
+	root, binding, err := readMessage(r, RequestQueryKey, "AuthnRequest")
+	if err != nil {
+		return nil, err
+	}
+	var request AuthnRequest
+	err = decodeElement(root, &request)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding authn request"))
+	}
+	issuer := strings.TrimSpace(request.Issuer.Url)
+	sp := idp.serviceProvider(issuer)
+	if sp == nil {
+		return nil, &ValidationError{Reason: ReasonWrongIssuer, Actual: issuer, Err: ErrUnknownServiceProvider}
+	}
+	received := &ReceivedAuthnRequest{
+		ServiceProvider: sp,
+		RelayState:      r.FormValue(RelayStateQueryKey),
+		Binding:         binding,
+	}
+	verified, err := idp.verifyMessageSignature(r, root, sp, binding, thisInstant)
+	if err != nil {
+		return nil, newValidationError(ReasonBadSignature, errors.Wrap(err, "validating authn request signature"))
+	}
+	if verified != nil {
+		received.Signed = true
+		// only the signed content is used
+		request = AuthnRequest{}
+		err = decodeElement(verified, &request)
+		if err != nil {
+			return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding signed authn request"))
+		}
+	} else if idp.WantAuthnRequestsSigned || sp.SPSSODescriptor.AuthnRequestsSigned {
+		return nil, newValidationError(ReasonBadSignature, errors.New("authn request is not signed"))
+	}
+	received.Request = &request
+
+	if request.Destination != "" && request.Destination != idp.SingleSignOnServiceURL {
+		return nil, newMismatchError(ReasonWrongDestination, idp.SingleSignOnServiceURL, request.Destination)
+	}
+	ok, err := issueInstantValid(request.IssueInstant, thisInstant, idp.clockSkew())
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "validating authn request"))
+	}
+	if !ok {
+		return nil, newValidationError(ReasonExpired, errors.New("authn request issue instant is not valid"))
+	}
+	received.AssertionConsumerService, err = getAssertionConsumerService(sp, &request)
+	if err != nil {
+		return nil, err
+	}
+	return received, nil
+}
+
+// verifyMessageSignature verifies the query signature and the XML signature of a message from sp, if
+// present. If the message is signed the verified element is returned. r is only used for messages
+// sent with the redirect binding.
+func (idp *IdentityProvider) verifyMessageSignature(r *http.Request, root *etree.Element, sp *SPEntityDescriptor, binding string, thisInstant time.Time) (*etree.Element, error) {
+	querySigned := binding == redirectBinding && r.URL.Query().Get(SignatureQueryKey) != ""
+	xmlSigned := root.SelectElement("Signature") != nil
+	if !querySigned && !xmlSigned {
+		return nil, nil
+	}
+	err := checkWrapping(root)
+	if err != nil {
+		return nil, err
+	}
+	var certificates certificatePolicy
+	validator, err := certificates.newSignatureValidator(sp.SPSSODescriptor.KeyDescriptors, thisInstant)
+	if err != nil {
+		return nil, err
+	}
+	algorithms := idp.allowedAlgorithms()
+	if querySigned {
+		err = algorithms.verifyRedirectSignature(r.URL.RawQuery, validator.certs)
+		if err != nil {
+			return nil, err
+		}
+		if !xmlSigned {
+			return root, nil
+		}
+	}
+	err = algorithms.checkXMLSignatures(root)
+	if err != nil {
+		return nil, err
+	}
+	return validator.Validate(root)
+}
+
+// readMessage decodes the message in the form value key of r, which was sent with the redirect or
+// post binding. The root of the message must be the protocol element tag. The root and binding
+// are returned.
+func readMessage(r *http.Request, key, tag string) (*etree.Element, string, error) {
+	err := parseBindingForm(r)
+	if err != nil {
+		return nil, "", newValidationError(ReasonMalformed, err)
+	}
+	encoded := r.FormValue(key)
+	if encoded == "" {
+		return nil, "", newValidationError(ReasonMalformed, errors.Errorf("missing %s", key))
+	}
+	binding := redirectBinding
+	if r.Method == http.MethodPost {
+		binding = postBinding
+	}
+	decoded, err := decodeBindingMessage(encoded, binding)
+	if err != nil {
+		return nil, "", newValidationError(ReasonMalformed, errors.Wrapf(err, "decoding %s", key))
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromString(decoded)
+	if err != nil {
+		return nil, "", newValidationError(ReasonMalformed, errors.Wrapf(err, "parsing %s", key))
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != tag || root.NamespaceURI() != samlProtocalNamespace {
+		return nil, "", newValidationError(ReasonMalformed, errors.Errorf("message is not a %s", tag))
+	}
+	return root, binding, nil
+}
+
+func (idp *IdentityProvider) serviceProvider(entityID string) *SPEntityDescriptor {
+	for _, sp := range idp.ServiceProviders {
+		if sp.EntityID == entityID {
+			return sp
+		}
+	}
+	return nil
+}
+
+func (idp *IdentityProvider) clockSkew() time.Duration {
+	if idp.ClockSkew == 0 {
+		return DefaultClockSkew
+	}
+	return idp.ClockSkew
+}
+
+func (idp *IdentityProvider) allowedAlgorithms() allowedAlgorithms {
+	algorithms := defaultAllowedAlgorithms()
+	if idp.SignatureMethods != nil {
+		algorithms.signatureMethods = idp.SignatureMethods
+	}
+	if idp.DigestMethods != nil {
+		algorithms.digestMethods = idp.DigestMethods
+	}
+	return algorithms
+}
+
+// getAssertionConsumerService finds the endpoint of sp requested by request. If the request does
+// not name one the default endpoint is used, that is the one marked isDefault or otherwise the
+// endpoint with the lowest index. Only endpoints using the post binding are supported.
+func getAssertionConsumerService(sp *SPEntityDescriptor, request *AuthnRequest) (*AssertionConsumerService, error) {
+	var services []*AssertionConsumerService
+	for i := range sp.SPSSODescriptor.AssertionConsumerServices {
+		svc := &sp.SPSSODescriptor.AssertionConsumerServices[i]
+		if svc.Binding == postBinding {
+			services = append(services, svc)
+		}
+	}
+	if len(services) == 0 {
+		return nil, newValidationError(ReasonWrongRecipient, errors.New("service provider has no post binding assertion consumer service"))
+	}
+	switch {
+	case request.AssertionConsumerServiceURL != "":
+		var locations []string
+		for _, svc := range services {
+			if svc.Location == request.AssertionConsumerServiceURL {
+				return svc, nil
+			}
+			locations = append(locations, svc.Location)
+		}
+		return nil, newMismatchError(ReasonWrongRecipient, strings.Join(locations, " "), request.AssertionConsumerServiceURL)
+	case request.AssertionConsumerServiceIndex != "":
+		index, err := strconv.Atoi(request.AssertionConsumerServiceIndex)
+		if err == nil {
+			for _, svc := range services {
+				if svc.Index == index {
+					return svc, nil
+				}
+			}
+		}
+		return nil, newMismatchError(ReasonWrongRecipient, "", request.AssertionConsumerServiceIndex)
+	}
+	sort.SliceStable(services, func(i, j int) bool {
+		return services[i].Index < services[j].Index
+	})
+	for _, svc := range services {
+		if svc.IsDefault {
+			return svc, nil
+		}
+	}
+	return services[0], nil
+}
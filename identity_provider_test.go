@@ -0,0 +1,225 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSSOURL = "https://idp.example.com/sso"
+
+func getTestAuthnRequest(sp *ServiceProvider, thisInstant time.Time) AuthnRequest {
+	return AuthnRequest{
+		XMLName: xml.Name{
+			Local: "samlp:AuthnRequest",
+		},
+		ID:                          "id-1234",
+		SAMLP:                       samlProtocalNamespace,
+		SAML:                        samlNamespace,
+		AssertionConsumerServiceURL: sp.AssertionConsumerServiceURL,
+		Destination:                 testSSOURL,
+		IssueInstant:                NewDateTime(thisInstant),
+		ProtocolBinding:             postBinding,
+		Version:                     samlVersion,
+		Issuer: Issuer{
+			XMLName: xml.Name{
+				Local: "saml:Issuer",
+			},
+			Url: sp.IssuerURI,
+		},
+	}
+}
+
+// getTestIdentityProvider returns an IDP that knows the metadata of sp.
+func getTestIdentityProvider(sp *ServiceProvider) *IdentityProvider {
+	metadata := NewSingleSignOnProfile(sp, &IDPSSODescriptor{}).Metadata()
+	return &IdentityProvider{
+		EntityID:               "https://idp.example.com",
+		SingleSignOnServiceURL: testSSOURL,
+		ServiceProviders:       []*SPEntityDescriptor{metadata},
+	}
+}
+
+func getRedirectAuthnRequest(t *testing.T, sp *ServiceProvider, request AuthnRequest) *http.Request {
+	var encoded bytes.Buffer
+	err := xml.NewEncoder(&encoded).Encode(request)
+	require.Nil(t, err)
+	location, err := sp.redirectBindingURL(testSSOURL, RequestQueryKey, &encoded, "state")
+	require.Nil(t, err)
+	return httptest.NewRequest(http.MethodGet, location, nil)
+}
+
+func getPostAuthnRequest(t *testing.T, sp *ServiceProvider, request AuthnRequest) *http.Request {
+	encoded, err := sp.encodeSigned(request)
+	require.Nil(t, err)
+	form := url.Values{}
+	form.Set(RequestQueryKey, base64.StdEncoding.EncodeToString(encoded.Bytes()))
+	form.Set(RelayStateQueryKey, "state")
+	r := httptest.NewRequest(http.MethodPost, testSSOURL, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestHandleAuthnRequestRedirect(t *testing.T) {
+	thisInstant := time.Now()
+	sp := &ServiceProvider{
+		IssuerURI:                   "uri:myserviceprovider",
+		AssertionConsumerServiceURL: "https://sp.example.com/acs",
+	}
+	idp := getTestIdentityProvider(sp)
+	r := getRedirectAuthnRequest(t, sp, getTestAuthnRequest(sp, thisInstant))
+
+	received, err := idp.HandleAuthnRequest(r, thisInstant)
+	require.Nil(t, err)
+	assert.Equal(t, "id-1234", received.Request.ID)
+	assert.Equal(t, "state", received.RelayState)
+	assert.Equal(t, redirectBinding, received.Binding)
+	assert.False(t, received.Signed)
+	assert.Equal(t, idp.ServiceProviders[0], received.ServiceProvider)
+	assert.Equal(t, "https://sp.example.com/acs", received.AssertionConsumerService.Location)
+}
+
+func TestHandleAuthnRequestSigned(t *testing.T) {
+	thisInstant := time.Now()
+	sp := getRSAServiceProvider(t)
+	sp.AssertionConsumerServiceURL = "https://sp.example.com/acs"
+	idp := getTestIdentityProvider(sp)
+	idp.WantAuthnRequestsSigned = true
+
+	for name, r := range map[string]*http.Request{
+		"redirect": getRedirectAuthnRequest(t, sp, getTestAuthnRequest(sp, thisInstant)),
+		"post":     getPostAuthnRequest(t, sp, getTestAuthnRequest(sp, thisInstant)),
+	} {
+		received, err := idp.HandleAuthnRequest(r, thisInstant)
+		require.Nil(t, err, name)
+		assert.True(t, received.Signed, name)
+		assert.Equal(t, "id-1234", received.Request.ID, name)
+	}
+
+	// signed by a key the IDP does not know
+	other := getRSAServiceProvider(t)
+	other.AssertionConsumerServiceURL = sp.AssertionConsumerServiceURL
+	r := getPostAuthnRequest(t, other, getTestAuthnRequest(other, thisInstant))
+	_, err := idp.HandleAuthnRequest(r, thisInstant)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrBadSignature))
+}
+
+func TestHandleAuthnRequestDuplicateParameters(t *testing.T) {
+	thisInstant := time.Now()
+	sp := getRSAServiceProvider(t)
+	sp.AssertionConsumerServiceURL = "https://sp.example.com/acs"
+	idp := getTestIdentityProvider(sp)
+
+	redirect := getRedirectAuthnRequest(t, sp, getTestAuthnRequest(sp, thisInstant))
+	redirect.URL.RawQuery += "&" + RelayStateQueryKey + "=other"
+	post := getPostAuthnRequest(t, sp, getTestAuthnRequest(sp, thisInstant))
+	post.URL.RawQuery = RequestQueryKey + "=other"
+	for name, r := range map[string]*http.Request{"redirect": redirect, "post": post} {
+		_, err := idp.HandleAuthnRequest(r, thisInstant)
+		assert.True(t, errors.Is(err, ErrMalformed), name)
+	}
+}
+
+func TestHandleAuthnRequestSignatureRequired(t *testing.T) {
+	thisInstant := time.Now()
+	sp := &ServiceProvider{
+		IssuerURI:                   "uri:myserviceprovider",
+		AssertionConsumerServiceURL: "https://sp.example.com/acs",
+	}
+	idp := getTestIdentityProvider(sp)
+	idp.ServiceProviders[0].SPSSODescriptor.AuthnRequestsSigned = true
+
+	r := getPostAuthnRequest(t, sp, getTestAuthnRequest(sp, thisInstant))
+	_, err := idp.HandleAuthnRequest(r, thisInstant)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrBadSignature))
+}
+
+func TestHandleAuthnRequestValidationErrors(t *testing.T) {
+	thisInstant := time.Now()
+	sp := &ServiceProvider{
+		IssuerURI:                   "uri:myserviceprovider",
+		AssertionConsumerServiceURL: "https://sp.example.com/acs",
+	}
+	idp := getTestIdentityProvider(sp)
+
+	tests := map[string]struct {
+		modify func(*AuthnRequest)
+		err    error
+	}{
+		"unknown issuer": {
+			modify: func(r *AuthnRequest) { r.Issuer.Url = "uri:unknown" },
+			err:    ErrWrongIssuer,
+		},
+		"wrong destination": {
+			modify: func(r *AuthnRequest) { r.Destination = "https://other.example.com/sso" },
+			err:    ErrWrongDestination,
+		},
+		"expired": {
+			modify: func(r *AuthnRequest) { r.IssueInstant = NewDateTime(thisInstant.Add(-time.Hour)) },
+			err:    ErrExpired,
+		},
+		"unregistered acs": {
+			modify: func(r *AuthnRequest) { r.AssertionConsumerServiceURL = "https://evil.example.com/acs" },
+			err:    ErrWrongRecipient,
+		},
+		"unknown acs index": {
+			modify: func(r *AuthnRequest) {
+				r.AssertionConsumerServiceURL = ""
+				r.AssertionConsumerServiceIndex = "7"
+			},
+			err: ErrWrongRecipient,
+		},
+	}
+	for name, test := range tests {
+		request := getTestAuthnRequest(sp, thisInstant)
+		test.modify(&request)
+		_, err := idp.HandleAuthnRequest(getRedirectAuthnRequest(t, sp, request), thisInstant)
+		require.NotNil(t, err, name)
+		assert.True(t, errors.Is(err, test.err), name)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, testSSOURL, nil)
+	_, err := idp.HandleAuthnRequest(r, thisInstant)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrMalformed))
+}
+
+func TestGetAssertionConsumerService(t *testing.T) {
+	sp := &SPEntityDescriptor{
+		SPSSODescriptor: SPSSODescriptor{
+			AssertionConsumerServices: []AssertionConsumerService{
+				{Binding: redirectBinding, Location: "https://sp.example.com/redirect", Index: 0},
+				{Binding: postBinding, Location: "https://sp.example.com/two", Index: 2},
+				{Binding: postBinding, Location: "https://sp.example.com/one", Index: 1},
+			},
+		},
+	}
+	svc, err := getAssertionConsumerService(sp, &AuthnRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, "https://sp.example.com/one", svc.Location)
+
+	sp.SPSSODescriptor.AssertionConsumerServices[1].IsDefault = true
+	svc, err = getAssertionConsumerService(sp, &AuthnRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, "https://sp.example.com/two", svc.Location)
+
+	svc, err = getAssertionConsumerService(sp, &AuthnRequest{AssertionConsumerServiceIndex: "1"})
+	require.Nil(t, err)
+	assert.Equal(t, "https://sp.example.com/one", svc.Location)
+
+	_, err = getAssertionConsumerService(sp, &AuthnRequest{AssertionConsumerServiceURL: "https://sp.example.com/redirect"})
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrWrongRecipient))
+}
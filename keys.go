@@ -0,0 +1,51 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// ParsePrivateKeyPEM parses the first private key in pemBytes, returning an *rsa.PrivateKey or
+// *ecdsa.PrivateKey for use as a SigningKey. RSA keys also satisfy crypto.Decrypter and may be
+// used as a DecryptionKey. PKCS #1, PKCS #8 and SEC 1 EC private keys are accepted. Keys held in
+// an HSM or KMS do not need this, any crypto.Signer or crypto.Decrypter may be used instead.
+func ParsePrivateKeyPEM(pemBytes []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			return nil, errors.New("no private key found in PEM data")
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing PKCS #1 private key")
+			}
+			return key, nil
+		case "EC PRIVATE KEY":
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing EC private key")
+			}
+			return key, nil
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing PKCS #8 private key")
+			}
+			switch key := key.(type) {
+			case *rsa.PrivateKey:
+				return key, nil
+			case *ecdsa.PrivateKey:
+				return key, nil
+			}
+			return nil, errors.Errorf("unsupported private key type %T", key)
+		}
+	}
+}
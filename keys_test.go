@@ -0,0 +1,59 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.Nil(t, err)
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	require.Nil(t, err)
+
+	tt := []struct {
+		name   string
+		block  *pem.Block
+		public crypto.PublicKey
+	}{
+		{"pkcs1", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, rsaKey.Public()},
+		{"ec", &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, ecKey.Public()},
+		{"pkcs8", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}, ecKey.Public()},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// keys are often stored after the certificate
+			data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("not a cert")})
+			data = append(data, pem.EncodeToMemory(tc.block)...)
+			key, err := ParsePrivateKeyPEM(data)
+			require.Nil(t, err)
+			assert.Equal(t, tc.public, key.Public())
+		})
+	}
+
+	_, err = ParsePrivateKeyPEM([]byte("garbage"))
+	assert.NotNil(t, err)
+}
+
+func TestParsePrivateKeyPEMUnsupported(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.Nil(t, err)
+	_, err = ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.NotNil(t, err)
+}
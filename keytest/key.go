@@ -0,0 +1,129 @@
+// Package keytest provides a fake hardware key for testing code that signs or decrypts
+// through crypto.Signer and crypto.Decrypter, such as an HSM or KMS backed key.
+package keytest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Key is a fake HSM key. Like a hardware key the private key can not be retrieved, it
+// counts the operations performed with it and can be made to fail.
+type Key struct {
+	mu          sync.Mutex
+	private     crypto.Signer
+	signatures  int
+	decryptions int
+	err         error
+}
+
+// NewRSAKey returns a fake key holding a new 2048 bit RSA key.
+func NewRSAKey() (*Key, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating RSA key")
+	}
+	return &Key{private: key}, nil
+}
+
+// NewECDSAKey returns a fake key holding a new P-256 ECDSA key.
+func NewECDSAKey() (*Key, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating ECDSA key")
+	}
+	return &Key{private: key}, nil
+}
+
+// Public returns the public key.
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// Sign signs digest, or returns the error set with SetError.
+func (k *Key) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err != nil {
+		return nil, k.err
+	}
+	k.signatures++
+	return k.private.Sign(rand, digest, opts)
+}
+
+// Decrypt decrypts ciphertext with an RSA key, or returns the error set with SetError.
+func (k *Key) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err != nil {
+		return nil, k.err
+	}
+	decrypter, ok := k.private.(crypto.Decrypter)
+	if !ok {
+		return nil, errors.New("key does not support decryption")
+	}
+	k.decryptions++
+	return decrypter.Decrypt(rand, ciphertext, opts)
+}
+
+// SetError makes subsequent operations fail with err, simulating an unavailable HSM. A nil
+// err restores normal operation.
+func (k *Key) SetError(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.err = err
+}
+
+// Signatures returns the number of signatures made with the key.
+func (k *Key) Signatures() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.signatures
+}
+
+// Decryptions returns the number of decryptions made with the key.
+func (k *Key) Decryptions() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.decryptions
+}
+
+// Certificate returns a self signed certificate for the key that is valid from now for
+// the given duration.
+func (k *Key) Certificate(commonName string, validFor time.Duration) (*x509.Certificate, error) {
+	now := time.Now()
+	return k.CertificateBetween(commonName, now.Add(-time.Minute), now.Add(validFor))
+}
+
+// CertificateBetween returns a self signed certificate for the key that is valid from notBefore
+// until notAfter.
+func (k *Key) CertificateBetween(commonName string, notBefore, notAfter time.Time) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, errors.Wrap(err, "generating serial number")
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, k.Public(), k)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating certificate")
+	}
+	return x509.ParseCertificate(der)
+}
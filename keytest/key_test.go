@@ -0,0 +1,52 @@
+package keytest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	key, err := NewRSAKey()
+	require.Nil(t, err)
+	cert, err := key.Certificate("uri:myserviceprovider", time.Hour)
+	require.Nil(t, err)
+	assert.Equal(t, 1, key.Signatures())
+	assert.Equal(t, key.Public(), cert.PublicKey)
+	notBefore := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert, err = key.CertificateBetween("uri:myserviceprovider", notBefore, notBefore.AddDate(100, 0, 0))
+	require.Nil(t, err)
+	assert.True(t, cert.NotBefore.Equal(notBefore))
+	assert.Equal(t, 2100, cert.NotAfter.Year())
+	assert.Equal(t, 2, key.Signatures())
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key.Public().(*rsa.PublicKey), []byte("secret"), nil)
+	require.Nil(t, err)
+	plaintext, err := key.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	require.Nil(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+	assert.Equal(t, 1, key.Decryptions())
+
+	unavailable := errors.New("hsm unavailable")
+	key.SetError(unavailable)
+	digest := sha256.Sum256([]byte("message"))
+	_, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Equal(t, unavailable, err)
+	key.SetError(nil)
+	_, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Nil(t, err)
+}
+
+func TestECDSAKeyDecrypt(t *testing.T) {
+	key, err := NewECDSAKey()
+	require.Nil(t, err)
+	_, err = key.Decrypt(rand.Reader, []byte("x"), nil)
+	assert.NotNil(t, err)
+}
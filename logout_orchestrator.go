@@ -0,0 +1,622 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	soapEnvelopeNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soapAction            = "http://www.oasis-open.org/committees/security"
+
+	// defaultSOAPTimeout limits the time taken by each back channel request unless WithTimeout
+	// is supplied.
+	defaultSOAPTimeout = 30 * time.Second
+	// maxSOAPResponseSize is the largest SOAP response read from a participant.
+	maxSOAPResponseSize = 1 << 20
+)
+
+// LogoutStep is the result of each stage of single logout at the IDP. Front channel logout
+// proceeds by sending the browser to each participant in turn, the participant returns the
+// browser to the IDP with its LogoutResponse.
+type LogoutStep struct {
+	// RedirectURL, if not empty, is where the browser must be redirected.
+	RedirectURL string
+	// PostForm, if not nil, must be written to the browser.
+	PostForm *PostForm
+	// Complete is true once every participant has been asked to log out. The final step sends
+	// the LogoutResponse to the service provider that initiated logout, or redirects to the
+	// relay URL of logout initiated by the IDP.
+	Complete bool
+	// PartialLogout is true if one or more participants could not be logged out.
+	PartialLogout bool
+}
+
+// LogoutOrchestrator propagates logout to each service provider where the principal has a
+// session recorded in the Sessions of the IdentityProvider. Service providers with a SOAP single
+// logout endpoint are logged out over the back channel, the others with the redirect or post
+// bindings through the browser. Logouts in progress are held in memory so a user must return to
+// the same host.
+type LogoutOrchestrator struct {
+	idp           *IdentityProvider
+	client        http.Client
+	allowUnsigned bool
+	mtx           sync.Mutex
+	logouts       map[string]*logoutState
+}
+
+// logoutState is a logout that is waiting for a front channel LogoutResponse.
+type logoutState struct {
+	id           string
+	issueInstant time.Time
+	// initiator is the service provider that requested logout, nil if the IDP initiated it.
+	initiator        *SPEntityDescriptor
+	initiatorBinding string
+	requestID        string
+	relayState       string
+	relayURL         string
+	pending          []*ParticipantSession
+	current          *ParticipantSession
+	currentRequestID string
+	partial          bool
+}
+
+// NewLogoutOrchestrator creates a LogoutOrchestrator for idp. Optionally WithTimeout may be
+// supplied to change the time allowed for each back channel request, 30 seconds by default, and
+// WithUnsignedLogoutRequests to accept unsigned LogoutRequests from service providers.
+func NewLogoutOrchestrator(idp *IdentityProvider, opts ...func() interface{}) *LogoutOrchestrator {
+	lo := &LogoutOrchestrator{
+		idp:     idp,
+		client:  http.Client{Timeout: defaultSOAPTimeout},
+		logouts: map[string]*logoutState{},
+	}
+	for _, opt := range opts {
+		switch t := opt().(type) {
+		case httpClientTimeout:
+			lo.client.Timeout = time.Duration(t)
+		case unsignedLogoutRequests:
+			lo.allowUnsigned = bool(t)
+		}
+	}
+	return lo
+}
+
+// HandleLogoutRequest validates a LogoutRequest sent by a service provider using the redirect or
+// post binding and starts logout of the principal's sessions at the other service providers. The
+// sessions of the requesting service provider are removed without being notified. Requests must
+// be signed, in the query or the XML, with a signing key from the service provider metadata
+// unless the orchestrator was created with WithUnsignedLogoutRequests. The requesting service
+// provider must hold a session recorded in Sessions for the NameID of the request, and for one of
+// its SessionIndexes if any are given, otherwise the request fails with ReasonWrongIssuer and
+// ErrNoSession. Errors that are the result of an invalid request are ValidationErrors.
+func (lo *LogoutOrchestrator) HandleLogoutRequest(r *http.Request, thisInstant time.Time) (*LogoutStep, error) {
+	root, binding, err := readMessage(r, RequestQueryKey, "LogoutRequest")
+	if err != nil {
+		return nil, err
+	}
+	var request LogoutRequest
+	err = decodeElement(root, &request)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding logout request"))
+	}
+	idp := lo.idp
+	issuer := strings.TrimSpace(request.Issuer.Url)
+	sp := idp.serviceProvider(issuer)
+	if sp == nil {
+		return nil, &ValidationError{Reason: ReasonWrongIssuer, Actual: issuer, Err: ErrUnknownServiceProvider}
+	}
+	verified, err := idp.verifyMessageSignature(r, root, sp, binding, thisInstant)
+	if err != nil {
+		return nil, newValidationError(ReasonBadSignature, errors.Wrap(err, "validating logout request signature"))
+	}
+	if verified != nil {
+		request = LogoutRequest{}
+		err = decodeElement(verified, &request)
+		if err != nil {
+			return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding signed logout request"))
+		}
+	} else if !lo.allowUnsigned {
+		return nil, newValidationError(ReasonBadSignature, errors.New("logout request is not signed"))
+	}
+	if request.Destination != "" && request.Destination != idp.SingleLogoutServiceURL {
+		return nil, newMismatchError(ReasonWrongDestination, idp.SingleLogoutServiceURL, request.Destination)
+	}
+	ok, err := issueInstantValid(request.IssueInstant, thisInstant, idp.clockSkew())
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "validating logout request"))
+	}
+	if !ok {
+		return nil, newValidationError(ReasonExpired, errors.New("logout request issue instant is not valid"))
+	}
+
+	sessions, err := lo.sessions(request.NameID.Value)
+	if err != nil {
+		return nil, err
+	}
+	// a service provider may only log out principals that have a session with it, otherwise
+	// any service provider could end the sessions of any user
+	var ended []*ParticipantSession
+	for _, session := range sessions {
+		if session.ServiceProvider == sp.EntityID && sameNameID(session.NameID, request.NameID) &&
+			(len(request.SessionIndex) == 0 || contains(request.SessionIndex, session.SessionIndex)) {
+			ended = append(ended, session)
+		}
+	}
+	if len(ended) == 0 {
+		return nil, &ValidationError{Reason: ReasonWrongIssuer, Actual: issuer, Err: ErrNoSession}
+	}
+
+	state, err := newLogoutState(thisInstant)
+	if err != nil {
+		return nil, err
+	}
+	state.initiator = sp
+	state.initiatorBinding = binding
+	state.requestID = request.ID
+	state.relayState = r.FormValue(RelayStateQueryKey)
+	for _, session := range ended {
+		err = lo.idp.Sessions.RemoveSession(session)
+		if err != nil {
+			return nil, errors.Wrap(err, "removing session")
+		}
+	}
+	for _, session := range sessions {
+		if !samePrincipal(session.NameID, request.NameID) || containsSession(ended, session) {
+			continue
+		}
+		state.pending = append(state.pending, session)
+	}
+	return lo.next(state, thisInstant)
+}
+
+// Logout starts logout of every session of the principal identified by the NameID value nameID.
+// Once logout is complete the browser is redirected to relayURL.
+func (lo *LogoutOrchestrator) Logout(nameID, relayURL string, thisInstant time.Time) (*LogoutStep, error) {
+	state, err := newLogoutState(thisInstant)
+	if err != nil {
+		return nil, err
+	}
+	state.relayURL = relayURL
+	state.pending, err = lo.sessions(escapeText(nameID))
+	if err != nil {
+		return nil, err
+	}
+	return lo.next(state, thisInstant)
+}
+
+// HandleLogoutResponse validates the LogoutResponse of a participant that was sent a front
+// channel LogoutRequest, and continues logout with the next participant. A response with a
+// status other than success, or the PartialLogout status, results in PartialLogout.
+func (lo *LogoutOrchestrator) HandleLogoutResponse(r *http.Request, thisInstant time.Time) (*LogoutStep, error) {
+	root, binding, err := readMessage(r, ResponseQueryKey, "LogoutResponse")
+	if err != nil {
+		return nil, err
+	}
+	var response LogoutResponse
+	err = decodeElement(root, &response)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding logout response"))
+	}
+	rs := r.FormValue(RelayStateQueryKey)
+	lo.mtx.Lock()
+	state, ok := lo.logouts[rs]
+	if ok && state.currentRequestID == response.InResponseTo {
+		delete(lo.logouts, rs)
+	}
+	lo.mtx.Unlock()
+	if !ok || state.currentRequestID != response.InResponseTo {
+		return nil, &ValidationError{Reason: ReasonReplay, Actual: response.InResponseTo, Err: ErrUnknownRequest}
+	}
+	if thisInstant.After(state.issueInstant.Add(maxRequestAge + lo.idp.clockSkew())) {
+		return nil, newValidationError(ReasonExpired, errors.New("logout expired"))
+	}
+	validated, err := lo.validateResponse(r, root, binding, state.current, thisInstant)
+	if err == nil && validated.InResponseTo != state.currentRequestID {
+		err = newMismatchError(ReasonReplay, state.currentRequestID, validated.InResponseTo)
+	}
+	if err != nil {
+		// the participant may still send a valid response
+		lo.save(state)
+		return nil, err
+	}
+	if !isStatusSuccess(validated.Status.StatusCode.Value) || isPartialLogout(validated.Status) {
+		state.partial = true
+	}
+	err = lo.idp.Sessions.RemoveSession(state.current)
+	if err != nil {
+		return nil, errors.Wrap(err, "removing session")
+	}
+	return lo.next(state, thisInstant)
+}
+
+// validateResponse checks the issuer, signature, destination and issue instant of a
+// LogoutResponse sent by the participant of session. If the response is signed only the
+// signed content is returned.
+func (lo *LogoutOrchestrator) validateResponse(r *http.Request, root *etree.Element, binding string, session *ParticipantSession, thisInstant time.Time) (*LogoutResponse, error) {
+	idp := lo.idp
+	sp := idp.serviceProvider(session.ServiceProvider)
+	if sp == nil {
+		return nil, &ValidationError{Reason: ReasonWrongIssuer, Actual: session.ServiceProvider, Err: ErrUnknownServiceProvider}
+	}
+	var response LogoutResponse
+	err := decodeElement(root, &response)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding logout response"))
+	}
+	issuer := strings.TrimSpace(response.Issuer.Url)
+	if issuer != sp.EntityID {
+		return nil, newMismatchError(ReasonWrongIssuer, sp.EntityID, issuer)
+	}
+	verified, err := idp.verifyMessageSignature(r, root, sp, binding, thisInstant)
+	if err != nil {
+		return nil, newValidationError(ReasonBadSignature, errors.Wrap(err, "validating logout response signature"))
+	}
+	if verified != nil {
+		response = LogoutResponse{}
+		err = decodeElement(verified, &response)
+		if err != nil {
+			return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding signed logout response"))
+		}
+	}
+	if binding != soapBinding && response.Destination != "" && response.Destination != idp.SingleLogoutServiceURL {
+		return nil, newMismatchError(ReasonWrongDestination, idp.SingleLogoutServiceURL, response.Destination)
+	}
+	ok, err := issueInstantValid(response.IssueInstant, thisInstant, idp.clockSkew())
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "validating logout response"))
+	}
+	if !ok {
+		return nil, newValidationError(ReasonExpired, errors.New("logout response issue instant is not valid"))
+	}
+	return &response, nil
+}
+
+// next sends a LogoutRequest to each pending participant. Participants with a SOAP endpoint are
+// logged out immediately, when a participant must be sent a request through the browser the
+// state is saved until its response arrives. Once there are no pending participants the
+// final step is returned.
+func (lo *LogoutOrchestrator) next(state *logoutState, thisInstant time.Time) (*LogoutStep, error) {
+	for len(state.pending) > 0 {
+		session := state.pending[0]
+		state.pending = state.pending[1:]
+		sp := lo.idp.serviceProvider(session.ServiceProvider)
+		var svc *SingleLogoutService
+		var err error
+		if sp != nil {
+			svc, err = getSingleLogoutService(sp.SPSSODescriptor.SingleLogoutService, soapBinding, redirectBinding, postBinding)
+		}
+		if sp == nil || err != nil {
+			// the participant can't be told to log out
+			state.partial = true
+			err = lo.idp.Sessions.RemoveSession(session)
+			if err != nil {
+				return nil, errors.Wrap(err, "removing session")
+			}
+			continue
+		}
+		request, err := lo.idp.newLogoutRequest(session, svc.Location, thisInstant)
+		if err != nil {
+			return nil, err
+		}
+		if svc.Binding == soapBinding {
+			err = lo.sendSOAP(svc.Location, request, session, thisInstant)
+			if err != nil {
+				state.partial = true
+			}
+			err = lo.idp.Sessions.RemoveSession(session)
+			if err != nil {
+				return nil, errors.Wrap(err, "removing session")
+			}
+			continue
+		}
+		step, err := lo.idp.frontChannelStep(svc.Location, svc.Binding, RequestQueryKey, request, state.id)
+		if err != nil {
+			return nil, errors.Wrap(err, "sending logout request")
+		}
+		state.current = session
+		state.currentRequestID = request.ID
+		lo.save(state)
+		step.PartialLogout = state.partial
+		return step, nil
+	}
+	return lo.complete(state, thisInstant)
+}
+
+// complete returns the final step of logout.
+func (lo *LogoutOrchestrator) complete(state *logoutState, thisInstant time.Time) (*LogoutStep, error) {
+	if state.initiator == nil {
+		return &LogoutStep{RedirectURL: state.relayURL, Complete: true, PartialLogout: state.partial}, nil
+	}
+	svc, err := getSingleLogoutService(state.initiator.SPSSODescriptor.SingleLogoutService, state.initiatorBinding, redirectBinding, postBinding)
+	if err != nil {
+		return nil, err
+	}
+	status := newStatus(Success)
+	if state.partial {
+		status = newStatus(Success, PartialLogout)
+	}
+	responseID, err := getUniqueID()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting id for logout response")
+	}
+	response := &LogoutResponse{
+		XMLName: xml.Name{
+			Local: "samlp:LogoutResponse",
+		},
+		SAMLP:        samlProtocalNamespace,
+		SAML:         samlNamespace,
+		ID:           "_" + responseID,
+		InResponseTo: state.requestID,
+		Destination:  svc.responseLocation(),
+		IssueInstant: NewDateTime(thisInstant),
+		Version:      samlVersion,
+		Issuer:       lo.idp.issuer(),
+		Status:       status,
+	}
+	step, err := lo.idp.frontChannelStep(svc.responseLocation(), svc.Binding, ResponseQueryKey, response, state.relayState)
+	if err != nil {
+		return nil, errors.Wrap(err, "sending logout response")
+	}
+	step.Complete = true
+	step.PartialLogout = state.partial
+	return step, nil
+}
+
+// sendSOAP sends request to the participant of session using the SOAP binding and validates
+// the response. An error is returned if the participant did not log out.
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf Section 3.2
+func (lo *LogoutOrchestrator) sendSOAP(location string, request *LogoutRequest, session *ParticipantSession, thisInstant time.Time) error {
+	context, err := lo.idp.messageSigningContext()
+	if err != nil {
+		return err
+	}
+	encoded, err := lo.idp.encodeMessage(request, context)
+	if err != nil {
+		return err
+	}
+	message := etree.NewDocument()
+	err = message.ReadFromBytes(encoded.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "reading encoded logout request")
+	}
+	doc := etree.NewDocument()
+	envelope := doc.CreateElement("soap11:Envelope")
+	envelope.CreateAttr("xmlns:soap11", soapEnvelopeNamespace)
+	envelope.CreateElement("soap11:Body").AddChild(message.Root())
+	body, err := doc.WriteToBytes()
+	if err != nil {
+		return errors.Wrap(err, "writing soap envelope")
+	}
+	req, err := http.NewRequest(http.MethodPost, location, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "creating soap request")
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", soapAction)
+	resp, err := lo.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sending soap logout request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("soap logout request returned status %d", resp.StatusCode)
+	}
+	responseBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSOAPResponseSize+1))
+	if err != nil {
+		return errors.Wrap(err, "reading soap logout response")
+	}
+	if len(responseBody) > maxSOAPResponseSize {
+		return newValidationError(ReasonMalformed, errors.New("soap logout response is too large"))
+	}
+	doc = etree.NewDocument()
+	err = doc.ReadFromBytes(responseBody)
+	if err != nil {
+		return newValidationError(ReasonMalformed, errors.Wrap(err, "parsing soap logout response"))
+	}
+	root, err := soapBodyMessage(doc, "LogoutResponse")
+	if err != nil {
+		return newValidationError(ReasonMalformed, err)
+	}
+	response, err := lo.validateResponse(nil, root, soapBinding, session, thisInstant)
+	if err != nil {
+		return err
+	}
+	if response.InResponseTo != request.ID {
+		return newMismatchError(ReasonReplay, request.ID, response.InResponseTo)
+	}
+	err = checkStatus(response.Status)
+	if err == nil && isPartialLogout(response.Status) {
+		return ErrPartialLogout
+	}
+	return err
+}
+
+// soapBodyMessage detaches the protocol message tag from the Body of the SOAP envelope doc. The
+// namespaces declared by the envelope are copied to the detached message so that its signature
+// can be validated.
+func soapBodyMessage(doc *etree.Document, tag string) (*etree.Element, error) {
+	envelope := doc.Root()
+	if envelope == nil || envelope.Tag != "Envelope" || envelope.NamespaceURI() != soapEnvelopeNamespace {
+		return nil, errors.New("missing soap envelope")
+	}
+	body := envelope.SelectElement("Body")
+	if body == nil || body.NamespaceURI() != soapEnvelopeNamespace {
+		return nil, errors.New("missing soap body")
+	}
+	var message *etree.Element
+	err := etreeutils.NSFindIterate(envelope, samlProtocalNamespace, tag, func(ctx etreeutils.NSContext, el *etree.Element) error {
+		if el.Parent() != body {
+			return errors.Errorf("%s with unexpected parent: %s", tag, el.Parent().Tag)
+		}
+		detached, err := etreeutils.NSDetatch(ctx, el)
+		if err != nil {
+			return err
+		}
+		message = detached
+		return etreeutils.ErrTraversalHalted
+	})
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, errors.Errorf("soap message does not contain a %s", tag)
+	}
+	return message, nil
+}
+
+func containsSession(sessions []*ParticipantSession, session *ParticipantSession) bool {
+	for _, s := range sessions {
+		if s == session {
+			return true
+		}
+	}
+	return false
+}
+
+// sessions returns the sessions of nameID, or none if the IDP does not record sessions.
+func (lo *LogoutOrchestrator) sessions(nameID string) ([]*ParticipantSession, error) {
+	if lo.idp.Sessions == nil {
+		return nil, nil
+	}
+	sessions, err := lo.idp.Sessions.Sessions(nameID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting sessions")
+	}
+	return sessions, nil
+}
+
+// save stores a logout that is waiting for a response, discarding abandoned logouts.
+func (lo *LogoutOrchestrator) save(state *logoutState) {
+	lo.mtx.Lock()
+	defer lo.mtx.Unlock()
+	for id, s := range lo.logouts {
+		if state.issueInstant.Sub(s.issueInstant) > maxRequestAge {
+			delete(lo.logouts, id)
+		}
+	}
+	lo.logouts[state.id] = state
+}
+
+func newLogoutState(thisInstant time.Time) (*logoutState, error) {
+	id, err := getUniqueID()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting id for logout")
+	}
+	return &logoutState{id: id, issueInstant: thisInstant}, nil
+}
+
+// newLogoutRequest creates a request asking the participant of session to log out.
+func (idp *IdentityProvider) newLogoutRequest(session *ParticipantSession, destination string, thisInstant time.Time) (*LogoutRequest, error) {
+	requestID, err := getUniqueID()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting id for logout request")
+	}
+	request := &LogoutRequest{
+		XMLName: xml.Name{
+			Local: "samlp:LogoutRequest",
+		},
+		ID:           "_" + requestID,
+		SAMLP:        samlProtocalNamespace,
+		SAML:         samlNamespace,
+		Destination:  destination,
+		IssueInstant: NewDateTime(thisInstant),
+		Version:      samlVersion,
+		Issuer:       idp.issuer(),
+		NameID:       session.NameID,
+	}
+	request.NameID.XMLName = xml.Name{Local: "saml:NameID"}
+	if session.SessionIndex != "" {
+		request.SessionIndex = []string{session.SessionIndex}
+	}
+	return request, nil
+}
+
+// frontChannelStep returns the step that delivers message to location through the browser using
+// binding, which is either the redirect or the post binding.
+func (idp *IdentityProvider) frontChannelStep(location, binding, queryKey string, message interface{}, rs string) (*LogoutStep, error) {
+	context, err := idp.messageSigningContext()
+	if err != nil {
+		return nil, err
+	}
+	if binding == postBinding {
+		encoded, err := idp.encodeMessage(message, context)
+		if err != nil {
+			return nil, err
+		}
+		return &LogoutStep{PostForm: postBindingForm(location, queryKey, encoded, rs)}, nil
+	}
+	// redirect binding messages are signed in the query
+	encoded, err := idp.encodeMessage(message, nil)
+	if err != nil {
+		return nil, err
+	}
+	redirectURL, err := redirectBindingURL(context, location, queryKey, encoded, rs)
+	if err != nil {
+		return nil, err
+	}
+	return &LogoutStep{RedirectURL: redirectURL}, nil
+}
+
+// messageSigningContext returns the context used to sign logout messages, or nil if the IDP
+// does not have a signing key.
+func (idp *IdentityProvider) messageSigningContext() (*dsig.SigningContext, error) {
+	if idp.SigningKey == nil {
+		return nil, nil
+	}
+	return idp.getSigningContext()
+}
+
+// EncodeMessage encodes a protocol message sent by the IDP, such as a LogoutRequest, for delivery
+// with the post or SOAP bindings. If the IDP has a signing key the message is signed.
+func (idp *IdentityProvider) EncodeMessage(message interface{}) ([]byte, error) {
+	context, err := idp.messageSigningContext()
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := idp.encodeMessage(message, context)
+	if err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+// encodeMessage encodes a protocol message sent by the IDP, adding an enveloped signature if
+// context is not nil.
+func (idp *IdentityProvider) encodeMessage(message interface{}, context *dsig.SigningContext) (*bytes.Buffer, error) {
+	var encoded bytes.Buffer
+	err := xml.NewEncoder(&encoded).Encode(message)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding message")
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(encoded.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "reading encoded message")
+	}
+	root := doc.Root()
+	qualify(root)
+	if context != nil {
+		root, err = signEnveloped(context, root)
+		if err != nil {
+			return nil, err
+		}
+		doc.SetRoot(root)
+	}
+	var result bytes.Buffer
+	_, err = doc.WriteTo(&result)
+	if err != nil {
+		return nil, errors.Wrap(err, "writing message")
+	}
+	return &result, nil
+}
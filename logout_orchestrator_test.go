@@ -0,0 +1,327 @@
+package saml
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSLOURL = "https://idp.example.com/slo"
+
+var soapLogoutResponse = `<soap11:Envelope xmlns:soap11="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap11:Body>
+    <samlp:LogoutResponse xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"
+                          ID="_soap" Version="2.0" IssueInstant="%s" InResponseTo="%s">
+      <saml:Issuer>https://sp2.example.com</saml:Issuer>
+      <samlp:Status><samlp:StatusCode Value="%s"/></samlp:Status>
+    </samlp:LogoutResponse>
+  </soap11:Body>
+</soap11:Envelope>`
+
+// soapParticipant returns a server that answers SOAP logout requests with status.
+func soapParticipant(t *testing.T, status int, received *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		doc := etree.NewDocument()
+		require.Nil(t, doc.ReadFromBytes(body))
+		request := doc.FindElement("/Envelope/Body/LogoutRequest")
+		require.NotNil(t, request)
+		assert.Equal(t, "john@kolide.co", request.SelectElement("NameID").Text())
+		assert.NotNil(t, request.SelectElement("Signature"))
+		*received = append(*received, request.SelectAttrValue("ID", ""))
+		fmt.Fprintf(w, soapLogoutResponse, NewDateTime(time.Now()), request.SelectAttrValue("ID", ""), statusURIs[status])
+	}))
+}
+
+// getLogoutTest returns an IDP with a session at a service provider using front channel
+// logout, whose profile is returned, and a session at a service provider using SOAP.
+func getLogoutTest(t *testing.T, soapURL string) (*IdentityProvider, *SingleLogOutProfile, *[]NameID) {
+	signer := getRSAServiceProvider(t)
+	sp := getRSAServiceProvider(t)
+	sp.IssuerURI = "https://sp1.example.com"
+	sp.SingleLogoutServiceURL = "https://sp1.example.com/slo"
+	idp := getTestIdentityProvider(sp)
+	idp.SingleLogoutServiceURL = testSLOURL
+	idp.SigningKey = signer.SigningKey
+	idp.SigningCertificate = signer.SigningCertificate
+	idp.Sessions = NewMemorySessionStore()
+	idp.ServiceProviders = append(idp.ServiceProviders, &SPEntityDescriptor{
+		EntityID: "https://sp2.example.com",
+		SPSSODescriptor: SPSSODescriptor{
+			SingleLogoutService: []SingleLogoutService{{Binding: soapBinding, Location: soapURL}},
+		},
+	})
+	for _, entityID := range []string{sp.IssuerURI, "https://sp2.example.com"} {
+		err := idp.Sessions.AddSession(&ParticipantSession{
+			ServiceProvider: entityID,
+			NameID:          NameID{Format: NameIDEmail, Value: "john@kolide.co"},
+			SessionIndex:    "session-1",
+		})
+		require.Nil(t, err)
+	}
+
+	var terminated []NameID
+	terminator := SessionTerminatorFunc(func(nameID NameID, sessionIndexes []string, reason string) error {
+		assert.Equal(t, []string{"session-1"}, sessionIndexes)
+		terminated = append(terminated, nameID)
+		return nil
+	})
+	profile := NewSingleLogOutProfile(sp, idp.Metadata(), WithSessionTerminator(terminator))
+	return idp, profile, &terminated
+}
+
+func TestLogoutOrchestratorIDPInitiated(t *testing.T) {
+	var soapRequests []string
+	server := soapParticipant(t, Success, &soapRequests)
+	defer server.Close()
+	idp, profile, terminated := getLogoutTest(t, server.URL)
+	lo := NewLogoutOrchestrator(idp, WithTimeout(time.Second))
+	thisInstant := time.Now()
+
+	step, err := lo.Logout("john@kolide.co", "/done", thisInstant)
+	require.Nil(t, err)
+	assert.False(t, step.Complete)
+	require.NotEqual(t, "", step.RedirectURL)
+
+	// the front channel participant logs out and returns the browser to the IDP
+	cb, err := profile.HandlePostResponse(httptest.NewRequest(http.MethodGet, step.RedirectURL, nil), thisInstant)
+	require.Nil(t, err)
+	require.NotNil(t, cb.ExternallyInitiatedLogout)
+	require.Len(t, *terminated, 1)
+	assert.Equal(t, "john@kolide.co", (*terminated)[0].Value)
+	r := httptest.NewRequest(http.MethodGet, cb.ExternallyInitiatedLogout.RedirectURL, nil)
+	step, err = lo.HandleLogoutResponse(r, thisInstant)
+	require.Nil(t, err)
+	assert.True(t, step.Complete)
+	assert.False(t, step.PartialLogout)
+	assert.Equal(t, "/done", step.RedirectURL)
+	assert.Len(t, soapRequests, 1)
+
+	sessions, err := idp.Sessions.Sessions("john@kolide.co")
+	require.Nil(t, err)
+	assert.Empty(t, sessions)
+
+	// a response is only accepted once
+	_, err = lo.HandleLogoutResponse(r, thisInstant)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrReplay))
+}
+
+func TestLogoutOrchestratorSPInitiated(t *testing.T) {
+	var soapRequests []string
+	server := soapParticipant(t, Success, &soapRequests)
+	defer server.Close()
+	idp, profile, terminated := getLogoutTest(t, server.URL)
+	lo := NewLogoutOrchestrator(idp)
+	thisInstant := time.Now()
+
+	location, err := profile.RedirectBinding("john@kolide.co", RelayState("/loggedout"))
+	require.Nil(t, err)
+	step, err := lo.HandleLogoutRequest(httptest.NewRequest(http.MethodGet, location, nil), thisInstant)
+	require.Nil(t, err)
+	assert.True(t, step.Complete)
+	assert.False(t, step.PartialLogout)
+	assert.Len(t, soapRequests, 1)
+	// the initiator is not asked to log out
+	assert.Empty(t, *terminated)
+
+	cb, err := profile.HandlePostResponse(httptest.NewRequest(http.MethodGet, step.RedirectURL, nil), thisInstant)
+	require.Nil(t, err)
+	require.NotNil(t, cb.SelfInitiatedLogout)
+	assert.Equal(t, "/loggedout", cb.SelfInitiatedLogout.RelayURL)
+	assert.False(t, cb.SelfInitiatedLogout.Partial)
+
+	sessions, err := idp.Sessions.Sessions("john@kolide.co")
+	require.Nil(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestLogoutOrchestratorPartialLogout(t *testing.T) {
+	var soapRequests []string
+	server := soapParticipant(t, Responder, &soapRequests)
+	defer server.Close()
+	idp, profile, _ := getLogoutTest(t, server.URL)
+	lo := NewLogoutOrchestrator(idp)
+	thisInstant := time.Now()
+
+	location, err := profile.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+	step, err := lo.HandleLogoutRequest(httptest.NewRequest(http.MethodGet, location, nil), thisInstant)
+	require.Nil(t, err)
+	assert.True(t, step.Complete)
+	assert.True(t, step.PartialLogout)
+
+	// partial logout is a success for the initiator, which is told it was partial
+	cb, err := profile.HandlePostResponse(httptest.NewRequest(http.MethodGet, step.RedirectURL, nil), thisInstant)
+	require.Nil(t, err)
+	require.NotNil(t, cb.SelfInitiatedLogout)
+	assert.True(t, cb.SelfInitiatedLogout.Partial)
+	sessions, err := idp.Sessions.Sessions("john@kolide.co")
+	require.Nil(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestHandleLogoutRequestUnknownIssuer(t *testing.T) {
+	idp, _, _ := getLogoutTest(t, "https://sp2.example.com/soap")
+	lo := NewLogoutOrchestrator(idp)
+	other := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:unknown"}, idp.Metadata())
+	location, err := other.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+	_, err = lo.HandleLogoutRequest(httptest.NewRequest(http.MethodGet, location, nil), time.Now())
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrWrongIssuer))
+}
+
+func TestHandleLogoutRequestUnsigned(t *testing.T) {
+	idp, _, _ := getLogoutTest(t, "https://sp2.example.com/soap")
+	unsigned := NewSingleLogOutProfile(&ServiceProvider{
+		IssuerURI:              "https://sp1.example.com",
+		SingleLogoutServiceURL: "https://sp1.example.com/slo",
+	}, idp.Metadata())
+	location, err := unsigned.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+
+	lo := NewLogoutOrchestrator(idp)
+	_, err = lo.HandleLogoutRequest(httptest.NewRequest(http.MethodGet, location, nil), time.Now())
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrBadSignature))
+
+	lo = NewLogoutOrchestrator(idp, WithUnsignedLogoutRequests(true))
+	_, err = lo.HandleLogoutRequest(httptest.NewRequest(http.MethodGet, location, nil), time.Now())
+	assert.Nil(t, err)
+}
+
+func TestHandleLogoutRequestWithoutSession(t *testing.T) {
+	idp, profile, _ := getLogoutTest(t, "https://sp2.example.com/soap")
+	lo := NewLogoutOrchestrator(idp)
+	// the principal only has a session at the SOAP participant
+	err := idp.Sessions.RemoveSession(&ParticipantSession{
+		ServiceProvider: "https://sp1.example.com",
+		NameID:          NameID{Format: NameIDEmail, Value: "john@kolide.co"},
+		SessionIndex:    "session-1",
+	})
+	require.Nil(t, err)
+
+	location, err := profile.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+	_, err = lo.HandleLogoutRequest(httptest.NewRequest(http.MethodGet, location, nil), time.Now())
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrWrongIssuer))
+	assert.True(t, errors.Is(err, ErrNoSession))
+	sessions, err := idp.Sessions.Sessions("john@kolide.co")
+	require.Nil(t, err)
+	assert.Len(t, sessions, 1)
+}
+
+func TestHandleLogoutRequestNameIDFormat(t *testing.T) {
+	idp, profile, _ := getLogoutTest(t, "https://sp2.example.com/soap")
+	lo := NewLogoutOrchestrator(idp)
+	// the session was established with a different NameID format that has the same value
+	for _, entityID := range []string{"https://sp1.example.com", "https://sp2.example.com"} {
+		session := &ParticipantSession{
+			ServiceProvider: entityID,
+			NameID:          NameID{Format: NameIDEmail, Value: "john@kolide.co"},
+			SessionIndex:    "session-1",
+		}
+		require.Nil(t, idp.Sessions.RemoveSession(session))
+		session.NameID.Format = NameIDUnspecified
+		require.Nil(t, idp.Sessions.AddSession(session))
+	}
+
+	location, err := profile.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+	_, err = lo.HandleLogoutRequest(httptest.NewRequest(http.MethodGet, location, nil), time.Now())
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrNoSession))
+}
+
+func TestLogoutOrchestratorTimeout(t *testing.T) {
+	idp, _, _ := getLogoutTest(t, "https://sp2.example.com/soap")
+	assert.Equal(t, defaultSOAPTimeout, NewLogoutOrchestrator(idp).client.Timeout)
+	assert.Equal(t, time.Second, NewLogoutOrchestrator(idp, WithTimeout(time.Second)).client.Timeout)
+}
+
+// sendSOAPTest sends a SOAP LogoutRequest for the SOAP participant of getLogoutTest to a server
+// that answers with response, which is formatted with the request ID.
+func sendSOAPTest(t *testing.T, response func(id string) string) error {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		doc := etree.NewDocument()
+		require.Nil(t, doc.ReadFromBytes(body))
+		request := doc.FindElement("/Envelope/Body/LogoutRequest")
+		require.NotNil(t, request)
+		fmt.Fprint(w, response(request.SelectAttrValue("ID", "")))
+	}))
+	defer server.Close()
+	idp, _, _ := getLogoutTest(t, server.URL)
+	lo := NewLogoutOrchestrator(idp)
+	session := &ParticipantSession{
+		ServiceProvider: "https://sp2.example.com",
+		NameID:          NameID{Format: NameIDEmail, Value: "john@kolide.co"},
+	}
+	request, err := idp.newLogoutRequest(session, server.URL, time.Now())
+	require.Nil(t, err)
+	return lo.sendSOAP(server.URL, request, session, time.Now())
+}
+
+func TestSendSOAPEnvelopeNamespaces(t *testing.T) {
+	// the protocol namespaces are declared by the envelope rather than the response
+	err := sendSOAPTest(t, func(id string) string {
+		return fmt.Sprintf(`<soap11:Envelope xmlns:soap11="http://schemas.xmlsoap.org/soap/envelope/"
+                 xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">
+  <soap11:Body>
+    <samlp:LogoutResponse ID="_soap" Version="2.0" IssueInstant="%s" InResponseTo="%s">
+      <saml:Issuer>https://sp2.example.com</saml:Issuer>
+      <samlp:Status><samlp:StatusCode Value="%s"/></samlp:Status>
+    </samlp:LogoutResponse>
+  </soap11:Body>
+</soap11:Envelope>`, NewDateTime(time.Now()), id, statusURIs[Success])
+	})
+	assert.Nil(t, err)
+
+	err = sendSOAPTest(t, func(id string) string {
+		return fmt.Sprintf(`<soap11:Envelope xmlns:soap11="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap11:Body><soap11:Fault>
+    <samlp:LogoutResponse xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_soap" InResponseTo="%s"/>
+  </soap11:Fault></soap11:Body>
+</soap11:Envelope>`, id)
+	})
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrMalformed))
+}
+
+func TestSendSOAPResponseTooLarge(t *testing.T) {
+	err := sendSOAPTest(t, func(id string) string {
+		padding := strings.Repeat(" ", maxSOAPResponseSize)
+		return fmt.Sprintf(soapLogoutResponse, NewDateTime(time.Now()), id, statusURIs[Success]) + padding
+	})
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrMalformed))
+}
+
+func TestResponsePostFormRecordsSession(t *testing.T) {
+	thisInstant := time.Now()
+	idp, _, request := getResponseTest(t, thisInstant)
+	idp.Sessions = NewMemorySessionStore()
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	postResponse(t, idp, request, response)
+
+	sessions, err := idp.Sessions.Sessions("john@kolide.co")
+	require.Nil(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, request.ServiceProvider.EntityID, sessions[0].ServiceProvider)
+	assert.Equal(t, "session-1", sessions[0].SessionIndex)
+	assert.Equal(t, NameIDEmail, sessions[0].NameID.Format)
+}
@@ -0,0 +1,263 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+)
+
+// metadataContentType is the media type of SAML metadata.
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-metadata-2.0-os.pdf Section 4.1.1
+const metadataContentType = "application/samlmetadata+xml"
+
+const (
+	// DefaultMetadataValidity is how long signed IDP metadata is valid for if
+	// IdentityProvider.MetadataValidity is zero.
+	DefaultMetadataValidity = 7 * 24 * time.Hour
+	// DefaultMetadataCacheDuration is the cacheDuration of signed IDP metadata if
+	// IdentityProvider.MetadataCacheDuration is zero.
+	DefaultMetadataCacheDuration = 24 * time.Hour
+)
+
+// SPEntityDescriptor is the metadata describing this service provider to an IDP.
+type SPEntityDescriptor struct {
+	XMLName         xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string          `xml:"entityID,attr"`
+	ValidUntil      DateTime        `xml:"validUntil,attr"`
+	SPSSODescriptor SPSSODescriptor `xml:"SPSSODescriptor"`
+}
+
+// SPSSODescriptor contains information about the service provider. WantAssertionsSigned
+// tells the IDP that assertions must be signed individually.
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-metadata-2.0-os.pdf Section 2.4.4
+type SPSSODescriptor struct {
+	XMLName                    xml.Name                   `xml:"urn:oasis:names:tc:SAML:2.0:metadata SPSSODescriptor"`
+	AuthnRequestsSigned        bool                       `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool                       `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string                     `xml:"protocolSupportEnumeration,attr"`
+	KeyDescriptors             []KeyDescriptor            `xml:"KeyDescriptor"`
+	SingleLogoutService        []SingleLogoutService      `xml:"SingleLogoutService"`
+	NameIDFormats              []NameIDFormat             `xml:"NameIDFormat"`
+	AssertionConsumerServices  []AssertionConsumerService `xml:"AssertionConsumerService"`
+}
+
+// AssertionConsumerService is an endpoint of the service provider that receives AuthnResponses.
+type AssertionConsumerService struct {
+	XMLName   xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata AssertionConsumerService"`
+	Binding   string   `xml:"Binding,attr"`
+	Location  string   `xml:"Location,attr"`
+	Index     int      `xml:"index,attr"`
+	IsDefault bool     `xml:"isDefault,attr,omitempty"`
+}
+
+// Metadata returns SP metadata for the service provider that can be supplied to the IDP.
+// The signature policy of the profile is advertised in WantAssertionsSigned, the metadata
+// schema has no way to express that the response itself must be signed.
+func (sp *SingleSignOnProfile) Metadata() *SPEntityDescriptor {
+	provider := sp.serviceProvder
+	descriptor := SPSSODescriptor{
+		WantAssertionsSigned:       sp.signaturePolicy&WantAssertionsSigned != 0,
+		ProtocolSupportEnumeration: samlProtocalNamespace,
+	}
+	if provider.SigningCertificate != nil {
+		descriptor.KeyDescriptors = append(descriptor.KeyDescriptors, newKeyDescriptor(KeyUseSigning, provider.SigningCertificate))
+	}
+	if provider.EncryptionCertificate != nil {
+		descriptor.KeyDescriptors = append(descriptor.KeyDescriptors, newKeyDescriptor(KeyUseEncryption, provider.EncryptionCertificate))
+	}
+	if provider.SingleLogoutServiceURL != "" {
+		for _, binding := range []string{redirectBinding, postBinding} {
+			descriptor.SingleLogoutService = append(descriptor.SingleLogoutService, SingleLogoutService{
+				Binding:  binding,
+				Location: provider.SingleLogoutServiceURL,
+			})
+		}
+	}
+	for _, format := range provider.NameIDFormats {
+		descriptor.NameIDFormats = append(descriptor.NameIDFormats, NameIDFormat{Value: format})
+	}
+	if provider.AssertionConsumerServiceURL != "" {
+		descriptor.AssertionConsumerServices = append(descriptor.AssertionConsumerServices, AssertionConsumerService{
+			Binding:  postBinding,
+			Location: provider.AssertionConsumerServiceURL,
+		})
+	}
+	return &SPEntityDescriptor{
+		EntityID:        provider.IssuerURI,
+		SPSSODescriptor: descriptor,
+	}
+}
+
+// newKeyDescriptor returns a KeyDescriptor publishing cert for use.
+func newKeyDescriptor(use string, cert *x509.Certificate) KeyDescriptor {
+	return KeyDescriptor{
+		Use: use,
+		KeyInfo: KeyInfo{
+			X509Data: X509Data{
+				X509Certificates: []X509Certificate{
+					{Data: base64.StdEncoding.EncodeToString(cert.Raw)},
+				},
+			},
+		},
+	}
+}
+
+// Metadata returns the metadata describing the IDP to service providers. The single sign on and
+// single logout services accept the redirect and post bindings, each is omitted if its URL is empty.
+func (idp *IdentityProvider) Metadata() *EntityDescriptor {
+	descriptor := IDPSSODescriptor{
+		WantAuthnRequestsSigned:    idp.WantAuthnRequestsSigned,
+		ProtocolSupportEnumeration: samlProtocalNamespace,
+	}
+	if idp.SigningCertificate != nil {
+		descriptor.KeyDescriptors = append(descriptor.KeyDescriptors, newKeyDescriptor(KeyUseSigning, idp.SigningCertificate))
+	}
+	if idp.EncryptionCertificate != nil {
+		descriptor.KeyDescriptors = append(descriptor.KeyDescriptors, newKeyDescriptor(KeyUseEncryption, idp.EncryptionCertificate))
+	}
+	for _, binding := range []string{redirectBinding, postBinding} {
+		if idp.SingleLogoutServiceURL != "" {
+			descriptor.SingleLogoutService = append(descriptor.SingleLogoutService, SingleLogoutService{
+				Binding:  binding,
+				Location: idp.SingleLogoutServiceURL,
+			})
+		}
+		if idp.SingleSignOnServiceURL != "" {
+			descriptor.SingleSignOnService = append(descriptor.SingleSignOnService, SingleSignOnService{
+				Binding:  binding,
+				Location: idp.SingleSignOnServiceURL,
+			})
+		}
+	}
+	for _, format := range idp.NameIDFormats {
+		descriptor.NameIDFormats = append(descriptor.NameIDFormats, NameIDFormat{Value: format})
+	}
+	for _, name := range idp.Attributes {
+		descriptor.Attributes = append(descriptor.Attributes, Attribute{
+			XMLName: xml.Name{
+				Space: samlNamespace,
+				Local: "Attribute",
+			},
+			Name:       name,
+			NameFormat: AttributeNameFormatBasic,
+		})
+	}
+	return &EntityDescriptor{
+		EntityID:         idp.EntityID,
+		IDPSSODescriptor: descriptor,
+	}
+}
+
+// EncodeMetadata returns the IDP metadata as XML. If sign is true the metadata is signed with the
+// IDP signing key so that service providers can verify metadata fetched over an untrusted channel.
+// Signed metadata is valid until MetadataValidity after thisInstant and carries a cacheDuration.
+func (idp *IdentityProvider) EncodeMetadata(sign bool, thisInstant time.Time) ([]byte, error) {
+	metadata := idp.Metadata()
+	if !sign {
+		encoded, err := xml.Marshal(metadata)
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding metadata")
+		}
+		return encoded, nil
+	}
+	id, err := getUniqueID()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting id for metadata")
+	}
+	metadata.ID = "_" + id
+	metadata.ValidUntil = NewDateTime(thisInstant.Add(idp.metadataValidity()))
+	metadata.CacheDuration = xsDuration(idp.metadataCacheDuration())
+	encoded, err := xml.Marshal(metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding metadata")
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading encoded metadata")
+	}
+	context, err := idp.getSigningContext()
+	if err != nil {
+		return nil, err
+	}
+	signed, err := signEnveloped(context, doc.Root())
+	if err != nil {
+		return nil, err
+	}
+	doc.SetRoot(signed)
+	encoded, err = doc.WriteToBytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "writing signed metadata")
+	}
+	return encoded, nil
+}
+
+func (idp *IdentityProvider) metadataValidity() time.Duration {
+	if idp.MetadataValidity == 0 {
+		return DefaultMetadataValidity
+	}
+	return idp.MetadataValidity
+}
+
+func (idp *IdentityProvider) metadataCacheDuration() time.Duration {
+	if idp.MetadataCacheDuration == 0 {
+		return DefaultMetadataCacheDuration
+	}
+	return idp.MetadataCacheDuration
+}
+
+// xsDuration formats d as an xs:duration in whole seconds.
+func xsDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%dS", int64(d/time.Second))
+}
+
+// MetadataHandler returns a handler that serves the IDP metadata, signed if sign is true. The
+// metadata is encoded and signed once, then served until it is within the cache duration of
+// expiring, so that a service provider caching it never holds expired metadata.
+func (idp *IdentityProvider) MetadataHandler(sign bool) http.Handler {
+	return &metadataHandler{idp: idp, sign: sign, clock: realClock{}}
+}
+
+type metadataHandler struct {
+	idp   *IdentityProvider
+	sign  bool
+	clock Clock
+
+	mu      sync.Mutex
+	encoded []byte
+	refresh time.Time
+}
+
+// metadata returns the cached metadata, encoding it again if it is due to be refreshed.
+func (h *metadataHandler) metadata() ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.clock.Now()
+	if h.encoded != nil && now.Before(h.refresh) {
+		return h.encoded, nil
+	}
+	encoded, err := h.idp.EncodeMetadata(h.sign, now)
+	if err != nil {
+		return nil, err
+	}
+	h.encoded = encoded
+	h.refresh = now.Add(h.idp.metadataValidity() - h.idp.metadataCacheDuration())
+	return encoded, nil
+}
+
+func (h *metadataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	metadata, err := h.metadata()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", metadataContentType)
+	w.Write(metadata)
+}
@@ -0,0 +1,110 @@
+package saml
+
+import (
+	"fmt"
+	"time"
+)
+
+// lintExpiryWarning is how long before expiry LintMetadata warns about a signing certificate.
+const lintExpiryWarning = 30 * 24 * time.Hour
+
+// MetadataProblem is a problem found in IDP metadata by LintMetadata.
+type MetadataProblem struct {
+	// Fatal is true if the problem prevents logins, otherwise it is a warning.
+	Fatal   bool
+	Message string
+}
+
+func (p MetadataProblem) String() string {
+	if p.Fatal {
+		return "error: " + p.Message
+	}
+	return "warning: " + p.Message
+}
+
+// LintMetadata checks that service providers using this package can log in with the IDP
+// described by metadata at thisInstant. It reports missing or expired signing keys and services
+// that are missing or only offer bindings the package does not support. It returns nil if no
+// problems are found.
+func LintMetadata(metadata *EntityDescriptor, thisInstant time.Time) []MetadataProblem {
+	var problems []MetadataProblem
+	fatal := func(format string, args ...interface{}) {
+		problems = append(problems, MetadataProblem{Fatal: true, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(format string, args ...interface{}) {
+		problems = append(problems, MetadataProblem{Message: fmt.Sprintf(format, args...)})
+	}
+
+	if metadata.EntityID == "" {
+		fatal("entityID is missing")
+	}
+	if !metadata.ValidUntil.IsZero() && thisInstant.After(metadata.ValidUntil.Time) {
+		fatal("metadata expired at %s", metadata.ValidUntil)
+	}
+	descriptor := &metadata.IDPSSODescriptor
+
+	certs, err := signingCertificates(descriptor.KeyDescriptors)
+	switch {
+	case err != nil:
+		fatal("signing keys can not be parsed: %s", err)
+	case len(certs) == 0:
+		fatal("no signing keys, signatures from the IDP can not be verified")
+	default:
+		current := 0
+		for _, cert := range certs {
+			name := cert.Subject.CommonName
+			if name == "" {
+				name = fmt.Sprintf("serial %s", cert.SerialNumber)
+			}
+			switch {
+			case cert.NotAfter.IsZero():
+				current++
+			case thisInstant.After(cert.NotAfter):
+				warn("signing certificate %s expired at %s", name, cert.NotAfter.UTC().Format(time.RFC3339))
+			case thisInstant.Before(cert.NotBefore):
+				warn("signing certificate %s is not valid until %s", name, cert.NotBefore.UTC().Format(time.RFC3339))
+			case cert.NotAfter.Sub(thisInstant) < lintExpiryWarning:
+				warn("signing certificate %s expires at %s", name, cert.NotAfter.UTC().Format(time.RFC3339))
+				current++
+			default:
+				current++
+			}
+		}
+		if current == 0 {
+			fatal("no signing certificate is currently valid, logins fail unless certificate validity is ignored")
+		}
+	}
+
+	// service providers only send AuthnRequests with the redirect binding
+	if len(descriptor.SingleSignOnService) == 0 {
+		fatal("no SingleSignOnService")
+	} else {
+		supported := false
+		for _, svc := range descriptor.SingleSignOnService {
+			if svc.Binding == redirectBinding {
+				supported = true
+			} else {
+				warn("SingleSignOnService binding %s is not used", svc.Binding)
+			}
+		}
+		if !supported {
+			fatal("no SingleSignOnService supports the redirect binding")
+		}
+	}
+
+	if len(descriptor.SingleLogoutService) == 0 {
+		warn("no SingleLogoutService, single logout is not possible")
+	}
+	for _, svc := range descriptor.SingleLogoutService {
+		if svc.Binding != redirectBinding && svc.Binding != postBinding {
+			warn("SingleLogoutService binding %s is not supported", svc.Binding)
+		}
+	}
+	if len(descriptor.SingleLogoutService) > 0 {
+		_, err = getSingleLogoutService(descriptor.SingleLogoutService, redirectBinding, postBinding)
+		if err != nil {
+			fatal("no SingleLogoutService supports the redirect or post binding")
+		}
+	}
+	return problems
+}
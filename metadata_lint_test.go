@@ -0,0 +1,66 @@
+package saml
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/saml/generated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func problemMessages(problems []MetadataProblem) []string {
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	return messages
+}
+
+func TestLintMetadata(t *testing.T) {
+	buff, err := generated.Asset("test_data/metadata.xml")
+	require.Nil(t, err)
+	metadata, err := getMetadata(bytes.NewReader(buff))
+	require.Nil(t, err)
+
+	// the certificate is valid from 2017-04-17 to 2022-04-18
+	problems := LintMetadata(metadata, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{
+		"warning: SingleSignOnService binding urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST is not used",
+		"warning: SingleSignOnService binding urn:oasis:names:tc:SAML:2.0:bindings:SOAP is not used",
+	}, problemMessages(problems))
+
+	problems = LintMetadata(metadata, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	messages := problemMessages(problems)
+	assert.Contains(t, messages, "warning: signing certificate OneLogin Account 105013 expired at 2022-04-18T22:40:18Z")
+	assert.Contains(t, messages, "error: no signing certificate is currently valid, logins fail unless certificate validity is ignored")
+
+	problems = LintMetadata(metadata, time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC))
+	assert.Contains(t, problemMessages(problems), "warning: signing certificate OneLogin Account 105013 expires at 2022-04-18T22:40:18Z")
+}
+
+func TestLintMetadataFatal(t *testing.T) {
+	metadata := &EntityDescriptor{
+		ValidUntil: NewDateTime(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)),
+		IDPSSODescriptor: IDPSSODescriptor{
+			SingleSignOnService: []SingleSignOnService{{Binding: postBinding, Location: "https://idp.example.com/sso"}},
+			SingleLogoutService: []SingleLogoutService{{Binding: soapBinding, Location: "https://idp.example.com/slo"}},
+		},
+	}
+	problems := LintMetadata(metadata, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	var fatal []string
+	for _, problem := range problems {
+		if problem.Fatal {
+			fatal = append(fatal, problem.Message)
+		}
+	}
+	assert.Equal(t, []string{
+		"entityID is missing",
+		"metadata expired at 2017-01-01T00:00:00Z",
+		"no signing keys, signatures from the IDP can not be verified",
+		"no SingleSignOnService supports the redirect binding",
+		"no SingleLogoutService supports the redirect or post binding",
+	}, fatal)
+	assert.Contains(t, problemMessages(problems), "warning: SingleLogoutService binding "+soapBinding+" is not supported")
+}
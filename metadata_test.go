@@ -0,0 +1,177 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/jonboulle/clockwork"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPMetadata(t *testing.T) {
+	sp := getRSAServiceProvider(t)
+	sp.AssertionConsumerServiceURL = "https://sp.example.com/acs"
+	sp.SingleLogoutServiceURL = "https://sp.example.com/slo"
+	profile := NewSingleSignOnProfile(sp, &IDPSSODescriptor{})
+
+	raw, err := xml.Marshal(profile.Metadata())
+	require.Nil(t, err)
+	var metadata SPEntityDescriptor
+	err = xml.Unmarshal(raw, &metadata)
+	require.Nil(t, err)
+
+	assert.Equal(t, sp.IssuerURI, metadata.EntityID)
+	descriptor := metadata.SPSSODescriptor
+	assert.True(t, descriptor.WantAssertionsSigned)
+	require.Len(t, descriptor.KeyDescriptors, 1)
+	assert.Equal(t, "signing", descriptor.KeyDescriptors[0].Use)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sp.SigningCertificate.Raw), descriptor.KeyDescriptors[0].KeyInfo.X509Data.X509Certificates[0].Data)
+	require.Len(t, descriptor.AssertionConsumerServices, 1)
+	assert.Equal(t, sp.AssertionConsumerServiceURL, descriptor.AssertionConsumerServices[0].Location)
+	assert.Len(t, descriptor.SingleLogoutService, 2)
+	require.Len(t, descriptor.NameIDFormats, 1)
+	assert.Equal(t, NameIDEmail, descriptor.NameIDFormats[0].Value)
+}
+
+func TestSPMetadataResponseSignedPolicy(t *testing.T) {
+	sp := &ServiceProvider{IssuerURI: "uri:myserviceprovider"}
+	profile := NewSingleSignOnProfile(sp, &IDPSSODescriptor{}, WithSignaturePolicy(WantResponseSigned))
+	metadata := profile.Metadata()
+	assert.False(t, metadata.SPSSODescriptor.WantAssertionsSigned)
+	assert.Empty(t, metadata.SPSSODescriptor.KeyDescriptors)
+}
+
+func TestSPMetadataEncryptionCertificate(t *testing.T) {
+	sp := getRSAServiceProvider(t)
+	sp.EncryptionCertificate = sp.SigningCertificate
+	metadata := NewSingleSignOnProfile(sp, &IDPSSODescriptor{}).Metadata()
+	require.Len(t, metadata.SPSSODescriptor.KeyDescriptors, 2)
+	assert.Equal(t, KeyUseEncryption, metadata.SPSSODescriptor.KeyDescriptors[1].Use)
+
+	cert, err := encryptionCertificate(metadata.SPSSODescriptor.KeyDescriptors)
+	require.Nil(t, err)
+	assert.Equal(t, sp.EncryptionCertificate.Raw, cert.Raw)
+	_, err = encryptionCertificate(metadata.SPSSODescriptor.KeyDescriptors[:1])
+	assert.Equal(t, ErrNoEncryptionCertificate, err)
+}
+
+func getMetadataIdentityProvider(t *testing.T) *IdentityProvider {
+	signer := getRSAServiceProvider(t)
+	return &IdentityProvider{
+		EntityID:                "https://idp.example.com",
+		SingleSignOnServiceURL:  "https://idp.example.com/sso",
+		SingleLogoutServiceURL:  "https://idp.example.com/slo",
+		NameIDFormats:           []string{NameIDEmail},
+		SigningKey:              signer.SigningKey,
+		SigningCertificate:      signer.SigningCertificate,
+		EncryptionCertificate:   signer.SigningCertificate,
+		WantAuthnRequestsSigned: true,
+		Attributes:              []string{"groups"},
+	}
+}
+
+func TestIDPMetadata(t *testing.T) {
+	idp := getMetadataIdentityProvider(t)
+	ts := httptest.NewServer(idp.MetadataHandler(false))
+	defer ts.Close()
+
+	metadata, err := GetMetadataFromURL(ts.URL)
+	require.Nil(t, err)
+	assert.Equal(t, idp.EntityID, metadata.EntityID)
+	descriptor := metadata.IDPSSODescriptor
+	assert.True(t, descriptor.WantAuthnRequestsSigned)
+	require.Len(t, descriptor.KeyDescriptors, 2)
+	certs, err := signingCertificates(descriptor.KeyDescriptors)
+	require.Nil(t, err)
+	require.Len(t, certs, 1)
+	assert.Equal(t, idp.SigningCertificate.Raw, certs[0].Raw)
+	_, err = encryptionCertificate(descriptor.KeyDescriptors)
+	assert.Nil(t, err)
+
+	location, err := getSSOBindingLocation(redirectBinding, descriptor.SingleSignOnService)
+	require.Nil(t, err)
+	assert.Equal(t, idp.SingleSignOnServiceURL, location)
+	location, err = getSSOBindingLocation(postBinding, descriptor.SingleSignOnService)
+	require.Nil(t, err)
+	assert.Equal(t, idp.SingleSignOnServiceURL, location)
+	assert.Len(t, descriptor.SingleLogoutService, 2)
+	require.Len(t, descriptor.NameIDFormats, 1)
+	assert.Equal(t, NameIDEmail, descriptor.NameIDFormats[0].Value)
+	require.Len(t, descriptor.Attributes, 1)
+	assert.Equal(t, "groups", descriptor.Attributes[0].Name)
+	assert.Equal(t, samlNamespace, descriptor.Attributes[0].XMLName.Space)
+}
+
+func TestIDPMetadataWithoutSingleSignOn(t *testing.T) {
+	idp := getMetadataIdentityProvider(t)
+	idp.SingleSignOnServiceURL = ""
+	descriptor := idp.Metadata().IDPSSODescriptor
+	assert.Empty(t, descriptor.SingleSignOnService)
+	assert.Len(t, descriptor.SingleLogoutService, 2)
+
+	idp.SingleLogoutServiceURL = ""
+	assert.Empty(t, idp.Metadata().IDPSSODescriptor.SingleLogoutService)
+}
+
+func TestIDPMetadataSigned(t *testing.T) {
+	idp := getMetadataIdentityProvider(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	encoded, err := idp.EncodeMetadata(true, now)
+	require.Nil(t, err)
+
+	doc := etree.NewDocument()
+	require.Nil(t, doc.ReadFromBytes(encoded))
+	context := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{idp.SigningCertificate},
+	})
+	validated, err := context.Validate(doc.Root())
+	require.Nil(t, err)
+	assert.Equal(t, "EntityDescriptor", validated.Tag)
+	var metadata EntityDescriptor
+	require.Nil(t, xml.Unmarshal(encoded, &metadata))
+	assert.Equal(t, now.Add(DefaultMetadataValidity), metadata.ValidUntil.Time)
+	assert.Equal(t, "PT86400S", metadata.CacheDuration)
+
+	idp.SigningKey = nil
+	_, err = idp.EncodeMetadata(true, now)
+	assert.Equal(t, ErrIDPSigningNotConfigured, err)
+}
+
+func TestIDPMetadataHandlerCachesSignature(t *testing.T) {
+	idp := getMetadataIdentityProvider(t)
+	idp.MetadataValidity = 48 * time.Hour
+	idp.MetadataCacheDuration = 12 * time.Hour
+	clock := clockwork.NewFakeClockAt(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := httptest.NewServer(&metadataHandler{idp: idp, sign: true, clock: clock})
+	defer ts.Close()
+	get := func() *EntityDescriptor {
+		resp, err := ts.Client().Get(ts.URL)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		var metadata EntityDescriptor
+		require.Nil(t, xml.Unmarshal(body, &metadata))
+		return &metadata
+	}
+
+	first := get()
+	assert.Equal(t, clock.Now().Add(48*time.Hour), first.ValidUntil.Time)
+	assert.Equal(t, "PT43200S", first.CacheDuration)
+	clock.Advance(35 * time.Hour)
+	assert.Equal(t, first.ID, get().ID)
+
+	// signed again once a cached copy could outlive validUntil
+	clock.Advance(time.Hour)
+	second := get()
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, clock.Now().Add(48*time.Hour), second.ValidUntil.Time)
+}
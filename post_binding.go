@@ -0,0 +1,56 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// PostForm contains a message that is delivered to its destination by the browser
+// using the HTTP-POST binding.
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf Section 3.5
+type PostForm struct {
+	// URL is the form action
+	URL string
+	// Values contains the form fields, SAMLRequest or SAMLResponse and optionally RelayState
+	Values url.Values
+}
+
+var postFormTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<body onload="document.forms[0].submit()">
+<noscript><p>Note: Since your browser does not support JavaScript, you must press the Continue button once to proceed.</p></noscript>
+<form method="post" action="{{.URL}}">
+{{range $key, $values := .Values}}{{range $values}}<input type="hidden" name="{{$key}}" value="{{.}}"/>
+{{end}}{{end}}<noscript><input type="submit" value="Continue"/></noscript>
+</form>
+</body>
+</html>
+`))
+
+// Write renders an HTML page containing a form that submits itself to URL when loaded.
+func (f *PostForm) Write(w io.Writer) error {
+	err := postFormTemplate.Execute(w, f)
+	if err != nil {
+		return errors.Wrap(err, "writing post form")
+	}
+	return nil
+}
+
+// postBindingForm creates the form used to deliver message to location using the HTTP-POST
+// binding. Unlike the redirect binding the message is not deflated.
+func postBindingForm(location, queryKey string, message *bytes.Buffer, rs string) *PostForm {
+	values := url.Values{}
+	values.Set(queryKey, base64.StdEncoding.EncodeToString(message.Bytes()))
+	if rs != "" {
+		values.Set(RelayStateQueryKey, rs)
+	}
+	return &PostForm{
+		URL:    location,
+		Values: values,
+	}
+}
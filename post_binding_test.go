@@ -0,0 +1,20 @@
+package saml
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostBindingForm(t *testing.T) {
+	form := postBindingForm("https://idp.com/slo?a=b&c=d", ResponseQueryKey, bytes.NewBufferString("<foo/>"), `"quoted"`)
+	assert.Equal(t, "PGZvby8+", form.Values.Get(ResponseQueryKey))
+	var page bytes.Buffer
+	err := form.Write(&page)
+	require.Nil(t, err)
+	assert.Contains(t, page.String(), `action="https://idp.com/slo?a=b&amp;c=d"`)
+	assert.Contains(t, page.String(), `name="SAMLResponse" value="PGZvby8&#43;"`)
+	assert.Contains(t, page.String(), `name="RelayState" value="&#34;quoted&#34;"`)
+}
@@ -1,5 +1,54 @@
 package saml
 
+import "time"
+
+const (
+	// DefaultClockSkew is the allowance made for differences between the clocks
+	// of the service provider and the IDP when checking timestamps.
+	DefaultClockSkew = 90 * time.Second
+	// maxIssueDelay is the longest time that may pass between the IDP issuing
+	// a message and the service provider receiving it.
+	maxIssueDelay = 90 * time.Second
+	// maxRequestAge is the longest time the service provider waits for a response
+	// to a request it sent to the IDP.
+	maxRequestAge = 10 * time.Minute
+)
+
+type clockSkew time.Duration
+
+// WithClockSkew is an optional parameter used to change the allowance made for differences between
+// the clocks of the service provider and the IDP. If not supplied DefaultClockSkew is used.
+func WithClockSkew(skew time.Duration) func() interface{} {
+	return func() interface{} {
+		return clockSkew(skew)
+	}
+}
+
+// Clock supplies the current time to a profile. It is satisfied by clockwork.Clock, so tests
+// can control the time seen by a profile with a clockwork fake clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+type profileClock struct {
+	Clock
+}
+
+// WithClock is an optional parameter used to supply the Clock a profile uses for the issue
+// instant of the messages it sends, and to validate the messages received by Callback. If
+// not supplied the system clock is used.
+func WithClock(c Clock) func() interface{} {
+	return func() interface{} {
+		return profileClock{c}
+	}
+}
+
 // Identity contains information about the principal that was authenticated
 // with the IDP.  Typically check the user is known to the SP.
 type Identity struct {
@@ -9,12 +58,22 @@ type Identity struct {
 	RelayState string
 }
 
+// SelfInitiatedLogout is returned when the IDP responds to a logout request sent by
+// this service provider. RelayURL is the relay state supplied when the request was sent.
+// Partial is true if the IDP responded with the PartialLogout status, the user is logged out
+// of this service provider but may still have sessions with the IDP or other participants.
 type SelfInitiatedLogout struct {
 	RelayURL string
+	Partial  bool
 }
 
+// ExternallyInitiatedLogout contains the logout response that must be returned to
+// the IDP after the user is logged out. Depending on the binding supported by the IDP
+// either RedirectURL is set, and the user should be redirected to it, or PostForm is set
+// and should be written to the user's browser.
 type ExternallyInitiatedLogout struct {
 	RedirectURL string
+	PostForm    *PostForm
 }
 
 type CallbackResponse struct {
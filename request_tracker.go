@@ -0,0 +1,92 @@
+package saml
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnknownRequest occurs when a response refers to a request that was not sent
+	// by this service provider, or has already been answered.
+	ErrUnknownRequest = errors.New("response does not match an outstanding request")
+	// ErrDuplicateRequest occurs when a request is tracked while a request with the same ID is
+	// already tracked.
+	ErrDuplicateRequest = errors.New("request is already tracked")
+)
+
+// TrackedRequest is a request sent to the IDP that is waiting for a response.
+type TrackedRequest struct {
+	// ID of the request, responses refer to it in InResponseTo
+	ID string
+	// RelayState is where the user is sent once the response is received
+	RelayState string
+	// IssueInstant is the time the request was sent
+	IssueInstant time.Time
+}
+
+// RequestTracker stores requests sent to the IDP so that responses can be matched to them.
+// If a service provider runs on more than one host the tracker must be shared between them.
+type RequestTracker interface {
+	// TrackRequest records a request sent to the IDP. If a request with the same ID is already
+	// tracked it is left unchanged and ErrDuplicateRequest is returned. Checking and recording
+	// the ID must be atomic, single sign on relies on it to reject replayed assertions.
+	TrackRequest(req *TrackedRequest) error
+	// StopTrackingRequest removes and returns the request with id. If the request
+	// is not known ErrUnknownRequest is returned.
+	StopTrackingRequest(id string) (*TrackedRequest, error)
+}
+
+type memoryRequestTracker struct {
+	mtx       sync.Mutex
+	requests  map[string]*TrackedRequest
+	retention time.Duration
+}
+
+// NewMemoryRequestTracker returns a RequestTracker that holds requests in memory. Requests
+// older than retention are discarded.
+func NewMemoryRequestTracker(retention time.Duration) RequestTracker {
+	return &memoryRequestTracker{
+		requests:  map[string]*TrackedRequest{},
+		retention: retention,
+	}
+}
+
+func (rt *memoryRequestTracker) TrackRequest(req *TrackedRequest) error {
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	for id, tracked := range rt.requests {
+		if req.IssueInstant.Sub(tracked.IssueInstant) > rt.retention {
+			delete(rt.requests, id)
+		}
+	}
+	if _, ok := rt.requests[req.ID]; ok {
+		return ErrDuplicateRequest
+	}
+	rt.requests[req.ID] = req
+	return nil
+}
+
+func (rt *memoryRequestTracker) StopTrackingRequest(id string) (*TrackedRequest, error) {
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	req, ok := rt.requests[id]
+	if !ok {
+		return nil, ErrUnknownRequest
+	}
+	delete(rt.requests, id)
+	return req, nil
+}
+
+type requestTracker struct {
+	RequestTracker
+}
+
+// WithRequestTracker is an optional parameter used to supply the RequestTracker used
+// by a profile. Typically this is used to share requests between several profiles or hosts.
+func WithRequestTracker(rt RequestTracker) func() interface{} {
+	return func() interface{} {
+		return requestTracker{rt}
+	}
+}
@@ -0,0 +1,40 @@
+package saml
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRequestTracker(t *testing.T) {
+	rt := NewMemoryRequestTracker(time.Minute)
+	now := time.Now()
+	err := rt.TrackRequest(&TrackedRequest{ID: "abc", RelayState: "/foo", IssueInstant: now})
+	require.Nil(t, err)
+	err = rt.TrackRequest(&TrackedRequest{ID: "abc", RelayState: "/bar", IssueInstant: now})
+	assert.Equal(t, ErrDuplicateRequest, err)
+
+	req, err := rt.StopTrackingRequest("abc")
+	require.Nil(t, err)
+	assert.Equal(t, "/foo", req.RelayState)
+
+	// a request can only be answered once
+	_, err = rt.StopTrackingRequest("abc")
+	assert.Equal(t, ErrUnknownRequest, err)
+}
+
+func TestMemoryRequestTrackerRetention(t *testing.T) {
+	rt := NewMemoryRequestTracker(time.Minute)
+	now := time.Now()
+	err := rt.TrackRequest(&TrackedRequest{ID: "old", IssueInstant: now.Add(-2 * time.Minute)})
+	require.Nil(t, err)
+	err = rt.TrackRequest(&TrackedRequest{ID: "new", IssueInstant: now})
+	require.Nil(t, err)
+
+	_, err = rt.StopTrackingRequest("old")
+	assert.Equal(t, ErrUnknownRequest, err)
+	_, err = rt.StopTrackingRequest("new")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,178 @@
+package samltest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/murphybytes/saml"
+	"github.com/murphybytes/saml/keytest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FixtureIssuer is the entity ID of the IDP that issues fixtures.
+	FixtureIssuer = "https://idp.example.com"
+	// FixtureRequestID is the ID of the AuthnRequest that fixture responses answer.
+	FixtureRequestID = "_request"
+	// FixtureSessionIndex is the session index of fixture assertions and logout requests.
+	FixtureSessionIndex = "session-1"
+)
+
+var (
+	fixtureCertificateNotBefore = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	fixtureCertificateNotAfter  = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Fixtures generates signed messages for tests of the validation performed by a service
+// provider. The messages are issued by an IDP with a new self signed key, at an instant chosen
+// by the test, and may be changed by overrides before they are signed. The certificate of the
+// IDP is valid from 2000 until 2100 so that it is trusted at any instant a test is likely to use.
+type Fixtures struct {
+	// IdentityProvider issues the fixtures. It may be changed, for example to encrypt
+	// assertions or sign responses.
+	IdentityProvider *saml.IdentityProvider
+	// ServiceProvider is the service provider fixtures are sent to.
+	ServiceProvider *saml.ServiceProvider
+	// Key is the signing key of the IDP.
+	Key *keytest.Key
+}
+
+// NewFixtures returns Fixtures for sp. The AssertionConsumerServiceURL of sp should be set so
+// that responses are addressed to it.
+func NewFixtures(sp *saml.ServiceProvider) (*Fixtures, error) {
+	key, err := keytest.NewRSAKey()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := key.CertificateBetween(FixtureIssuer, fixtureCertificateNotBefore, fixtureCertificateNotAfter)
+	if err != nil {
+		return nil, err
+	}
+	return &Fixtures{
+		IdentityProvider: &saml.IdentityProvider{
+			EntityID:               FixtureIssuer,
+			SingleSignOnServiceURL: FixtureIssuer + ssoPath,
+			SingleLogoutServiceURL: FixtureIssuer + "/slo",
+			SigningKey:             key,
+			SigningCertificate:     cert,
+		},
+		ServiceProvider: sp,
+		Key:             key,
+	}, nil
+}
+
+// Metadata returns the metadata of the IDP, it is used to create the profiles under test.
+func (f *Fixtures) Metadata() *saml.EntityDescriptor {
+	return f.IdentityProvider.Metadata()
+}
+
+// Response returns a successful response to FixtureRequestID, issued at thisInstant, encoded
+// as it is posted in the SAMLResponse form value. The assertion is about DefaultNameID.
+// Overrides are applied in order before the response is signed.
+func (f *Fixtures) Response(thisInstant time.Time, overrides ...func(*saml.Response)) (string, error) {
+	request := &saml.ReceivedAuthnRequest{
+		Request:         &saml.AuthnRequest{ID: FixtureRequestID},
+		ServiceProvider: saml.NewSingleSignOnProfile(f.ServiceProvider, &saml.IDPSSODescriptor{}).Metadata(),
+		AssertionConsumerService: &saml.AssertionConsumerService{
+			Location: f.ServiceProvider.AssertionConsumerServiceURL,
+		},
+	}
+	principal := &saml.Principal{
+		NameID:       DefaultNameID,
+		NameIDFormat: saml.NameIDEmail,
+		SessionIndex: FixtureSessionIndex,
+	}
+	response, err := f.IdentityProvider.NewResponse(request, principal, thisInstant)
+	if err != nil {
+		return "", errors.Wrap(err, "creating response")
+	}
+	for _, override := range overrides {
+		override(response)
+	}
+	form, err := f.IdentityProvider.ResponsePostForm(request, response)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding response")
+	}
+	return form.Values.Get(saml.ResponseQueryKey), nil
+}
+
+// Assertion returns a signed assertion issued at thisInstant. Overrides are applied in order
+// before the assertion is signed.
+func (f *Fixtures) Assertion(thisInstant time.Time, overrides ...func(*saml.Assertion)) ([]byte, error) {
+	encoded, err := f.Response(thisInstant, func(response *saml.Response) {
+		for _, override := range overrides {
+			override(&response.Assertion)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding response")
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(decoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing response")
+	}
+	assertion := doc.Root().SelectElement("Assertion")
+	if assertion == nil {
+		return nil, errors.New("response does not contain a plain assertion")
+	}
+	doc.SetRoot(assertion)
+	return doc.WriteToBytes()
+}
+
+// LogoutRequest returns an HTTP request that posts an IDP initiated LogoutRequest for
+// DefaultNameID, issued at thisInstant, to the SingleLogoutServiceURL of the service provider.
+// Overrides are applied in order before the request is signed.
+func (f *Fixtures) LogoutRequest(thisInstant time.Time, overrides ...func(*saml.LogoutRequest)) (*http.Request, error) {
+	request := &saml.LogoutRequest{
+		XMLName: xml.Name{
+			Local: "samlp:LogoutRequest",
+		},
+		SAMLP:        "urn:oasis:names:tc:SAML:2.0:protocol",
+		SAML:         "urn:oasis:names:tc:SAML:2.0:assertion",
+		ID:           "_logout",
+		IssueInstant: saml.NewDateTime(thisInstant),
+		Version:      "2.0",
+		Destination:  f.ServiceProvider.SingleLogoutServiceURL,
+		Issuer: saml.Issuer{
+			XMLName: xml.Name{
+				Local: "saml:Issuer",
+			},
+			Url: f.IdentityProvider.EntityID,
+		},
+		NameID: saml.NameID{
+			XMLName: xml.Name{
+				Local: "saml:NameID",
+			},
+			Format: saml.NameIDEmail,
+			Value:  DefaultNameID,
+		},
+		SessionIndex: []string{FixtureSessionIndex},
+	}
+	for _, override := range overrides {
+		override(request)
+	}
+	encoded, err := f.IdentityProvider.EncodeMessage(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding logout request")
+	}
+	form := url.Values{}
+	form.Set(saml.RequestQueryKey, base64.StdEncoding.EncodeToString(encoded))
+	target := f.ServiceProvider.SingleLogoutServiceURL
+	if target == "" {
+		target = "/"
+	}
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r, nil
+}
@@ -0,0 +1,119 @@
+package samltest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/jonboulle/clockwork"
+	"github.com/murphybytes/saml"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fixtureInstant = time.Date(2017, 6, 11, 20, 29, 27, 0, time.UTC)
+
+func getFixtures(t *testing.T) *Fixtures {
+	f, err := NewFixtures(&saml.ServiceProvider{
+		IssuerURI:                   "https://sp.example.com",
+		AssertionConsumerServiceURL: "https://sp.example.com/acs",
+		SingleLogoutServiceURL:      "https://sp.example.com/slo",
+	})
+	require.Nil(t, err)
+	return f
+}
+
+func TestFixtureResponse(t *testing.T) {
+	f := getFixtures(t)
+	clock := clockwork.NewFakeClockAt(fixtureInstant)
+	profile := saml.NewSingleSignOnProfile(f.ServiceProvider, &f.Metadata().IDPSSODescriptor, saml.WithClock(clock))
+
+	encoded, err := f.Response(fixtureInstant)
+	require.Nil(t, err)
+	cb, err := profile.Callback(encoded)
+	require.Nil(t, err)
+	assert.Equal(t, DefaultNameID, cb.Identity.UserID)
+
+	clock.Advance(saml.DefaultAssertionLifetime + saml.DefaultClockSkew)
+	_, err = profile.Callback(encoded)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, saml.ErrExpired))
+}
+
+func TestFixtureResponseOverrides(t *testing.T) {
+	f := getFixtures(t)
+	profile := saml.NewSingleSignOnProfile(f.ServiceProvider, &f.Metadata().IDPSSODescriptor,
+		saml.WithClock(clockwork.NewFakeClockAt(fixtureInstant)))
+
+	tests := map[string]struct {
+		override func(*saml.Response)
+		err      error
+	}{
+		"audience": {
+			override: func(r *saml.Response) {
+				r.Assertion.Conditions.AudienceRestrictions[0].Audiences = []string{"uri:other"}
+			},
+			err: saml.ErrWrongAudience,
+		},
+		"destination": {
+			override: func(r *saml.Response) { r.Destination = "https://other.example.com/acs" },
+			err:      saml.ErrWrongDestination,
+		},
+		"not before": {
+			override: func(r *saml.Response) {
+				r.Assertion.Conditions.NotBefore = saml.NewDateTime(fixtureInstant.Add(time.Hour))
+			},
+			err: saml.ErrExpired,
+		},
+	}
+	for name, test := range tests {
+		encoded, err := f.Response(fixtureInstant, test.override)
+		require.Nil(t, err, name)
+		_, err = profile.Callback(encoded)
+		require.NotNil(t, err, name)
+		assert.True(t, errors.Is(err, test.err), name)
+	}
+}
+
+func TestFixtureAssertion(t *testing.T) {
+	f := getFixtures(t)
+	assertion, err := f.Assertion(fixtureInstant, func(a *saml.Assertion) {
+		a.Subject.NameID.Value = "john@kolide.co"
+	})
+	require.Nil(t, err)
+	doc := etree.NewDocument()
+	require.Nil(t, doc.ReadFromBytes(assertion))
+	assert.Equal(t, "Assertion", doc.Root().Tag)
+	assert.NotNil(t, doc.Root().SelectElement("Signature"))
+	assert.Equal(t, "john@kolide.co", doc.Root().FindElement("Subject/NameID").Text())
+}
+
+func TestFixtureLogoutRequest(t *testing.T) {
+	f := getFixtures(t)
+	var terminated []string
+	terminator := saml.SessionTerminatorFunc(func(nameID saml.NameID, sessionIndexes []string, reason string) error {
+		terminated = append(terminated, nameID.Value)
+		assert.Equal(t, []string{FixtureSessionIndex}, sessionIndexes)
+		return nil
+	})
+	profile := saml.NewSingleLogOutProfile(f.ServiceProvider, f.Metadata(),
+		saml.WithSessionTerminator(terminator), saml.WithClock(clockwork.NewFakeClockAt(fixtureInstant)))
+
+	r, err := f.LogoutRequest(fixtureInstant, func(lr *saml.LogoutRequest) {
+		lr.NameID.Value = "john@kolide.co"
+	})
+	require.Nil(t, err)
+	cb, err := profile.Callback(r)
+	require.Nil(t, err)
+	require.NotNil(t, cb.ExternallyInitiatedLogout)
+	assert.Equal(t, []string{"john@kolide.co"}, terminated)
+
+	r, err = f.LogoutRequest(fixtureInstant, func(lr *saml.LogoutRequest) {
+		lr.Issuer.Url = "https://other.example.com"
+	})
+	require.Nil(t, err)
+	_, err = profile.Callback(r)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, saml.ErrWrongIssuer))
+}
@@ -0,0 +1,214 @@
+// Package samltest provides an in-process identity provider for end to end tests of service
+// providers. Responses are signed when they are issued so tests do not depend on canned
+// responses that expire, and the IDP can be told to send failures and defective responses.
+package samltest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/murphybytes/saml"
+	"github.com/murphybytes/saml/keytest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultNameID is the NameID of the principal if the Behavior does not supply one.
+	DefaultNameID = "user@example.com"
+	// WrongAudience is the audience of assertions sent with the DefectWrongAudience defect.
+	WrongAudience = "https://wrong.example.com"
+	// WrongRecipient is the recipient of assertions sent with the DefectWrongRecipient defect.
+	WrongRecipient = "https://wrong.example.com/acs"
+
+	metadataPath = "/metadata"
+	ssoPath      = "/sso"
+)
+
+// Defect is a deliberate fault in the responses sent by the IDP. Defects may be combined.
+type Defect int
+
+const (
+	// DefectBadSignature signs responses with a key that does not match the certificate in
+	// the IDP metadata.
+	DefectBadSignature Defect = 1 << iota
+	// DefectWrongAudience issues assertions to WrongAudience rather than the service provider.
+	DefectWrongAudience
+	// DefectWrongRecipient addresses the subject confirmation of assertions to WrongRecipient.
+	DefectWrongRecipient
+)
+
+// Behavior controls the responses sent by the IDP.
+type Behavior struct {
+	// Principal is the subject of assertions. If its NameID is empty DefaultNameID is used.
+	Principal saml.Principal
+	// Status is the status of responses, for example saml.Responder. Responses with a status
+	// other than saml.Success do not contain an assertion. SubStatus is the optional second
+	// level status code, such as saml.NoPassive.
+	Status    int
+	SubStatus []int
+	// ClockOffset is added to the time responses are issued, a negative offset makes
+	// responses appear old and a positive one makes them appear to come from the future.
+	// AuthnRequests are validated with the current time.
+	ClockOffset time.Duration
+	// AssertionLifetime is the time assertions may be used for, if zero
+	// saml.DefaultAssertionLifetime is used.
+	AssertionLifetime time.Duration
+	// ResponseSignatures determines which elements of responses are signed, if zero only
+	// assertions are signed.
+	ResponseSignatures saml.SignaturePolicy
+	// EncryptAssertions encrypts assertions with the encryption certificate of the service
+	// provider.
+	EncryptAssertions bool
+	// Defects are faults introduced into responses.
+	Defects Defect
+}
+
+// IDP is a mock identity provider served by an httptest.Server. The metadata of the IDP is
+// served at MetadataURL and AuthnRequests sent with the redirect binding are answered with a
+// page containing a form that posts the response to the service provider.
+type IDP struct {
+	// Server is the server for the IDP endpoints.
+	Server *httptest.Server
+
+	mtx      sync.Mutex
+	idp      *saml.IdentityProvider
+	rogueKey *keytest.Key
+	behavior Behavior
+}
+
+// NewIDP starts a mock IDP. Service providers must be registered with AddServiceProvider
+// before they send AuthnRequests. The IDP should be closed when it is no longer needed.
+func NewIDP() (*IDP, error) {
+	key, err := keytest.NewRSAKey()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := key.Certificate("samltest", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	rogueKey, err := keytest.NewRSAKey()
+	if err != nil {
+		return nil, err
+	}
+	m := &IDP{rogueKey: rogueKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc(metadataPath, m.handleMetadata)
+	mux.HandleFunc(ssoPath, m.handleSSO)
+	m.Server = httptest.NewServer(mux)
+	m.idp = &saml.IdentityProvider{
+		EntityID:               m.Server.URL + metadataPath,
+		SingleSignOnServiceURL: m.Server.URL + ssoPath,
+		NameIDFormats:          []string{saml.NameIDEmail, saml.NameIDUnspecified},
+		SigningKey:             key,
+		SigningCertificate:     cert,
+	}
+	return m, nil
+}
+
+// Close shuts down the server.
+func (m *IDP) Close() {
+	m.Server.Close()
+}
+
+// MetadataURL returns the URL the IDP metadata is served from.
+func (m *IDP) MetadataURL() string {
+	return m.Server.URL + metadataPath
+}
+
+// Metadata returns the IDP metadata.
+func (m *IDP) Metadata() *saml.EntityDescriptor {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.idp.Metadata()
+}
+
+// AddServiceProvider registers the metadata of a service provider with the IDP.
+func (m *IDP) AddServiceProvider(sp *saml.SPEntityDescriptor) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.idp.ServiceProviders = append(m.idp.ServiceProviders, sp)
+}
+
+// SetBehavior changes the responses sent by the IDP.
+func (m *IDP) SetBehavior(behavior Behavior) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.behavior = behavior
+}
+
+// Login answers the AuthnRequest in redirectURL, as returned by
+// saml.SingleSignOnProfile.RedirectBinding, without an HTTP round trip. It returns the form
+// the browser would post to the service provider.
+func (m *IDP) Login(redirectURL string) (*saml.PostForm, error) {
+	return m.respond(httptest.NewRequest(http.MethodGet, redirectURL, nil))
+}
+
+func (m *IDP) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	m.mtx.Lock()
+	metadata, err := m.idp.EncodeMetadata(false, time.Now())
+	m.mtx.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
+
+func (m *IDP) handleSSO(w http.ResponseWriter, r *http.Request) {
+	form, err := m.respond(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = form.Write(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// respond validates an AuthnRequest and builds the response required by the behavior.
+func (m *IDP) respond(r *http.Request) (*saml.PostForm, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	behavior := m.behavior
+	m.idp.AssertionLifetime = behavior.AssertionLifetime
+	m.idp.ResponseSignatures = behavior.ResponseSignatures
+	m.idp.EncryptAssertions = behavior.EncryptAssertions
+	request, err := m.idp.HandleAuthnRequest(r, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "handling authn request")
+	}
+	thisInstant := time.Now().Add(behavior.ClockOffset)
+	var response *saml.Response
+	if behavior.Status != saml.Success {
+		response, err = m.idp.NewErrorResponse(request, thisInstant, behavior.Status, behavior.SubStatus...)
+	} else {
+		principal := behavior.Principal
+		if principal.NameID == "" {
+			principal.NameID = DefaultNameID
+		}
+		response, err = m.idp.NewResponse(request, &principal, thisInstant)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "creating response")
+	}
+	if behavior.Defects&DefectWrongAudience != 0 {
+		for i := range response.Assertion.Conditions.AudienceRestrictions {
+			response.Assertion.Conditions.AudienceRestrictions[i].Audiences = []string{WrongAudience}
+		}
+	}
+	if behavior.Defects&DefectWrongRecipient != 0 {
+		response.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient = WrongRecipient
+	}
+	if behavior.Defects&DefectBadSignature != 0 {
+		key := m.idp.SigningKey
+		m.idp.SigningKey = m.rogueKey
+		defer func() { m.idp.SigningKey = key }()
+	}
+	return m.idp.ResponsePostForm(request, response)
+}
@@ -0,0 +1,157 @@
+package samltest
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/murphybytes/saml"
+	"github.com/murphybytes/saml/keytest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getTestProfile returns a running IDP and the profile of a service provider registered with it.
+func getTestProfile(t *testing.T) (*IDP, *saml.SingleSignOnProfile) {
+	idp, err := NewIDP()
+	require.Nil(t, err)
+	metadata, err := saml.GetMetadataFromURL(idp.MetadataURL())
+	require.Nil(t, err)
+	sp := &saml.ServiceProvider{
+		IssuerURI:                   "https://sp.example.com",
+		AssertionConsumerServiceURL: "https://sp.example.com/acs",
+	}
+	profile := saml.NewSingleSignOnProfile(sp, &metadata.IDPSSODescriptor)
+	idp.AddServiceProvider(profile.Metadata())
+	return idp, profile
+}
+
+// login sends an AuthnRequest to idp and returns the encoded response.
+func login(t *testing.T, idp *IDP, profile *saml.SingleSignOnProfile) string {
+	location, err := profile.RedirectBinding(saml.RelayState("/home"))
+	require.Nil(t, err)
+	form, err := idp.Login(location)
+	require.Nil(t, err)
+	assert.Equal(t, "https://sp.example.com/acs", form.URL)
+	assert.Equal(t, "/home", form.Values.Get(saml.RelayStateQueryKey))
+	return form.Values.Get(saml.ResponseQueryKey)
+}
+
+func TestLogin(t *testing.T) {
+	idp, profile := getTestProfile(t)
+	defer idp.Close()
+	idp.SetBehavior(Behavior{
+		Principal: saml.Principal{
+			NameID:     "john@kolide.co",
+			Attributes: map[string][]string{"groups": {"admins"}},
+		},
+	})
+
+	encoded := login(t, idp, profile)
+	cb, err := profile.HandlePostResponse(encoded, time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, "john@kolide.co", cb.Identity.UserID)
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	require.Nil(t, err)
+	doc := etree.NewDocument()
+	require.Nil(t, doc.ReadFromBytes(decoded))
+	value := doc.FindElement("//Attribute[@Name='groups']/AttributeValue")
+	require.NotNil(t, value)
+	assert.Equal(t, "admins", value.Text())
+}
+
+func TestLoginHTTP(t *testing.T) {
+	idp, profile := getTestProfile(t)
+	defer idp.Close()
+	location, err := profile.RedirectBinding()
+	require.Nil(t, err)
+	resp, err := http.Get(location)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.True(t, strings.Contains(string(body), `name="SAMLResponse"`))
+
+	// requests from unknown service providers are rejected
+	other := saml.NewSingleSignOnProfile(&saml.ServiceProvider{IssuerURI: "uri:unknown"}, &idp.Metadata().IDPSSODescriptor)
+	location, err = other.RedirectBinding()
+	require.Nil(t, err)
+	resp, err = http.Get(location)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestLoginFailures(t *testing.T) {
+	tests := map[string]struct {
+		behavior Behavior
+		err      error
+	}{
+		"bad signature":   {behavior: Behavior{Defects: DefectBadSignature}, err: saml.ErrBadSignature},
+		"wrong audience":  {behavior: Behavior{Defects: DefectWrongAudience}, err: saml.ErrWrongAudience},
+		"wrong recipient": {behavior: Behavior{Defects: DefectWrongRecipient}, err: saml.ErrWrongRecipient},
+		"expired":         {behavior: Behavior{ClockOffset: -time.Hour}, err: saml.ErrExpired},
+	}
+	idp, profile := getTestProfile(t)
+	defer idp.Close()
+	for name, test := range tests {
+		idp.SetBehavior(test.behavior)
+		_, err := profile.HandlePostResponse(login(t, idp, profile), time.Now())
+		require.NotNil(t, err, name)
+		assert.True(t, errors.Is(err, test.err), name)
+	}
+}
+
+func TestLoginAssertionLifetime(t *testing.T) {
+	idp, profile := getTestProfile(t)
+	defer idp.Close()
+	idp.SetBehavior(Behavior{AssertionLifetime: time.Minute})
+	encoded := login(t, idp, profile)
+	_, err := profile.HandlePostResponse(encoded, time.Now())
+	require.Nil(t, err)
+	_, err = profile.HandlePostResponse(encoded, time.Now().Add(time.Minute+saml.DefaultClockSkew))
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, saml.ErrExpired))
+}
+
+func TestLoginStatus(t *testing.T) {
+	idp, profile := getTestProfile(t)
+	defer idp.Close()
+	idp.SetBehavior(Behavior{Status: saml.Responder, SubStatus: []int{saml.NoPassive}})
+	_, err := profile.HandlePostResponse(login(t, idp, profile), time.Now())
+	require.NotNil(t, err)
+	var se *saml.StatusError
+	require.True(t, errors.As(err, &se))
+	assert.True(t, se.HasCode(saml.NoPassive))
+}
+
+func TestLoginEncrypted(t *testing.T) {
+	idp, err := NewIDP()
+	require.Nil(t, err)
+	defer idp.Close()
+	key, err := keytest.NewRSAKey()
+	require.Nil(t, err)
+	cert, err := key.Certificate("https://sp.example.com", time.Hour)
+	require.Nil(t, err)
+	sp := &saml.ServiceProvider{
+		IssuerURI:                   "https://sp.example.com",
+		AssertionConsumerServiceURL: "https://sp.example.com/acs",
+		DecryptionKey:               key,
+		EncryptionCertificate:       cert,
+	}
+	profile := saml.NewSingleSignOnProfile(sp, &idp.Metadata().IDPSSODescriptor)
+	idp.AddServiceProvider(profile.Metadata())
+	idp.SetBehavior(Behavior{EncryptAssertions: true, ResponseSignatures: saml.WantBothSigned})
+
+	cb, err := profile.HandlePostResponse(login(t, idp, profile), time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, DefaultNameID, cb.Identity.UserID)
+	assert.Equal(t, 1, key.Decryptions())
+}
@@ -0,0 +1,100 @@
+package saml
+
+import "sync"
+
+// ParticipantSession is a session at a service provider established with an assertion issued by
+// the IDP. The IDP asks each participant to end its session when the principal logs out.
+type ParticipantSession struct {
+	// ServiceProvider is the entity ID of the service provider the assertion was issued to.
+	ServiceProvider string
+	// NameID identifies the principal, as it was sent in the assertion.
+	NameID NameID
+	// SessionIndex is the SessionIndex of the AuthnStatement, it may be empty.
+	SessionIndex string
+}
+
+// SessionStore records the participant sessions of each principal. If an IDP runs on more
+// than one host the store must be shared between them.
+type SessionStore interface {
+	// AddSession records a session established at a service provider.
+	AddSession(session *ParticipantSession) error
+	// Sessions returns the sessions of the principal with the NameID value nameID.
+	Sessions(nameID string) ([]*ParticipantSession, error)
+	// RemoveSession removes a session once it has been ended. Removing a session that is not
+	// recorded is not an error.
+	RemoveSession(session *ParticipantSession) error
+}
+
+type memorySessionStore struct {
+	mtx      sync.Mutex
+	sessions map[string][]*ParticipantSession
+}
+
+// NewMemorySessionStore returns a SessionStore that holds sessions in memory.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		sessions: map[string][]*ParticipantSession{},
+	}
+}
+
+func (ss *memorySessionStore) AddSession(session *ParticipantSession) error {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	for _, s := range ss.sessions[session.NameID.Value] {
+		if sameSession(s, session) {
+			return nil
+		}
+	}
+	ss.sessions[session.NameID.Value] = append(ss.sessions[session.NameID.Value], session)
+	return nil
+}
+
+func (ss *memorySessionStore) Sessions(nameID string) ([]*ParticipantSession, error) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	return append([]*ParticipantSession(nil), ss.sessions[nameID]...), nil
+}
+
+func (ss *memorySessionStore) RemoveSession(session *ParticipantSession) error {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	var remaining []*ParticipantSession
+	for _, s := range ss.sessions[session.NameID.Value] {
+		if !sameSession(s, session) {
+			remaining = append(remaining, s)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(ss.sessions, session.NameID.Value)
+	} else {
+		ss.sessions[session.NameID.Value] = remaining
+	}
+	return nil
+}
+
+func sameSession(a, b *ParticipantSession) bool {
+	return a.ServiceProvider == b.ServiceProvider &&
+		sameNameID(a.NameID, b.NameID) &&
+		a.SessionIndex == b.SessionIndex
+}
+
+// samePrincipal reports whether a and b identify the same principal, they may have been issued
+// to different service providers. A missing Format is the unspecified format.
+func samePrincipal(a, b NameID) bool {
+	return a.Value == b.Value &&
+		nameIDFormat(a) == nameIDFormat(b) &&
+		a.NameQualifier == b.NameQualifier
+}
+
+// sameNameID reports whether a and b are the same NameID, including the service provider that
+// qualifies it.
+func sameNameID(a, b NameID) bool {
+	return samePrincipal(a, b) && a.SPNameQualifier == b.SPNameQualifier
+}
+
+func nameIDFormat(n NameID) string {
+	if n.Format == "" {
+		return NameIDUnspecified
+	}
+	return n.Format
+}
@@ -0,0 +1,47 @@
+package saml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	ss := NewMemorySessionStore()
+	one := &ParticipantSession{ServiceProvider: "sp1", NameID: NameID{Value: "john@kolide.co"}, SessionIndex: "1"}
+	two := &ParticipantSession{ServiceProvider: "sp2", NameID: NameID{Value: "john@kolide.co"}, SessionIndex: "1"}
+	require.Nil(t, ss.AddSession(one))
+	require.Nil(t, ss.AddSession(two))
+	// a session is only recorded once
+	require.Nil(t, ss.AddSession(&ParticipantSession{ServiceProvider: "sp1", NameID: NameID{Value: "john@kolide.co"}, SessionIndex: "1"}))
+
+	sessions, err := ss.Sessions("john@kolide.co")
+	require.Nil(t, err)
+	assert.Equal(t, []*ParticipantSession{one, two}, sessions)
+
+	require.Nil(t, ss.RemoveSession(one))
+	sessions, err = ss.Sessions("john@kolide.co")
+	require.Nil(t, err)
+	assert.Equal(t, []*ParticipantSession{two}, sessions)
+
+	require.Nil(t, ss.RemoveSession(two))
+	require.Nil(t, ss.RemoveSession(two))
+	sessions, err = ss.Sessions("john@kolide.co")
+	require.Nil(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestSameNameID(t *testing.T) {
+	email := NameID{Format: NameIDEmail, Value: "john@kolide.co"}
+	assert.True(t, sameNameID(email, email))
+	assert.True(t, sameNameID(NameID{Value: "john"}, NameID{Format: NameIDUnspecified, Value: "john"}))
+	assert.False(t, sameNameID(email, NameID{Value: "john@kolide.co"}))
+
+	qualified := email
+	qualified.SPNameQualifier = "https://sp1.example.com"
+	assert.False(t, sameNameID(email, qualified))
+	assert.True(t, samePrincipal(email, qualified))
+	qualified.NameQualifier = "https://idp.example.com"
+	assert.False(t, samePrincipal(email, qualified))
+}
@@ -0,0 +1,80 @@
+package saml
+
+import "github.com/pkg/errors"
+
+var (
+	// ErrPartialLogout may be returned by a SessionTerminator when only some of the
+	// principal's sessions could be ended. The IDP is told logout was partially successful.
+	ErrPartialLogout = errors.New("not all sessions were logged out")
+)
+
+// SessionTerminator is called when the IDP asks the service provider to log a principal out.
+// nameID identifies the principal, sessionIndexes identify the sessions to end, if empty all
+// of the principal's sessions should be ended. reason is the optional reason for logout supplied
+// by the IDP. If an error is returned the IDP is told that logout failed, unless the error is
+// or wraps ErrPartialLogout.
+type SessionTerminator interface {
+	TerminateSessions(nameID NameID, sessionIndexes []string, reason string) error
+}
+
+// SessionTerminatorFunc allows an ordinary function to be used as a SessionTerminator.
+type SessionTerminatorFunc func(nameID NameID, sessionIndexes []string, reason string) error
+
+// TerminateSessions calls f.
+func (f SessionTerminatorFunc) TerminateSessions(nameID NameID, sessionIndexes []string, reason string) error {
+	return f(nameID, sessionIndexes, reason)
+}
+
+type sessionTerminator struct {
+	SessionTerminator
+}
+
+// WithSessionTerminator is an optional parameter used to supply the SessionTerminator that ends
+// user sessions when the IDP initiates logout. Without one no session can be ended, so logout
+// requests from the IDP are answered with a Responder status.
+func WithSessionTerminator(st SessionTerminator) func() interface{} {
+	return func() interface{} {
+		return sessionTerminator{st}
+	}
+}
+
+// terminateSessions calls st and returns the status that should be sent to the IDP.
+func terminateSessions(st SessionTerminator, r *LogoutRequest) Status {
+	if st == nil {
+		return newStatus(Responder)
+	}
+	err := st.TerminateSessions(r.NameID, r.SessionIndex, r.Reason)
+	switch {
+	case err == nil:
+		return newStatus(Success)
+	case errors.Is(err, ErrPartialLogout):
+		return newStatus(Success, PartialLogout)
+	}
+	return newStatus(Responder)
+}
+
+type unsignedLogoutRequests bool
+
+// WithUnsignedLogoutRequests is an optional parameter to NewSingleLogOutProfile that allows
+// logout requests from the IDP that are not signed. By default a logout request must carry a
+// valid query or XML signature from the IDP before any session is terminated. Only allow unsigned
+// requests if they reach the service provider over a channel that authenticates the IDP. Passed
+// to NewLogoutOrchestrator it allows unsigned logout requests from service providers.
+func WithUnsignedLogoutRequests(allow bool) func() interface{} {
+	return func() interface{} {
+		return unsignedLogoutRequests(allow)
+	}
+}
+
+type unsignedLogoutResponses bool
+
+// WithUnsignedLogoutResponses is an optional parameter to NewSingleLogOutProfile that allows
+// logout responses from the IDP that are not signed. By default a logout response must carry a
+// valid query or XML signature from the IDP, otherwise anyone who knows the ID of an outstanding
+// logout request could report that logout succeeded. Only allow unsigned responses if they reach
+// the service provider over a channel that authenticates the IDP.
+func WithUnsignedLogoutResponses(allow bool) func() interface{} {
+	return func() interface{} {
+		return unsignedLogoutResponses(allow)
+	}
+}
@@ -0,0 +1,217 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"math/big"
+	"net/url"
+
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	// Signature algorithms that may be used to sign outbound messages.
+	// See https://www.w3.org/TR/xmldsig-core1/#sec-AlgID
+	SignatureMethodRSASHA256   = dsig.RSASHA256SignatureMethod
+	SignatureMethodRSASHA512   = dsig.RSASHA512SignatureMethod
+	SignatureMethodECDSASHA256 = dsig.ECDSASHA256SignatureMethod
+	SignatureMethodECDSASHA512 = dsig.ECDSASHA512SignatureMethod
+)
+
+var (
+	// ErrSigningNotConfigured occurs when a signed message is requested but the
+	// ServiceProvider does not have a signing key.
+	ErrSigningNotConfigured = errors.New("service provider signing key not configured")
+)
+
+// signsMessages returns true if outbound messages from this service provider
+// should be signed.
+func (sp *ServiceProvider) signsMessages() bool {
+	return sp.SigningKey != nil
+}
+
+func (sp *ServiceProvider) getSigningContext() (*dsig.SigningContext, error) {
+	if !sp.signsMessages() {
+		return nil, ErrSigningNotConfigured
+	}
+	return newSigningContext(sp.SigningKey, sp.SigningCertificate, sp.SignatureMethod)
+}
+
+// newSigningContext returns a context that signs with key. If cert is not nil it is included
+// in the KeyInfo of signatures. If method is empty the goxmldsig default is used. ECDSA
+// signatures are the concatenation of r and s, as XML signatures and the redirect binding
+// require.
+func newSigningContext(key crypto.Signer, cert *x509.Certificate, method string) (*dsig.SigningContext, error) {
+	var certs [][]byte
+	if cert != nil {
+		certs = append(certs, cert.Raw)
+	}
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		key = ecdsaSigner{key}
+	}
+	context, err := dsig.NewSigningContext(key, certs)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating signing context")
+	}
+	if method != "" {
+		err = context.SetSignatureMethod(method)
+		if err != nil {
+			return nil, errors.Wrap(err, "setting signature method")
+		}
+	}
+	return context, nil
+}
+
+// redirectBindingURL builds the URL used to deliver a deflated message to location
+// using the HTTP-Redirect binding. If the service provider has a signing key a
+// detached signature is added to the query in the order required by the SAML bindings
+// spec. See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf Section 3.4.4.1
+func (sp *ServiceProvider) redirectBindingURL(location, queryKey string, message *bytes.Buffer, rs string) (string, error) {
+	var context *dsig.SigningContext
+	if sp.signsMessages() {
+		var err error
+		context, err = sp.getSigningContext()
+		if err != nil {
+			return "", err
+		}
+	}
+	return redirectBindingURL(context, location, queryKey, message, rs)
+}
+
+// redirectBindingURL builds a redirect binding URL, signed with context if it is not nil.
+func redirectBindingURL(context *dsig.SigningContext, location, queryKey string, message *bytes.Buffer, rs string) (string, error) {
+	deflated, err := deflate(message)
+	if err != nil {
+		return "", errors.Wrap(err, "compressing message")
+	}
+	destination, err := url.Parse(location)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing destination url")
+	}
+	query := queryKey + "=" + url.QueryEscape(deflated)
+	if rs != "" {
+		query += "&" + RelayStateQueryKey + "=" + url.QueryEscape(rs)
+	}
+	if context != nil {
+		query += "&" + SigAlgQueryKey + "=" + url.QueryEscape(context.GetSignatureMethodIdentifier())
+		signature, err := context.SignString(query)
+		if err != nil {
+			return "", errors.Wrap(err, "signing redirect binding")
+		}
+		query += "&" + SignatureQueryKey + "=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+	}
+	if destination.RawQuery != "" {
+		query = destination.RawQuery + "&" + query
+	}
+	destination.RawQuery = query
+	return destination.String(), nil
+}
+
+// encodeSigned encodes message as XML and, if the service provider has a signing key,
+// adds an enveloped signature following the Issuer element. This is the form messages
+// take when delivered with the HTTP-POST binding.
+func (sp *ServiceProvider) encodeSigned(message interface{}) (*bytes.Buffer, error) {
+	var encoded bytes.Buffer
+	err := xml.NewEncoder(&encoded).Encode(message)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding message")
+	}
+	if !sp.signsMessages() {
+		return &encoded, nil
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(encoded.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "reading encoded message")
+	}
+	context, err := sp.getSigningContext()
+	if err != nil {
+		return nil, err
+	}
+	signed, err := signEnveloped(context, doc.Root())
+	if err != nil {
+		return nil, err
+	}
+	doc.SetRoot(signed)
+	var signedBuff bytes.Buffer
+	_, err = doc.WriteTo(&signedBuff)
+	if err != nil {
+		return nil, errors.Wrap(err, "writing signed message")
+	}
+	return &signedBuff, nil
+}
+
+// signEnveloped signs el placing the signature immediately after the Issuer
+// as required by the SAML schema.
+func signEnveloped(context *dsig.SigningContext, el *etree.Element) (*etree.Element, error) {
+	signature, err := context.ConstructSignature(el, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing enveloped signature")
+	}
+	signed := el.Copy()
+	position := 0
+	if issuer := signed.SelectElement("Issuer"); issuer != nil {
+		position = issuer.Index() + 1
+	}
+	signed.InsertChildAt(position, signature)
+	return signed, nil
+}
+
+// ecdsaSigner returns the signatures of an ECDSA crypto.Signer as the fixed width concatenation
+// of r and s used by XML signatures, rather than the ASN.1 form returned by crypto.Signer.
+// See https://www.w3.org/TR/xmldsig-core1/#sec-ECDSA
+type ecdsaSigner struct {
+	crypto.Signer
+}
+
+func (s ecdsaSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signature, err := s.Signer.Sign(rand, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	return rawECDSASignature(s.Public().(*ecdsa.PublicKey), signature)
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// rawECDSASignature converts an ASN.1 ECDSA signature made with key to the concatenation of r
+// and s, each padded to the size of the curve.
+func rawECDSASignature(key *ecdsa.PublicKey, signature []byte) ([]byte, error) {
+	var sig ecdsaSignature
+	rest, err := asn1.Unmarshal(signature, &sig)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding ECDSA signature")
+	}
+	size := (key.Curve.Params().BitSize + 7) / 8
+	if len(rest) != 0 || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 ||
+		sig.R.BitLen() > 8*size || sig.S.BitLen() > 8*size {
+		return nil, errors.New("invalid ECDSA signature")
+	}
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
+
+// asn1ECDSASignature converts an ECDSA signature that is the concatenation of r and s to
+// ASN.1, the form verified by the standard library.
+func asn1ECDSASignature(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, errors.New("invalid ECDSA signature length")
+	}
+	size := len(raw) / 2
+	return asn1.Marshal(ecdsaSignature{
+		R: new(big.Int).SetBytes(raw[:size]),
+		S: new(big.Int).SetBytes(raw[size:]),
+	})
+}
@@ -0,0 +1,215 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/murphybytes/saml/keytest"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getSigningServiceProvider(t *testing.T, key crypto.Signer) *ServiceProvider {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "uri:myserviceprovider",
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return &ServiceProvider{
+		IssuerURI: "uri:myserviceprovider",
+		NameIDFormats: []string{
+			NameIDEmail,
+		},
+		SigningKey:         key,
+		SigningCertificate: cert,
+	}
+}
+
+func getRSAServiceProvider(t *testing.T) *ServiceProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	return getSigningServiceProvider(t, key)
+}
+
+func TestRedirectBindingURLUnsigned(t *testing.T) {
+	sp := &ServiceProvider{IssuerURI: "uri:myserviceprovider"}
+	location, err := sp.redirectBindingURL("https://myidp.com/slo?x=1", RequestQueryKey, bytes.NewBufferString("<foo/>"), "bar")
+	require.Nil(t, err)
+	parsed, err := url.Parse(location)
+	require.Nil(t, err)
+	assert.Equal(t, "1", parsed.Query().Get("x"))
+	assert.Equal(t, "bar", parsed.Query().Get(RelayStateQueryKey))
+	assert.NotEqual(t, "", parsed.Query().Get(RequestQueryKey))
+	assert.Equal(t, "", parsed.Query().Get(SignatureQueryKey))
+}
+
+func TestRedirectBindingURLSignedRSA(t *testing.T) {
+	sp := getRSAServiceProvider(t)
+	sp.SignatureMethod = SignatureMethodRSASHA512
+	location, err := sp.redirectBindingURL("https://myidp.com/slo", RequestQueryKey, bytes.NewBufferString("<foo/>"), "bar")
+	require.Nil(t, err)
+	parsed, err := url.Parse(location)
+	require.Nil(t, err)
+	assert.Equal(t, SignatureMethodRSASHA512, parsed.Query().Get(SigAlgQueryKey))
+
+	// signed content is everything up to the signature in the order given by the bindings spec
+	signed := parsed.RawQuery[:strings.Index(parsed.RawQuery, "&"+SignatureQueryKey+"=")]
+	assert.True(t, strings.HasPrefix(signed, RequestQueryKey+"="))
+	signature, err := base64.StdEncoding.DecodeString(parsed.Query().Get(SignatureQueryKey))
+	require.Nil(t, err)
+	digest := sha512.Sum512([]byte(signed))
+	err = rsa.VerifyPKCS1v15(sp.SigningKey.Public().(*rsa.PublicKey), crypto.SHA512, digest[:], signature)
+	assert.Nil(t, err)
+}
+
+func TestRedirectBindingURLSignedECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	sp := getSigningServiceProvider(t, key)
+	location, err := sp.redirectBindingURL("https://myidp.com/slo", ResponseQueryKey, bytes.NewBufferString("<foo/>"), "")
+	require.Nil(t, err)
+	parsed, err := url.Parse(location)
+	require.Nil(t, err)
+	assert.Equal(t, SignatureMethodECDSASHA256, parsed.Query().Get(SigAlgQueryKey))
+	signed := parsed.RawQuery[:strings.Index(parsed.RawQuery, "&"+SignatureQueryKey+"=")]
+	signature, err := base64.StdEncoding.DecodeString(parsed.Query().Get(SignatureQueryKey))
+	require.Nil(t, err)
+	// the signature is r and s, each 32 bytes for P-256, not ASN.1
+	require.Len(t, signature, 64)
+	digest := sha256.Sum256([]byte(signed))
+	r := new(big.Int).SetBytes(signature[:32])
+	sig := new(big.Int).SetBytes(signature[32:])
+	assert.True(t, ecdsa.Verify(&key.PublicKey, digest[:], r, sig))
+}
+
+func TestEncodeSignedECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.Nil(t, err)
+	sp := getSigningServiceProvider(t, key)
+	sp.SignatureMethod = SignatureMethodECDSASHA384
+	request := LogoutRequest{
+		XMLName:      xml.Name{Local: "samlp:LogoutRequest"},
+		ID:           "abc123",
+		SAMLP:        samlProtocalNamespace,
+		SAML:         samlNamespace,
+		IssueInstant: NewDateTime(time.Now()),
+		Version:      samlVersion,
+		Issuer:       Issuer{XMLName: xml.Name{Local: "saml:Issuer"}, Url: sp.IssuerURI},
+	}
+	encoded, err := sp.encodeSigned(request)
+	require.Nil(t, err)
+	doc := etree.NewDocument()
+	require.Nil(t, doc.ReadFromBytes(encoded.Bytes()))
+	// the signature is r and s, each 48 bytes for P-384, not ASN.1
+	value, err := base64.StdEncoding.DecodeString(doc.FindElement("//SignatureValue").Text())
+	require.Nil(t, err)
+	assert.Len(t, value, 96)
+
+	validator := &signatureValidator{certs: []*x509.Certificate{sp.SigningCertificate}, thisInstant: time.Now()}
+	_, err = validator.Validate(doc.Root())
+	assert.Nil(t, err)
+}
+
+func TestRedirectBindingURLIncompatibleMethod(t *testing.T) {
+	sp := getRSAServiceProvider(t)
+	sp.SignatureMethod = SignatureMethodECDSASHA256
+	_, err := sp.redirectBindingURL("https://myidp.com/slo", RequestQueryKey, bytes.NewBufferString("<foo/>"), "")
+	assert.NotNil(t, err)
+}
+
+func TestEncodeSigned(t *testing.T) {
+	sp := getRSAServiceProvider(t)
+	request := LogoutRequest{
+		XMLName: xml.Name{
+			Local: "samlp:LogoutRequest",
+		},
+		ID:           "abc123",
+		SAMLP:        samlProtocalNamespace,
+		SAML:         samlNamespace,
+		IssueInstant: NewDateTime(time.Now()),
+		Version:      samlVersion,
+		Issuer: Issuer{
+			XMLName: xml.Name{
+				Local: "saml:Issuer",
+			},
+			Url: sp.IssuerURI,
+		},
+		NameID: NameID{
+			Format: NameIDEmail,
+			Value:  "john@kolide.co",
+		},
+	}
+	encoded, err := sp.encodeSigned(request)
+	require.Nil(t, err)
+
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(encoded.Bytes())
+	require.Nil(t, err)
+	children := doc.Root().ChildElements()
+	require.True(t, len(children) > 1)
+	assert.Equal(t, "Issuer", children[0].Tag)
+	assert.Equal(t, "Signature", children[1].Tag)
+
+	context := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{sp.SigningCertificate},
+	})
+	_, err = context.Validate(doc.Root())
+	assert.Nil(t, err)
+}
+
+func TestEncodeSignedWithoutKey(t *testing.T) {
+	sp := &ServiceProvider{IssuerURI: "uri:myserviceprovider"}
+	encoded, err := sp.encodeSigned(Issuer{XMLName: xml.Name{Local: "saml:Issuer"}, Url: sp.IssuerURI})
+	require.Nil(t, err)
+	assert.NotContains(t, encoded.String(), "Signature")
+}
+
+func TestSigningWithHardwareKey(t *testing.T) {
+	key, err := keytest.NewRSAKey()
+	require.Nil(t, err)
+	cert, err := key.Certificate("uri:myserviceprovider", time.Hour)
+	require.Nil(t, err)
+	sp := &ServiceProvider{
+		IssuerURI:          "uri:myserviceprovider",
+		SigningKey:         key,
+		SigningCertificate: cert,
+	}
+	signatures := key.Signatures()
+	encoded, err := sp.encodeSigned(Issuer{XMLName: xml.Name{Local: "saml:Issuer"}, Url: sp.IssuerURI})
+	require.Nil(t, err)
+	assert.Contains(t, encoded.String(), "Signature")
+	_, err = sp.redirectBindingURL("https://myidp.com/slo", RequestQueryKey, bytes.NewBufferString("<foo/>"), "")
+	require.Nil(t, err)
+	assert.Equal(t, signatures+2, key.Signatures())
+
+	key.SetError(errors.New("hsm unavailable"))
+	_, err = sp.encodeSigned(Issuer{XMLName: xml.Name{Local: "saml:Issuer"}, Url: sp.IssuerURI})
+	assert.NotNil(t, err)
+	_, err = sp.redirectBindingURL("https://myidp.com/slo", RequestQueryKey, bytes.NewBufferString("<foo/>"), "")
+	assert.NotNil(t, err)
+}
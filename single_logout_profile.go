@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/xml"
 	"net/http"
-	"net/url"
 	"time"
 
 	"github.com/beevik/etree"
@@ -14,37 +13,136 @@ import (
 
 // SingleLogOutProfile provides single log out services
 type SingleLogOutProfile struct {
-	serviceProvider *ServiceProvider
-	entity          *EntityDescriptor
+	serviceProvider        *ServiceProvider
+	entity                 *EntityDescriptor
+	requests               RequestTracker
+	terminator             SessionTerminator
+	clockSkew              time.Duration
+	algorithms             allowedAlgorithms
+	certificates           certificatePolicy
+	clock                  Clock
+	allowUnsignedRequests  bool
+	allowUnsignedResponses bool
 }
 
-// NewSingleLogOutProfile creates a SingleLogOutProfile
-func NewSingleLogOutProfile(spDescription *ServiceProvider, entity *EntityDescriptor) *SingleLogOutProfile {
-	return &SingleLogOutProfile{
+// NewSingleLogOutProfile creates a SingleLogOutProfile.
+//
+// Logout responses are only accepted if they answer a request sent by the same profile, others
+// fail with ReasonReplay. If requests are sent and responses handled by different profiles, for
+// example a profile created per HTTP request or one per host, WithRequestTracker must be used to
+// supply a tracker shared between them. Without it requests are tracked in memory by this profile.
+//
+// Logout requests and responses from the IDP must be signed by the IDP, WithUnsignedLogoutRequests
+// and WithUnsignedLogoutResponses may be used to accept unsigned requests or responses.
+// WithClockSkew may be used to change the tolerance for differences between the IDP clock and
+// ours. WithSessionTerminator supplies the callback that logs users out when the IDP initiates
+// logout, without it the IDP is told that logout failed. WithAllowedAlgorithms changes the
+// signature algorithms accepted from the IDP. WithCertificateValidity and
+// WithCertificateExpiryWarning control how the dates of IDP certificates are handled, and WithClock
+// supplies the clock used for the messages sent by the profile and by Callback.
+func NewSingleLogOutProfile(spDescription *ServiceProvider, entity *EntityDescriptor, opts ...func() interface{}) *SingleLogOutProfile {
+	slp := &SingleLogOutProfile{
 		serviceProvider: spDescription,
 		entity:          entity,
+		clockSkew:       DefaultClockSkew,
+		algorithms:      defaultAllowedAlgorithms(),
+		clock:           realClock{},
 	}
+	for _, opt := range opts {
+		switch t := opt().(type) {
+		case requestTracker:
+			slp.requests = t.RequestTracker
+		case clockSkew:
+			slp.clockSkew = time.Duration(t)
+		case profileClock:
+			slp.clock = t.Clock
+		case sessionTerminator:
+			slp.terminator = t.SessionTerminator
+		case allowedAlgorithms:
+			slp.algorithms = t
+		case certificateValidity:
+			slp.certificates.ignoreValidity = !bool(t)
+		case certificateExpiryWarning:
+			slp.certificates.expiryWarning = t
+		case unsignedLogoutRequests:
+			slp.allowUnsignedRequests = bool(t)
+		case unsignedLogoutResponses:
+			slp.allowUnsignedResponses = bool(t)
+		}
+	}
+	if slp.requests == nil {
+		slp.requests = NewMemoryRequestTracker(maxRequestAge)
+	}
+	return slp
 }
 
 // RedirectBinding generates a redirect binding that can be used to
-// send a logout request for the user identified by email to an IDP.
-func (slp *SingleLogOutProfile) RedirectBinding(email string) (string, error) {
+// send a logout request for the user identified by email to an IDP. Optionally
+// a relay state may be supplied, once the IDP responds it is returned in
+// SelfInitiatedLogout.RelayURL.
+func (slp *SingleLogOutProfile) RedirectBinding(email string, opts ...func() interface{}) (string, error) {
 	idpRedirectURL, err := getSingleLogoutBindingLocation(redirectBinding, slp.entity.IDPSSODescriptor.SingleLogoutService)
 	if err != nil {
 		return "", err
 	}
+	request, err := slp.newLogoutRequest(email, idpRedirectURL, opts...)
+	if err != nil {
+		return "", errors.Wrap(err, "creating logout request for redirect binding")
+	}
+	var encodedRequest bytes.Buffer
+	err = xml.NewEncoder(&encodedRequest).Encode(request)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding logout request")
+	}
+	return slp.serviceProvider.redirectBindingURL(idpRedirectURL, RequestQueryKey, &encodedRequest, "")
+}
+
+// PostBinding generates a form that can be used to send a logout request for the user
+// identified by email to an IDP using the HTTP-POST binding. This is used when the IDP does
+// not support the redirect binding for logout. The form should be written to the user's browser
+// where it submits itself to the IDP. Optionally a relay state may be supplied, once the IDP
+// responds it is returned in SelfInitiatedLogout.RelayURL.
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf Section 3.5
+func (slp *SingleLogOutProfile) PostBinding(email string, opts ...func() interface{}) (*PostForm, error) {
+	idpPostURL, err := getSingleLogoutBindingLocation(postBinding, slp.entity.IDPSSODescriptor.SingleLogoutService)
+	if err != nil {
+		return nil, err
+	}
+	request, err := slp.newLogoutRequest(email, idpPostURL, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating logout request for post binding")
+	}
+	encodedRequest, err := slp.serviceProvider.encodeSigned(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding logout request")
+	}
+	return postBindingForm(idpPostURL, RequestQueryKey, encodedRequest, ""), nil
+}
+
+// newLogoutRequest creates a logout request sent to destination and tracks it so that the
+// IDP response can be validated.
+func (slp *SingleLogOutProfile) newLogoutRequest(email, destination string, opts ...func() interface{}) (*LogoutRequest, error) {
+	var rs relayState
+	for _, opt := range opts {
+		switch t := opt().(type) {
+		case relayState:
+			rs = t
+		}
+	}
 	requestID, err := getUniqueID()
 	if err != nil {
-		return "", errors.Wrap(err, "getting id for redirect binding")
+		return nil, errors.Wrap(err, "getting id for logout request")
 	}
-	request := LogoutRequest{
+	issueInstant := slp.clock.Now().UTC()
+	request := &LogoutRequest{
 		XMLName: xml.Name{
 			Local: "samlp:LogoutRequest",
 		},
 		ID:           requestID,
 		SAMLP:        samlProtocalNamespace,
 		SAML:         samlNamespace,
-		IssueInstant: time.Now().UTC().Format(samlTimeFormat),
+		Destination:  destination,
+		IssueInstant: NewDateTime(issueInstant),
 		Version:      samlVersion,
 		Issuer: Issuer{
 			XMLName: xml.Name{
@@ -57,66 +155,141 @@ func (slp *SingleLogOutProfile) RedirectBinding(email string) (string, error) {
 			Value:  email,
 		},
 	}
-	var encodedRequest bytes.Buffer
-	err = xml.NewEncoder(&encodedRequest).Encode(request)
+	err = slp.requests.TrackRequest(&TrackedRequest{
+		ID:           requestID,
+		RelayState:   string(rs),
+		IssueInstant: issueInstant,
+	})
 	if err != nil {
-		return "", errors.Wrap(err, "encoding logout request")
+		return nil, errors.Wrap(err, "tracking logout request")
 	}
+	return request, nil
+}
 
-	logoutQueryVal, err := deflate(&encodedRequest)
-	if err != nil {
-		return "", errors.Wrap(err, "compressing logout request")
-	}
-	idpURL, err := url.Parse(idpRedirectURL)
-	if err != nil {
-		return "", errors.Wrap(err, "Unable to parse IDP URL")
-	}
-	query := idpURL.Query()
-	query.Set(RequestQueryKey, logoutQueryVal)
-	idpURL.RawQuery = query.Encode()
-	return idpURL.String(), nil
+// Callback handles a logout message from the IDP like HandlePostResponse, at the time of the
+// profile clock.
+func (slp *SingleLogOutProfile) Callback(r *http.Request) (*CallbackResponse, error) {
+	return slp.HandlePostResponse(r, slp.clock.Now())
 }
 
 // HandlePostResponse validates the IDP response to the logout request.  If successful, nil is returned
-// and the host should be logged out.
+// and the host should be logged out. Messages may be sent by the IDP using either the redirect or
+// post binding. Signed messages must be signed by the IDP with an allowed algorithm, logout requests
+// must be signed unless WithUnsignedLogoutRequests allows them.
 func (slp *SingleLogOutProfile) HandlePostResponse(r *http.Request, thisInstant time.Time) (*CallbackResponse, error) {
-	err := r.ParseForm()
+	err := parseBindingForm(r)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing logout handler")
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "parsing logout handler"))
+	}
+	encodedSaml := r.FormValue(ResponseQueryKey)
+	if encodedSaml == "" {
+		encodedSaml = r.FormValue(RequestQueryKey)
 	}
-	compressedSaml := r.FormValue(ResponseQueryKey)
-	if compressedSaml == "" {
-		compressedSaml = r.FormValue(RequestQueryKey)
+	if encodedSaml == "" {
+		return nil, newValidationError(ReasonMalformed, errors.New("invalid response"))
 	}
-	if compressedSaml == "" {
-		return nil, errors.New("invalid response")
+	binding := redirectBinding
+	if r.Method == http.MethodPost {
+		binding = postBinding
 	}
-	inflated, err := inflate(compressedSaml)
+	decoded, err := decodeBindingMessage(encodedSaml, binding)
 	if err != nil {
-		return nil, errors.Wrap(err, "handling logout response")
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "handling logout response"))
 	}
-	resp, err := createLogout(inflated)
+	signed, err := slp.validateSignatures(r, decoded, binding, thisInstant)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing logout response in callback")
+		return nil, newValidationError(ReasonBadSignature, errors.Wrap(err, "validating logout message signature"))
+	}
+	resp, err := createLogout(decoded)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "parsing logout response in callback"))
 	}
 	switch t := resp.(type) {
 	case *LogoutRequest:
-		return slp.handleLogoutRequest(t)
+		if !signed && !slp.allowUnsignedRequests {
+			return nil, newValidationError(ReasonBadSignature, errors.New("logout request is not signed"))
+		}
+		return slp.handleLogoutRequest(t, r.FormValue(RelayStateQueryKey), binding, thisInstant)
 	case *LogoutResponse:
-		return slp.handleLogoutResponse(t)
+		if !signed && !slp.allowUnsignedResponses {
+			return nil, newValidationError(ReasonBadSignature, errors.New("logout response is not signed"))
+		}
+		return slp.handleLogoutResponse(t, thisInstant)
 	}
 	return nil, errors.New("logout application error")
 }
 
-func (slp *SingleLogOutProfile) handleLogoutRequest(r *LogoutRequest) (*CallbackResponse, error) {
+// validateSignatures verifies the query signature of a message sent with the redirect binding, and
+// the XML signature of the message, if they are present. It reports whether the message was signed.
+func (slp *SingleLogOutProfile) validateSignatures(r *http.Request, decoded, binding string, thisInstant time.Time) (bool, error) {
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(decoded)
+	if err != nil {
+		return false, errors.Wrap(err, "parsing logout message")
+	}
+	querySigned := binding == redirectBinding && r.URL.Query().Get(SignatureQueryKey) != ""
+	xmlSigned := doc.Root() != nil && doc.Root().SelectElement("Signature") != nil
+	if !querySigned && !xmlSigned {
+		return false, nil
+	}
+	validator, err := slp.certificates.newSignatureValidator(slp.entity.IDPSSODescriptor.KeyDescriptors, thisInstant)
+	if err != nil {
+		return false, err
+	}
+	if querySigned {
+		err = slp.algorithms.verifyRedirectSignature(r.URL.RawQuery, validator.certs)
+		if err != nil {
+			return false, err
+		}
+	}
+	if !xmlSigned {
+		return true, nil
+	}
+	err = slp.algorithms.checkXMLSignatures(doc.Root())
+	if err != nil {
+		return false, err
+	}
+	_, err = validator.Validate(doc.Root())
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// handleLogoutRequest ends the user's sessions and builds the response to an IDP initiated
+// logout request. If possible the response is sent using requestBinding, the binding the IDP
+// used to send the request, otherwise whichever of the redirect or post bindings the IDP supports
+// is used. Any relay state sent by the IDP is returned unaltered.
+func (slp *SingleLogOutProfile) handleLogoutRequest(r *LogoutRequest, rs, requestBinding string, thisInstant time.Time) (*CallbackResponse, error) {
 	if slp.entity.EntityID != r.Issuer.Url {
-		return nil, errors.Errorf("issuer is not correct %q", r.Issuer.Url)
+		return nil, newMismatchError(ReasonWrongIssuer, slp.entity.EntityID, r.Issuer.Url)
+	}
+	if r.Destination != "" && slp.serviceProvider.SingleLogoutServiceURL != "" &&
+		r.Destination != slp.serviceProvider.SingleLogoutServiceURL {
+		return nil, newMismatchError(ReasonWrongDestination, slp.serviceProvider.SingleLogoutServiceURL, r.Destination)
+	}
+	ok, err := issueInstantValid(r.IssueInstant, thisInstant, slp.clockSkew)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "validating logout request"))
+	}
+	if !ok {
+		return nil, newValidationError(ReasonExpired, errors.New("logout request timestamp is not valid"))
+	}
+	if !r.NotOnOrAfter.IsZero() && !thisInstant.Before(r.NotOnOrAfter.Add(slp.clockSkew)) {
+		return nil, newValidationError(ReasonExpired, errors.Errorf("logout request expired at %s", r.NotOnOrAfter))
+	}
+	svc, err := getSingleLogoutService(slp.entity.IDPSSODescriptor.SingleLogoutService, requestBinding, redirectBinding, postBinding)
+	if err != nil {
+		return nil, err
 	}
 	requestID, err := getUniqueID()
 	if err != nil {
 		return nil, errors.Wrap(err, "handling logout response")
 	}
 	response := &LogoutResponse{
+		XMLName: xml.Name{
+			Local: "samlp:LogoutResponse",
+		},
 		Issuer: Issuer{
 			XMLName: xml.Name{
 				Local: "saml:Issuer",
@@ -124,55 +297,76 @@ func (slp *SingleLogOutProfile) handleLogoutRequest(r *LogoutRequest) (*Callback
 			Url: slp.serviceProvider.IssuerURI,
 		},
 		InResponseTo: r.ID,
-		IssueInstant: time.Now().UTC().Format(samlTimeFormat),
+		Destination:  svc.responseLocation(),
+		IssueInstant: NewDateTime(slp.clock.Now()),
 		Version:      samlVersion,
 		SAMLP:        samlProtocalNamespace,
+		SAML:         samlNamespace,
 		ID:           requestID,
-		Status: Status{
-			StatusCode: StatusCode{
-				Value: "urn:oasis:names:tc:SAML:2.0:status:Success",
-			},
-		},
+		Status:       terminateSessions(slp.terminator, r),
+	}
+	cb := &CallbackResponse{
+		ExternallyInitiatedLogout: &ExternallyInitiatedLogout{},
+	}
+	if svc.Binding == postBinding {
+		encoded, err := slp.serviceProvider.encodeSigned(response)
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding logout response")
+		}
+		cb.ExternallyInitiatedLogout.PostForm = postBindingForm(svc.responseLocation(), ResponseQueryKey, encoded, rs)
+		return cb, nil
 	}
 	var encoded bytes.Buffer
 	err = xml.NewEncoder(&encoded).Encode(response)
 	if err != nil {
 		return nil, errors.Wrap(err, "encoding logout response")
 	}
-	queryVal, err := deflate(&encoded)
+	cb.ExternallyInitiatedLogout.RedirectURL, err = slp.serviceProvider.redirectBindingURL(svc.responseLocation(), ResponseQueryKey, &encoded, rs)
 	if err != nil {
-		return nil, errors.Wrap(err, "deflate logout response")
+		return nil, errors.Wrap(err, "building logout response redirect")
+	}
+	return cb, nil
+}
+
+// handleLogoutResponse validates the IDP response to a logout request sent by this
+// service provider.
+func (slp *SingleLogOutProfile) handleLogoutResponse(r *LogoutResponse, thisInstant time.Time) (*CallbackResponse, error) {
+	if slp.entity.EntityID != r.Issuer.Url {
+		return nil, newMismatchError(ReasonWrongIssuer, slp.entity.EntityID, r.Issuer.Url)
+	}
+	if r.Destination != "" && slp.serviceProvider.SingleLogoutServiceURL != "" &&
+		r.Destination != slp.serviceProvider.SingleLogoutServiceURL {
+		return nil, newMismatchError(ReasonWrongDestination, slp.serviceProvider.SingleLogoutServiceURL, r.Destination)
 	}
-	idpURLRoot, err := getSingleLogoutBindingLocation(redirectBinding, slp.entity.IDPSSODescriptor.SingleLogoutService)
+	ok, err := issueInstantValid(r.IssueInstant, thisInstant, slp.clockSkew)
 	if err != nil {
-		return nil, err
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "validating logout response"))
+	}
+	if !ok {
+		return nil, newValidationError(ReasonExpired, errors.New("logout response timestamp is not valid"))
+	}
+	request, err := slp.requests.StopTrackingRequest(r.InResponseTo)
+	if err == ErrUnknownRequest {
+		return nil, &ValidationError{Reason: ReasonReplay, Actual: r.InResponseTo, Err: err}
 	}
-	idpURL, err := url.Parse(idpURLRoot)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing idp url")
+		return nil, errors.Wrapf(err, "logout response to %q", r.InResponseTo)
 	}
-	idpQuery := idpURL.Query()
-	idpQuery.Set(RequestQueryKey, queryVal)
-	idpURL.RawQuery = idpQuery.Encode()
-	cb := &CallbackResponse{
-		ExternallyInitiatedLogout: &ExternallyInitiatedLogout{
-			RedirectURL: idpURL.String(),
-		},
+	if thisInstant.After(request.IssueInstant.Add(maxRequestAge + slp.clockSkew)) {
+		return nil, newValidationError(ReasonExpired, errors.Errorf("logout request %q expired", r.InResponseTo))
 	}
-	return cb, nil
-}
-
-func (slp *SingleLogOutProfile) handleLogoutResponse(r *LogoutResponse) (*CallbackResponse, error) {
-	if slp.entity.EntityID != r.Issuer.Url {
-		return nil, errors.Errorf("issuer is not correct %q", r.Issuer.Url)
+	err = checkStatus(r.Status)
+	if err != nil {
+		return nil, err
 	}
-	// TODO: add more vaidation
-	if !isStatusSuccess(r.Status.StatusCode.Value) {
-		return nil, errors.Errorf("logout failed: %q", r.Status.StatusCode.Value)
+	relayURL := request.RelayState
+	if relayURL == "" {
+		relayURL = "/"
 	}
 	cb := &CallbackResponse{
 		SelfInitiatedLogout: &SelfInitiatedLogout{
-			RelayURL: "/",
+			RelayURL: relayURL,
+			Partial:  isPartialLogout(r.Status),
 		},
 	}
 	return cb, nil
@@ -187,13 +381,37 @@ func getSingleLogoutBindingLocation(desiredBinding string, services []SingleLogo
 	return "", ErrBindingNotSupported
 }
 
+// getSingleLogoutService returns the first service that supports one of desiredBindings,
+// bindings are listed in order of preference.
+func getSingleLogoutService(services []SingleLogoutService, desiredBindings ...string) (*SingleLogoutService, error) {
+	for _, binding := range desiredBindings {
+		for i := range services {
+			if services[i].Binding == binding {
+				return &services[i], nil
+			}
+		}
+	}
+	return nil, ErrBindingNotSupported
+}
+
+// responseLocation is where logout responses are sent.
+func (svc *SingleLogoutService) responseLocation() string {
+	if svc.ResponseLocation != "" {
+		return svc.ResponseLocation
+	}
+	return svc.Location
+}
+
 // checks issuer, if issuer and if we have a login request or response to
 // determine who initiated the logout request
 func createLogout(samlResponse string) (interface{}, error) {
 	doc := etree.NewDocument()
 	err := doc.ReadFromString(samlResponse)
 	if err != nil {
-		return false, errors.Wrap(err, "parsing logout saml")
+		return nil, errors.Wrap(err, "parsing logout saml")
+	}
+	if doc.Root() == nil {
+		return nil, errors.New("logout message is empty")
 	}
 	tag := doc.Root().Tag
 	switch tag {
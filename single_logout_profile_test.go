@@ -1,10 +1,19 @@
 package saml
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/murphybytes/saml/generated"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -28,6 +37,21 @@ func TestLogoutRedirectBinding(t *testing.T) {
 
 }
 
+func TestSignedLogoutRedirectBinding(t *testing.T) {
+	buff, err := generated.Asset("test_data/metadata.xml")
+	require.Nil(t, err)
+	var entity EntityDescriptor
+	err = xml.Unmarshal(buff, &entity)
+	require.Nil(t, err)
+	profile := NewSingleLogOutProfile(getRSAServiceProvider(t), &entity)
+	binding, err := profile.RedirectBinding("someone@acme.com")
+	require.Nil(t, err)
+	parsed, err := url.Parse(binding)
+	require.Nil(t, err)
+	assert.Equal(t, SignatureMethodRSASHA256, parsed.Query().Get(SigAlgQueryKey))
+	assert.NotEqual(t, "", parsed.Query().Get(SignatureQueryKey))
+}
+
 var logoutResponse = `
 <samlp:LogoutResponse InResponseTo="saTmz9HA4d"
                       Version="2.0"
@@ -67,3 +91,571 @@ func TestGetIssuerFromLogout(t *testing.T) {
 	_, err = createLogout("<garbage")
 	assert.NotNil(t, err)
 }
+
+func getLogoutEntity(services ...SingleLogoutService) *EntityDescriptor {
+	return &EntityDescriptor{
+		EntityID: "https://app.onelogin.com/saml/metadata/649458",
+		IDPSSODescriptor: IDPSSODescriptor{
+			SingleLogoutService: services,
+		},
+	}
+}
+
+// getLogoutRequestXML returns logoutRequest issued at issueInstant with additional attributes.
+func getLogoutRequestXML(issueInstant time.Time, attributes string) string {
+	request := strings.Replace(logoutRequest, "2017-06-11T20:29:27Z", issueInstant.UTC().Format(time.RFC3339), 1)
+	return strings.Replace(request, `Version="2.0"`, `Version="2.0" `+attributes, 1)
+}
+
+func getIDPLogoutRequest(t *testing.T, relayState string) *http.Request {
+	return getIDPLogoutRequestMessage(t, getLogoutRequestXML(time.Now(), ""), relayState)
+}
+
+func getIDPLogoutRequestMessage(t *testing.T, message, relayState string) *http.Request {
+	deflated, err := deflate(bytes.NewBufferString(message))
+	require.Nil(t, err)
+	query := url.Values{}
+	query.Set(RequestQueryKey, deflated)
+	query.Set(RelayStateQueryKey, relayState)
+	return httptest.NewRequest("GET", "/logout/callback?"+query.Encode(), nil)
+}
+
+func getIDPPostLogoutRequest(t *testing.T, relayState string) *http.Request {
+	form := url.Values{}
+	form.Set(RequestQueryKey, base64.StdEncoding.EncodeToString([]byte(getLogoutRequestXML(time.Now(), ""))))
+	form.Set(RelayStateQueryKey, relayState)
+	r := httptest.NewRequest("POST", "/logout/callback", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestIDPInitiatedLogoutRedirect(t *testing.T) {
+	entity := getLogoutEntity(
+		SingleLogoutService{
+			Binding:  postBinding,
+			Location: "https://idp.com/slo/post",
+		},
+		SingleLogoutService{
+			Binding:          redirectBinding,
+			Location:         "https://idp.com/slo",
+			ResponseLocation: "https://idp.com/slo/response",
+		},
+	)
+	profile := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, entity, WithUnsignedLogoutRequests(true))
+	cb, err := profile.HandlePostResponse(getIDPLogoutRequest(t, "xyz"), time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, cb.ExternallyInitiatedLogout)
+	assert.Nil(t, cb.ExternallyInitiatedLogout.PostForm)
+
+	redirect, err := url.Parse(cb.ExternallyInitiatedLogout.RedirectURL)
+	require.Nil(t, err)
+	assert.Equal(t, "/slo/response", redirect.Path)
+	assert.Equal(t, "xyz", redirect.Query().Get(RelayStateQueryKey))
+	assert.Equal(t, "", redirect.Query().Get(RequestQueryKey))
+	inflated, err := inflate(redirect.Query().Get(ResponseQueryKey))
+	require.Nil(t, err)
+	resp, err := createLogout(inflated)
+	require.Nil(t, err)
+	require.IsType(t, &LogoutResponse{}, resp)
+	assert.Equal(t, "saTmz9HA4d", resp.(*LogoutResponse).InResponseTo)
+	assert.Equal(t, "https://idp.com/slo/response", resp.(*LogoutResponse).Destination)
+}
+
+func TestIDPInitiatedLogoutPost(t *testing.T) {
+	entity := getLogoutEntity(
+		SingleLogoutService{
+			Binding:  postBinding,
+			Location: "https://idp.com/slo/post",
+		},
+	)
+	profile := NewSingleLogOutProfile(getRSAServiceProvider(t), entity, WithUnsignedLogoutRequests(true))
+	cb, err := profile.HandlePostResponse(getIDPLogoutRequest(t, "xyz"), time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, cb.ExternallyInitiatedLogout)
+	form := cb.ExternallyInitiatedLogout.PostForm
+	require.NotNil(t, form)
+	assert.Equal(t, "", cb.ExternallyInitiatedLogout.RedirectURL)
+	assert.Equal(t, "https://idp.com/slo/post", form.URL)
+	assert.Equal(t, "xyz", form.Values.Get(RelayStateQueryKey))
+
+	decoded, err := base64.StdEncoding.DecodeString(form.Values.Get(ResponseQueryKey))
+	require.Nil(t, err)
+	resp, err := createLogout(string(decoded))
+	require.Nil(t, err)
+	require.IsType(t, &LogoutResponse{}, resp)
+	assert.Equal(t, "saTmz9HA4d", resp.(*LogoutResponse).InResponseTo)
+	assert.Contains(t, string(decoded), "Signature")
+}
+
+func TestIDPInitiatedLogoutNoBinding(t *testing.T) {
+	entity := getLogoutEntity(
+		SingleLogoutService{
+			Binding:  soapBinding,
+			Location: "https://idp.com/slo/soap",
+		},
+	)
+	profile := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, entity, WithUnsignedLogoutRequests(true))
+	_, err := profile.HandlePostResponse(getIDPLogoutRequest(t, ""), time.Now())
+	assert.Equal(t, ErrBindingNotSupported, err)
+}
+
+func TestIDPInitiatedLogoutUnsigned(t *testing.T) {
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  redirectBinding,
+		Location: "https://idp.com/slo",
+	})
+	terminated := false
+	terminator := SessionTerminatorFunc(func(nameID NameID, sessionIndexes []string, reason string) error {
+		terminated = true
+		return nil
+	})
+	profile := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, entity, WithSessionTerminator(terminator))
+	_, err := profile.HandlePostResponse(getIDPLogoutRequest(t, ""), time.Now())
+	assert.True(t, errors.Is(err, ErrBadSignature))
+	_, err = profile.HandlePostResponse(getIDPPostLogoutRequest(t, ""), time.Now())
+	assert.True(t, errors.Is(err, ErrBadSignature))
+	assert.False(t, terminated)
+}
+
+func TestIDPInitiatedLogoutRequestInvalid(t *testing.T) {
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  redirectBinding,
+		Location: "https://idp.com/slo",
+	})
+	sp := &ServiceProvider{
+		IssuerURI:              "uri:myserviceprovider",
+		SingleLogoutServiceURL: "https://sp.com/logout/callback",
+	}
+	profile := NewSingleLogOutProfile(sp, entity, WithUnsignedLogoutRequests(true), WithClockSkew(time.Second))
+	now := time.Now()
+	var tests = []struct {
+		name    string
+		request string
+		err     error
+	}{
+		{"valid", getLogoutRequestXML(now, `Destination="https://sp.com/logout/callback"`), nil},
+		{"wrong destination", getLogoutRequestXML(now, `Destination="https://evil.com/logout"`), ErrWrongDestination},
+		{"old", getLogoutRequestXML(now.Add(-time.Hour), ""), ErrExpired},
+		{"future", getLogoutRequestXML(now.Add(time.Hour), ""), ErrExpired},
+		{"not on or after", getLogoutRequestXML(now, fmt.Sprintf("NotOnOrAfter=%q", now.Add(-time.Minute).UTC().Format(time.RFC3339))), ErrExpired},
+		{"missing issue instant", strings.Replace(logoutRequest, `IssueInstant="2017-06-11T20:29:27Z"`, "", 1), ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := profile.HandlePostResponse(getIDPLogoutRequestMessage(t, tt.request, ""), now)
+			if tt.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+		})
+	}
+}
+
+func TestLogoutMessageEmpty(t *testing.T) {
+	profile := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, getLogoutEntity())
+	for _, message := range []string{"", "  ", "\n\t"} {
+		deflated, err := deflate(bytes.NewBufferString(message))
+		require.Nil(t, err)
+		redirect := httptest.NewRequest(http.MethodGet, "/logout/callback?"+url.Values{ResponseQueryKey: {deflated}}.Encode(), nil)
+		_, err = profile.HandlePostResponse(redirect, time.Now())
+		assert.True(t, errors.Is(err, ErrMalformed), "redirect %q: %v", message, err)
+
+		for _, encoded := range []string{deflated, base64.StdEncoding.EncodeToString([]byte(message))} {
+			post := httptest.NewRequest(http.MethodPost, "/logout/callback", strings.NewReader(url.Values{ResponseQueryKey: {encoded}}.Encode()))
+			post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			_, err = profile.HandlePostResponse(post, time.Now())
+			assert.True(t, errors.Is(err, ErrMalformed), "post %q: %v", message, err)
+		}
+	}
+}
+
+func getIDPLogoutResponse(t *testing.T, inResponseTo, destination string, issueInstant time.Time) *http.Request {
+	response := fmt.Sprintf(`<samlp:LogoutResponse xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_96ba4530"
+	InResponseTo=%q Destination=%q IssueInstant=%q Version="2.0">
+	<saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">https://app.onelogin.com/saml/metadata/649458</saml:Issuer>
+	<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+</samlp:LogoutResponse>`, inResponseTo, destination, issueInstant.UTC().Format(time.RFC3339))
+	deflated, err := deflate(bytes.NewBufferString(response))
+	require.Nil(t, err)
+	return httptest.NewRequest("GET", "/logout/callback?"+url.Values{ResponseQueryKey: {deflated}}.Encode(), nil)
+}
+
+func getSPLogoutRequestID(t *testing.T, binding string) string {
+	parsed, err := url.Parse(binding)
+	require.Nil(t, err)
+	inflated, err := inflate(parsed.Query().Get(RequestQueryKey))
+	require.Nil(t, err)
+	req, err := createLogout(inflated)
+	require.Nil(t, err)
+	require.IsType(t, &LogoutRequest{}, req)
+	return req.(*LogoutRequest).ID
+}
+
+func TestSPInitiatedLogoutResponse(t *testing.T) {
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  redirectBinding,
+		Location: "https://idp.com/slo",
+	})
+	sp := &ServiceProvider{
+		IssuerURI:              "uri:myserviceprovider",
+		SingleLogoutServiceURL: "https://sp.com/logout/callback",
+	}
+	tracker := NewMemoryRequestTracker(time.Hour)
+	requester := NewSingleLogOutProfile(sp, entity, WithRequestTracker(tracker))
+	binding, err := requester.RedirectBinding("john@kolide.co", RelayState("/goodbye"))
+	require.Nil(t, err)
+	requestID := getSPLogoutRequestID(t, binding)
+
+	// response is handled by a different profile sharing the tracker
+	responder := NewSingleLogOutProfile(sp, entity, WithRequestTracker(tracker), WithUnsignedLogoutResponses(true))
+	cb, err := responder.HandlePostResponse(getIDPLogoutResponse(t, requestID, sp.SingleLogoutServiceURL, time.Now()), time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, cb.SelfInitiatedLogout)
+	assert.Equal(t, "/goodbye", cb.SelfInitiatedLogout.RelayURL)
+	assert.False(t, cb.SelfInitiatedLogout.Partial)
+
+	// replayed response is rejected
+	_, err = responder.HandlePostResponse(getIDPLogoutResponse(t, requestID, sp.SingleLogoutServiceURL, time.Now()), time.Now())
+	assert.NotNil(t, err)
+}
+
+func TestSPInitiatedLogoutResponseInvalid(t *testing.T) {
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  redirectBinding,
+		Location: "https://idp.com/slo",
+	})
+	sp := &ServiceProvider{
+		IssuerURI:              "uri:myserviceprovider",
+		SingleLogoutServiceURL: "https://sp.com/logout/callback",
+	}
+	profile := NewSingleLogOutProfile(sp, entity, WithClockSkew(time.Second), WithUnsignedLogoutResponses(true))
+	binding, err := profile.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+	requestID := getSPLogoutRequestID(t, binding)
+	now := time.Now()
+
+	_, err = profile.HandlePostResponse(getIDPLogoutResponse(t, "unknown", sp.SingleLogoutServiceURL, now), now)
+	assert.True(t, errors.Is(err, ErrReplay))
+	assert.True(t, errors.Is(err, ErrUnknownRequest))
+	_, err = profile.HandlePostResponse(getIDPLogoutResponse(t, requestID, "https://evil.com/logout", now), now)
+	assert.True(t, errors.Is(err, ErrWrongDestination))
+	_, err = profile.HandlePostResponse(getIDPLogoutResponse(t, requestID, sp.SingleLogoutServiceURL, now.Add(-time.Hour)), now)
+	assert.True(t, errors.Is(err, ErrExpired))
+	_, err = profile.HandlePostResponse(getIDPLogoutResponse(t, requestID, sp.SingleLogoutServiceURL, now.Add(time.Hour)), now)
+	assert.True(t, errors.Is(err, ErrExpired))
+
+	// the request is still outstanding after the invalid responses
+	cb, err := profile.HandlePostResponse(getIDPLogoutResponse(t, requestID, sp.SingleLogoutServiceURL, now), now)
+	require.Nil(t, err)
+	assert.Equal(t, "/", cb.SelfInitiatedLogout.RelayURL)
+}
+
+func TestSPInitiatedPartialLogout(t *testing.T) {
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  redirectBinding,
+		Location: "https://idp.com/slo",
+	})
+	sp := &ServiceProvider{IssuerURI: "uri:myserviceprovider"}
+	profile := NewSingleLogOutProfile(sp, entity, WithUnsignedLogoutResponses(true))
+	binding, err := profile.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+	response := fmt.Sprintf(`<samlp:LogoutResponse xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_96ba4530"
+	InResponseTo=%q IssueInstant=%q Version="2.0">
+	<saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">https://app.onelogin.com/saml/metadata/649458</saml:Issuer>
+	<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success">
+		<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:PartialLogout"/>
+	</samlp:StatusCode></samlp:Status>
+</samlp:LogoutResponse>`, getSPLogoutRequestID(t, binding), time.Now().UTC().Format(time.RFC3339))
+	deflated, err := deflate(bytes.NewBufferString(response))
+	require.Nil(t, err)
+	r := httptest.NewRequest("GET", "/logout/callback?"+url.Values{ResponseQueryKey: {deflated}}.Encode(), nil)
+	cb, err := profile.HandlePostResponse(r, time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, cb.SelfInitiatedLogout)
+	assert.True(t, cb.SelfInitiatedLogout.Partial)
+}
+
+func TestSPInitiatedLogoutResponseSigned(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  redirectBinding,
+		Location: "https://idp.com/slo",
+	})
+	entity.IDPSSODescriptor.KeyDescriptors = []KeyDescriptor{getKeyDescriptor(idp.SigningCertificate)}
+	sp := &ServiceProvider{IssuerURI: "uri:myserviceprovider"}
+	profile := NewSingleLogOutProfile(sp, entity)
+	binding, err := profile.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+	requestID := getSPLogoutRequestID(t, binding)
+	response := LogoutResponse{
+		XMLName:      xml.Name{Local: "samlp:LogoutResponse"},
+		ID:           "_96ba4530",
+		InResponseTo: requestID,
+		IssueInstant: NewDateTime(time.Now()),
+		Version:      samlVersion,
+		SAMLP:        samlProtocalNamespace,
+		SAML:         samlNamespace,
+		Issuer:       Issuer{XMLName: xml.Name{Local: "saml:Issuer"}, Url: entity.EntityID},
+		Status:       newStatus(Success, PartialLogout),
+	}
+	var encoded bytes.Buffer
+	require.Nil(t, xml.NewEncoder(&encoded).Encode(response))
+
+	// a forged response naming the outstanding request is rejected
+	_, err = profile.HandlePostResponse(getIDPLogoutResponse(t, requestID, "", time.Now()), time.Now())
+	assert.True(t, errors.Is(err, ErrBadSignature))
+
+	signed, err := idp.encodeSigned(response)
+	require.Nil(t, err)
+	tampered := strings.Replace(signed.String(), "PartialLogout", "Success", 1)
+	form := url.Values{}
+	form.Set(ResponseQueryKey, base64.StdEncoding.EncodeToString([]byte(tampered)))
+	r := httptest.NewRequest("POST", "/logout/callback", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = profile.HandlePostResponse(r, time.Now())
+	assert.True(t, errors.Is(err, ErrBadSignature))
+
+	// the request is still outstanding, a response signed in the redirect query is accepted
+	location, err := idp.redirectBindingURL("/logout/callback", ResponseQueryKey, &encoded, "")
+	require.Nil(t, err)
+	cb, err := profile.HandlePostResponse(httptest.NewRequest("GET", location, nil), time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, cb.SelfInitiatedLogout)
+	assert.True(t, cb.SelfInitiatedLogout.Partial)
+
+	// a response signed in the XML is accepted
+	binding, err = profile.RedirectBinding("john@kolide.co")
+	require.Nil(t, err)
+	response.InResponseTo = getSPLogoutRequestID(t, binding)
+	signed, err = idp.encodeSigned(response)
+	require.Nil(t, err)
+	form.Set(ResponseQueryKey, base64.StdEncoding.EncodeToString(signed.Bytes()))
+	r = httptest.NewRequest("POST", "/logout/callback", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	cb, err = profile.HandlePostResponse(r, time.Now())
+	require.Nil(t, err)
+	assert.NotNil(t, cb.SelfInitiatedLogout)
+}
+
+func getIDPLogoutResponseStatus(t *testing.T, cb *CallbackResponse) *LogoutResponse {
+	require.NotNil(t, cb.ExternallyInitiatedLogout)
+	redirect, err := url.Parse(cb.ExternallyInitiatedLogout.RedirectURL)
+	require.Nil(t, err)
+	inflated, err := inflate(redirect.Query().Get(ResponseQueryKey))
+	require.Nil(t, err)
+	resp, err := createLogout(inflated)
+	require.Nil(t, err)
+	require.IsType(t, &LogoutResponse{}, resp)
+	return resp.(*LogoutResponse)
+}
+
+func TestIDPInitiatedLogoutSessionTerminator(t *testing.T) {
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  redirectBinding,
+		Location: "https://idp.com/slo",
+	})
+	sp := &ServiceProvider{IssuerURI: "uri:myserviceprovider"}
+	var tests = []struct {
+		err     error
+		status  string
+		subCode string
+	}{
+		{nil, "urn:oasis:names:tc:SAML:2.0:status:Success", ""},
+		{ErrPartialLogout, "urn:oasis:names:tc:SAML:2.0:status:Success", "urn:oasis:names:tc:SAML:2.0:status:PartialLogout"},
+		{fmt.Errorf("ending sessions: %w", ErrPartialLogout), "urn:oasis:names:tc:SAML:2.0:status:Success", "urn:oasis:names:tc:SAML:2.0:status:PartialLogout"},
+		{errors.New("session store unavailable"), "urn:oasis:names:tc:SAML:2.0:status:Responder", ""},
+	}
+	for _, tt := range tests {
+		var terminated string
+		terminator := SessionTerminatorFunc(func(nameID NameID, sessionIndexes []string, reason string) error {
+			terminated = nameID.Value
+			return tt.err
+		})
+		profile := NewSingleLogOutProfile(sp, entity, WithSessionTerminator(terminator), WithUnsignedLogoutRequests(true))
+		cb, err := profile.HandlePostResponse(getIDPLogoutRequest(t, ""), time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, "john@kolide.co", terminated)
+		resp := getIDPLogoutResponseStatus(t, cb)
+		assert.Equal(t, tt.status, resp.Status.StatusCode.Value)
+		if tt.subCode == "" {
+			assert.Nil(t, resp.Status.StatusCode.StatusCode)
+			continue
+		}
+		require.NotNil(t, resp.Status.StatusCode.StatusCode)
+		assert.Equal(t, tt.subCode, resp.Status.StatusCode.StatusCode.Value)
+	}
+}
+
+func TestIDPInitiatedLogoutWithoutSessionTerminator(t *testing.T) {
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  redirectBinding,
+		Location: "https://idp.com/slo",
+	})
+	profile := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, entity, WithUnsignedLogoutRequests(true))
+	cb, err := profile.HandlePostResponse(getIDPLogoutRequest(t, ""), time.Now())
+	require.Nil(t, err)
+	resp := getIDPLogoutResponseStatus(t, cb)
+	assert.Equal(t, "urn:oasis:names:tc:SAML:2.0:status:Responder", resp.Status.StatusCode.Value)
+}
+
+func TestLogoutRequestSessionIndexes(t *testing.T) {
+	request := `<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"
+	xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="abc" Version="2.0" Reason="urn:oasis:names:tc:SAML:2.0:logout:user">
+	<saml:Issuer>https://app.onelogin.com/saml/metadata/649458</saml:Issuer>
+	<saml:NameID>john@kolide.co</saml:NameID>
+	<samlp:SessionIndex>one</samlp:SessionIndex>
+	<samlp:SessionIndex>two</samlp:SessionIndex>
+</samlp:LogoutRequest>`
+	lr, err := createLogout(request)
+	require.Nil(t, err)
+	require.IsType(t, &LogoutRequest{}, lr)
+	assert.Equal(t, []string{"one", "two"}, lr.(*LogoutRequest).SessionIndex)
+	assert.Equal(t, "urn:oasis:names:tc:SAML:2.0:logout:user", lr.(*LogoutRequest).Reason)
+}
+
+func TestIDPInitiatedLogoutPostRequest(t *testing.T) {
+	entity := getLogoutEntity(
+		SingleLogoutService{
+			Binding:  redirectBinding,
+			Location: "https://idp.com/slo",
+		},
+		SingleLogoutService{
+			Binding:  postBinding,
+			Location: "https://idp.com/slo/post",
+		},
+	)
+	profile := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, entity, WithUnsignedLogoutRequests(true))
+	// response uses the same binding as the request
+	cb, err := profile.HandlePostResponse(getIDPPostLogoutRequest(t, "xyz"), time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, cb.ExternallyInitiatedLogout)
+	form := cb.ExternallyInitiatedLogout.PostForm
+	require.NotNil(t, form)
+	assert.Equal(t, "https://idp.com/slo/post", form.URL)
+	assert.Equal(t, "xyz", form.Values.Get(RelayStateQueryKey))
+}
+
+func TestLogoutPostBinding(t *testing.T) {
+	entity := getLogoutEntity(SingleLogoutService{
+		Binding:  postBinding,
+		Location: "https://idp.com/slo/post",
+	})
+	sp := getRSAServiceProvider(t)
+	profile := NewSingleLogOutProfile(sp, entity, WithUnsignedLogoutResponses(true))
+	_, err := profile.RedirectBinding("john@kolide.co")
+	assert.Equal(t, ErrBindingNotSupported, err)
+
+	form, err := profile.PostBinding("john@kolide.co", RelayState("/goodbye"))
+	require.Nil(t, err)
+	assert.Equal(t, "https://idp.com/slo/post", form.URL)
+	decoded, err := base64.StdEncoding.DecodeString(form.Values.Get(RequestQueryKey))
+	require.Nil(t, err)
+	req, err := createLogout(string(decoded))
+	require.Nil(t, err)
+	require.IsType(t, &LogoutRequest{}, req)
+	assert.Equal(t, "https://idp.com/slo/post", req.(*LogoutRequest).Destination)
+	assert.Contains(t, string(decoded), "Signature")
+
+	// IDP posts response
+	response := fmt.Sprintf(`<samlp:LogoutResponse xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_96ba4530"
+	InResponseTo=%q IssueInstant=%q Version="2.0">
+	<saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">https://app.onelogin.com/saml/metadata/649458</saml:Issuer>
+	<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+</samlp:LogoutResponse>`, req.(*LogoutRequest).ID, time.Now().UTC().Format(time.RFC3339))
+	values := url.Values{}
+	values.Set(ResponseQueryKey, base64.StdEncoding.EncodeToString([]byte(response)))
+	r := httptest.NewRequest("POST", "/logout/callback", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	cb, err := profile.HandlePostResponse(r, time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, cb.SelfInitiatedLogout)
+	assert.Equal(t, "/goodbye", cb.SelfInitiatedLogout.RelayURL)
+}
+
+func TestDecodeBindingMessage(t *testing.T) {
+	deflated, err := deflate(bytes.NewBufferString(logoutRequest))
+	require.Nil(t, err)
+	decoded, err := decodeBindingMessage(deflated, redirectBinding)
+	require.Nil(t, err)
+	assert.Equal(t, logoutRequest, decoded)
+	// some IDPs deflate posted messages
+	decoded, err = decodeBindingMessage(deflated, postBinding)
+	require.Nil(t, err)
+	assert.Equal(t, logoutRequest, decoded)
+
+	decoded, err = decodeBindingMessage(base64.StdEncoding.EncodeToString([]byte(logoutRequest)), postBinding)
+	require.Nil(t, err)
+	assert.Equal(t, logoutRequest, decoded)
+}
+
+func TestIDPInitiatedLogoutSignedRedirect(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	entity := getLogoutEntity(
+		SingleLogoutService{
+			Binding:  redirectBinding,
+			Location: "https://idp.com/slo",
+		},
+	)
+	entity.IDPSSODescriptor.KeyDescriptors = []KeyDescriptor{getKeyDescriptor(idp.SigningCertificate)}
+	location, err := idp.redirectBindingURL("/logout/callback", RequestQueryKey, bytes.NewBufferString(getLogoutRequestXML(time.Now(), "")), "xyz")
+	require.Nil(t, err)
+
+	profile := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, entity)
+	cb, err := profile.HandlePostResponse(httptest.NewRequest("GET", location, nil), time.Now())
+	require.Nil(t, err)
+	assert.NotNil(t, cb.ExternallyInitiatedLogout)
+
+	tampered := strings.Replace(location, "RelayState=xyz", "RelayState=abc", 1)
+	_, err = profile.HandlePostResponse(httptest.NewRequest("GET", tampered, nil), time.Now())
+	assert.True(t, errors.Is(err, ErrBadSignature))
+
+	// a second RelayState would be used instead of the one that was signed
+	for _, duplicated := range []string{location + "&RelayState=abc", strings.Replace(location, "?", "?RelayState=abc&", 1)} {
+		_, err = profile.HandlePostResponse(httptest.NewRequest("GET", duplicated, nil), time.Now())
+		assert.True(t, errors.Is(err, ErrMalformed), "got %v", err)
+	}
+
+	idp.SignatureMethod = SignatureMethodRSASHA1
+	location, err = idp.redirectBindingURL("/logout/callback", RequestQueryKey, bytes.NewBufferString(getLogoutRequestXML(time.Now(), "")), "xyz")
+	require.Nil(t, err)
+	_, err = profile.HandlePostResponse(httptest.NewRequest("GET", location, nil), time.Now())
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
+}
+
+func TestIDPInitiatedLogoutSignedPost(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	entity := getLogoutEntity(
+		SingleLogoutService{
+			Binding:  postBinding,
+			Location: "https://idp.com/slo",
+		},
+	)
+	entity.IDPSSODescriptor.KeyDescriptors = []KeyDescriptor{getKeyDescriptor(idp.SigningCertificate)}
+	var request LogoutRequest
+	err := xml.Unmarshal([]byte(getLogoutRequestXML(time.Now(), "")), &request)
+	require.Nil(t, err)
+	request.XMLName.Local = "samlp:LogoutRequest"
+	request.SAMLP = samlProtocalNamespace
+	request.SAML = samlNamespace
+	request.Issuer.XMLName.Local = "saml:Issuer"
+	request.NameID.XMLName.Local = "saml:NameID"
+	encoded, err := idp.encodeSigned(request)
+	require.Nil(t, err)
+
+	profile := NewSingleLogOutProfile(&ServiceProvider{IssuerURI: "uri:myserviceprovider"}, entity)
+	post := func(message string) *http.Request {
+		form := url.Values{}
+		form.Set(RequestQueryKey, base64.StdEncoding.EncodeToString([]byte(message)))
+		r := httptest.NewRequest("POST", "/logout/callback", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	cb, err := profile.HandlePostResponse(post(encoded.String()), time.Now())
+	require.Nil(t, err)
+	assert.NotNil(t, cb.ExternallyInitiatedLogout)
+
+	tampered := strings.Replace(encoded.String(), "saTmz9HA4d", "saTmz9HA4e", -1)
+	_, err = profile.HandlePostResponse(post(tampered), time.Now())
+	assert.True(t, errors.Is(err, ErrBadSignature))
+}
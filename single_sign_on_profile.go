@@ -2,10 +2,12 @@ package saml
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/xml"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/beevik/etree"
@@ -30,20 +32,133 @@ type ServiceProvider struct {
 	// AssertionConsumerServiceURL is the URL of the service provider handler for
 	// the AuthnResponse sent by the IDP after sign on.
 	AssertionConsumerServiceURL string
+	// SingleLogoutServiceURL is the URL of the service provider handler for logout
+	// requests and responses sent by the IDP.
+	SingleLogoutServiceURL string
+	// SigningKey is the private key used to sign messages sent to the IDP, such as
+	// logout requests and responses. RSA and ECDSA keys are supported. If nil, outbound
+	// messages are not signed. Only the crypto.Signer interface is used so the key may be
+	// held in an HSM or KMS, ParsePrivateKeyPEM may be used for keys loaded from PEM files.
+	SigningKey crypto.Signer
+	// SigningCertificate is the certificate corresponding to SigningKey. If present
+	// it is included in the KeyInfo of enveloped signatures.
+	SigningCertificate *x509.Certificate
+	// SignatureMethod is the algorithm used to sign messages, for example
+	// SignatureMethodRSASHA256. If empty SHA-256 is used with the algorithm
+	// matching SigningKey.
+	SignatureMethod string
+	// DecryptionKey is the RSA private key used to decrypt assertions that the IDP encrypts
+	// for this service provider. If nil, encrypted assertions are rejected. Only the
+	// crypto.Decrypter interface is used so the key may be held in an HSM or KMS.
+	DecryptionKey crypto.Decrypter
+	// EncryptionCertificate is the certificate corresponding to DecryptionKey. If present it
+	// is published in metadata so that the IDP can encrypt assertions.
+	EncryptionCertificate *x509.Certificate
+}
+
+// SignaturePolicy determines which parts of an AuthnResponse must be signed by the IDP.
+type SignaturePolicy int
+
+const (
+	// WantAssertionsSigned requires each assertion to carry its own valid signature, a
+	// signature on the enclosing response is not enough. This is the default.
+	WantAssertionsSigned SignaturePolicy = 1 << iota
+	// WantResponseSigned requires the response to be signed, assertions are trusted
+	// because they are covered by the response signature.
+	WantResponseSigned
+	// WantBothSigned requires the response and each assertion to be signed.
+	WantBothSigned = WantAssertionsSigned | WantResponseSigned
+)
+
+type signaturePolicy SignaturePolicy
+
+// WithSignaturePolicy is an optional parameter to NewSingleSignOnProfile that changes the
+// signatures required on AuthnResponses. The policy is also advertised in SP metadata.
+func WithSignaturePolicy(policy SignaturePolicy) func() interface{} {
+	return func() interface{} {
+		return signaturePolicy(policy)
+	}
+}
+
+type idpEntityID string
+
+// WithIDPEntityID is an optional parameter to NewSingleSignOnProfile that supplies the entity ID
+// of the IDP. The Issuer of responses and assertions must match it.
+func WithIDPEntityID(entityID string) func() interface{} {
+	return func() interface{} {
+		return idpEntityID(entityID)
+	}
 }
 
 // SingleSignOnProfile supplies single sign on functionality
 type SingleSignOnProfile struct {
-	serviceProvder *ServiceProvider
-	idpDescription *IDPSSODescriptor
+	serviceProvder  *ServiceProvider
+	idpDescription  *IDPSSODescriptor
+	idpEntityID     string
+	clockSkew       time.Duration
+	signaturePolicy SignaturePolicy
+	algorithms      allowedAlgorithms
+	certificates    certificatePolicy
+	allowCBC        bool
+	clock           Clock
+	assertions      RequestTracker
 }
 
-// NewSingleSignOnProfile creates an SSOProvider
-func NewSingleSignOnProfile(spDescription *ServiceProvider, idpDescription *IDPSSODescriptor) *SingleSignOnProfile {
-	return &SingleSignOnProfile{
-		serviceProvder: spDescription,
-		idpDescription: idpDescription,
+// NewSingleSignOnProfile creates an SSOProvider. WithIDPEntityID should be supplied so that
+// responses issued by other entities are rejected, without it the Issuer of the assertion must
+// only match the Issuer of the response.
+//
+// Each assertion is only accepted once, a response containing an assertion that was already
+// accepted fails with ReasonReplay. The IDs of accepted assertions are recorded in memory by the
+// profile. If responses are handled by different profiles, for example one per host,
+// WithRequestTracker must be used to supply a tracker shared between them. The tracker should
+// not also be used for logout requests.
+//
+// Optionally WithClockSkew may be supplied to change the tolerance for differences between the
+// IDP clock and ours, WithSignaturePolicy to change which signatures are required and
+// WithAllowedAlgorithms to change the accepted signature algorithms. WithCertificateValidity and
+// WithCertificateExpiryWarning control how the dates of IDP certificates are handled,
+// WithCBCDecryption accepts assertions encrypted with AES-CBC and WithClock supplies the clock
+// used by RedirectBinding and Callback.
+func NewSingleSignOnProfile(spDescription *ServiceProvider, idpDescription *IDPSSODescriptor, opts ...func() interface{}) *SingleSignOnProfile {
+	sp := &SingleSignOnProfile{
+		serviceProvder:  spDescription,
+		idpDescription:  idpDescription,
+		clockSkew:       DefaultClockSkew,
+		signaturePolicy: WantAssertionsSigned,
+		algorithms:      defaultAllowedAlgorithms(),
+		clock:           realClock{},
+	}
+	for _, opt := range opts {
+		switch t := opt().(type) {
+		case idpEntityID:
+			sp.idpEntityID = string(t)
+		case requestTracker:
+			sp.assertions = t.RequestTracker
+		case clockSkew:
+			sp.clockSkew = time.Duration(t)
+		case profileClock:
+			sp.clock = t.Clock
+		case signaturePolicy:
+			if t&signaturePolicy(WantBothSigned) != 0 {
+				sp.signaturePolicy = SignaturePolicy(t) & WantBothSigned
+			}
+		case allowedAlgorithms:
+			sp.algorithms = t
+		case certificateValidity:
+			sp.certificates.ignoreValidity = !bool(t)
+		case certificateExpiryWarning:
+			sp.certificates.expiryWarning = t
+		case cbcDecryption:
+			sp.allowCBC = bool(t)
+		}
+	}
+	if sp.assertions == nil {
+		// assertions are only accepted for maxIssueDelay after they are issued, they must be
+		// remembered for that long allowing for the IDP clock being ahead or behind ours.
+		sp.assertions = NewMemoryRequestTracker(maxIssueDelay + 2*sp.clockSkew)
 	}
+	return sp
 }
 
 type relayState string
@@ -87,7 +202,7 @@ func (sp *SingleSignOnProfile) RedirectBinding(opts ...func() interface{}) (stri
 		SAML:  samlNamespace,
 		AssertionConsumerServiceURL: sp.serviceProvder.AssertionConsumerServiceURL,
 		Destination:                 idpRedirectURL,
-		IssueInstant:                time.Now().UTC().Format(samlTimeFormat),
+		IssueInstant:                NewDateTime(sp.clock.Now()),
 		ProtocolBinding:             redirectBinding,
 		Version:                     samlVersion,
 		Issuer: Issuer{
@@ -120,6 +235,12 @@ func (sp *SingleSignOnProfile) RedirectBinding(opts ...func() interface{}) (stri
 	return idpURL.String(), nil
 }
 
+// Callback validates the IDP AuthnResponse like HandlePostResponse, at the time of the
+// profile clock.
+func (sp *SingleSignOnProfile) Callback(samlResponse string) (*CallbackResponse, error) {
+	return sp.HandlePostResponse(samlResponse, sp.clock.Now())
+}
+
 // HandlePostResponse validates the IDP AuthnResponse. If successful information about the
 // IDP authorized user is returned. The samlResponse argument is extracted from the form posted
 // from the IDP in the SAMLResponse form value.
@@ -128,38 +249,49 @@ func (sp *SingleSignOnProfile) RedirectBinding(opts ...func() interface{}) (stri
 func (sp *SingleSignOnProfile) HandlePostResponse(samlResponse string, thisInstant time.Time) (*CallbackResponse, error) {
 	decoded, err := base64.StdEncoding.DecodeString(samlResponse)
 	if err != nil {
-		return nil, errors.Wrap(err, "decoding saml response")
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding saml response"))
 	}
-	signed, err := sp.validateSignature(decoded)
+	// IDPs frequently do not sign responses that report a failure, such as NoPassive, so
+	// the status is checked before the signature. This can only reject a response.
+	var unverified Response
+	err = xml.Unmarshal(decoded, &unverified)
 	if err != nil {
-		return nil, errors.Wrap(err, "validating auth response signature")
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding post response xml"))
 	}
-	var xmlBuff bytes.Buffer
-	doc := etree.NewDocument()
-	doc.SetRoot(signed)
-	_, err = doc.WriteTo(&xmlBuff)
+	err = checkStatus(unverified.Status)
 	if err != nil {
-		return nil, errors.Wrap(err, "processing post response xml")
+		return nil, err
 	}
-	var response Response
-	err = xml.NewDecoder(&xmlBuff).Decode(&response)
+	response, err := sp.verifyResponse(decoded, thisInstant)
 	if err != nil {
-		return nil, errors.Wrap(err, "decoding signed post response xml")
+		return nil, err
 	}
-	if !isStatusSuccess(response.Status.StatusCode.Value) {
-		return nil, errors.Errorf("IDP Status: %s", response.Status.StatusCode.Value)
+	err = sp.validateTiming(response, thisInstant)
+	if err != nil {
+		return nil, err
 	}
-	ok, err := timestampValid(&response, thisInstant)
+	err = sp.validateIssuer(response)
 	if err != nil {
-		return nil, errors.Wrap(err, "validating auth response")
+		return nil, err
 	}
-	if !ok {
-		return nil, errors.New("response timestamp is not valid")
+	err = sp.validateAudience(response)
+	if err != nil {
+		return nil, err
+	}
+	err = sp.validateRecipient(response)
+	if err != nil {
+		return nil, err
+	}
+	err = sp.useAssertion(response)
+	if err != nil {
+		return nil, err
 	}
 
 	cbr := &CallbackResponse{
 		Identity: &Identity{
 			UserID:     response.Assertion.Subject.NameID.Value,
+			Audience:   sp.serviceProvder.IssuerURI,
+			Recipient:  response.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient,
 			RelayState: "/",
 		},
 	}
@@ -167,63 +299,281 @@ func (sp *SingleSignOnProfile) HandlePostResponse(samlResponse string, thisInsta
 	return cbr, nil
 }
 
-func (sp *SingleSignOnProfile) validateSignature(xmlBytes []byte) (*etree.Element, error) {
+// verifyResponse validates the signatures of the decoded response and returns the elements
+// that were verified.
+func (sp *SingleSignOnProfile) verifyResponse(decoded []byte, thisInstant time.Time) (*Response, error) {
+	envelope, assertion, err := sp.validateSignature(decoded, thisInstant)
+	if err != nil {
+		return nil, newValidationError(ReasonBadSignature, errors.Wrap(err, "validating auth response signature"))
+	}
+	// Only the elements returned by signature validation are decoded, anything else in the
+	// document is ignored.
+	var response Response
+	err = decodeElement(envelope, &response)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding post response xml"))
+	}
+	response.Assertion = Assertion{}
+	err = decodeElement(assertion, &response.Assertion)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, errors.Wrap(err, "decoding signed assertion xml"))
+	}
+	return &response, nil
+}
+
+// validateTiming checks the issue instants and validity periods of the response at thisInstant.
+func (sp *SingleSignOnProfile) validateTiming(response *Response, thisInstant time.Time) error {
+	ok, err := timestampValid(response, thisInstant, sp.clockSkew)
+	if err != nil {
+		return newValidationError(ReasonMalformed, errors.Wrap(err, "validating auth response"))
+	}
+	if !ok {
+		return newValidationError(ReasonExpired, errors.New("response timestamp is not valid"))
+	}
+	return nil
+}
+
+// validateIssuer checks that the response and assertion were issued by the IDP. The Issuer
+// of the response is optional.
+func (sp *SingleSignOnProfile) validateIssuer(response *Response) error {
+	expected := sp.idpEntityID
+	if expected == "" {
+		expected = response.Issuer.Url
+	}
+	if response.Issuer.Url != "" && response.Issuer.Url != expected {
+		return newMismatchError(ReasonWrongIssuer, expected, response.Issuer.Url)
+	}
+	if expected != "" && response.Assertion.Issuer.Url != expected {
+		return newMismatchError(ReasonWrongIssuer, expected, response.Assertion.Issuer.Url)
+	}
+	return nil
+}
+
+// useAssertion records the ID of the assertion so that it can not be used again. It fails
+// with ReasonReplay if the assertion was already used.
+func (sp *SingleSignOnProfile) useAssertion(response *Response) error {
+	id := response.Assertion.ID
+	if id == "" {
+		return newValidationError(ReasonMalformed, errors.New("assertion does not have an ID"))
+	}
+	// a single call, so that concurrent copies of the assertion can not all be accepted
+	err := sp.assertions.TrackRequest(&TrackedRequest{
+		ID:           id,
+		IssueInstant: response.Assertion.IssueInstant.Time,
+	})
+	if errors.Is(err, ErrDuplicateRequest) {
+		return &ValidationError{Reason: ReasonReplay, Actual: id, Err: errors.New("assertion was already used")}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "tracking assertion %q", id)
+	}
+	return nil
+}
+
+// validateAudience checks that this service provider is an audience of the assertion. Each
+// AudienceRestriction must include the service provider.
+func (sp *SingleSignOnProfile) validateAudience(response *Response) error {
+	for _, restriction := range response.Assertion.Conditions.AudienceRestrictions {
+		found := false
+		for _, audience := range restriction.Audiences {
+			if audience == sp.serviceProvder.IssuerURI {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return newMismatchError(ReasonWrongAudience, sp.serviceProvder.IssuerURI, strings.Join(restriction.Audiences, " "))
+		}
+	}
+	return nil
+}
+
+// validateRecipient checks that the response was sent to our assertion consumer service.
+func (sp *SingleSignOnProfile) validateRecipient(response *Response) error {
+	acsURL := sp.serviceProvder.AssertionConsumerServiceURL
+	if acsURL == "" {
+		return nil
+	}
+	if response.Destination != "" && response.Destination != acsURL {
+		return newMismatchError(ReasonWrongDestination, acsURL, response.Destination)
+	}
+	recipient := response.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient
+	if recipient != "" && recipient != acsURL {
+		return newMismatchError(ReasonWrongRecipient, acsURL, recipient)
+	}
+	return nil
+}
+
+// validateSignature checks the response and assertion signatures required by the signature
+// policy. It returns the response without its assertion, and the assertion. Each is the
+// element that was verified if the policy requires it to be signed.
+//
+// To defeat XML signature wrapping attacks documents must contain exactly one assertion,
+// which must be a child of the response, and IDs must be unique. Callers must only use the
+// returned elements.
+//
+// An encrypted assertion is decrypted with the DecryptionKey of the service provider before its
+// signature is checked.
+func (sp *SingleSignOnProfile) validateSignature(xmlBytes []byte, thisInstant time.Time) (*etree.Element, *etree.Element, error) {
 	doc := etree.NewDocument()
 	err := doc.ReadFromBytes(xmlBytes)
 	if err != nil {
-		return nil, errors.Wrap(err, "xml for signature validation")
+		return nil, nil, errors.Wrap(err, "xml for signature validation")
 	}
-	if doc.Root() == nil {
-		return nil, errors.New("missing xml doc")
+	root := doc.Root()
+	if root == nil {
+		return nil, nil, errors.New("missing xml doc")
 	}
-	context, err := sp.getValidationContext()
+	if root.Tag != "Response" || root.NamespaceURI() != samlProtocalNamespace {
+		return nil, nil, errors.Errorf("unexpected root element %s", root.Tag)
+	}
+	err = checkWrapping(root)
 	if err != nil {
-		return nil, errors.Wrap(err, "setting up sig validation context")
+		return nil, nil, err
 	}
-	root := doc.Root()
-	validated, err := context.Validate(root)
-	if err == nil {
-		return validated, err
+	err = sp.algorithms.checkXMLSignatures(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	validator, err := sp.getSignatureValidator(thisInstant)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "setting up sig validation context")
 	}
+	envelope, err := validator.Validate(root)
 	if err == dsig.ErrMissingSignature {
-		err = etreeutils.NSFindIterate(root, samlNamespace, assertionTag, func(ctx etreeutils.NSContext, unverified *etree.Element) error {
-			if unverified.Parent() != root {
-				return errors.Errorf("assertion with unexpected parent: %s", unverified.Parent())
-			}
-			detached, err := etreeutils.NSDetatch(ctx, unverified)
-			if err != nil {
-				return err
-			}
-			signed, err := context.Validate(detached)
-			if err != nil {
-				return err
-			}
-			root.RemoveChild(unverified)
-			root.AddChild(signed)
-			return nil
-		})
-		if err != nil {
-			return nil, err
+		if sp.signaturePolicy&WantResponseSigned != 0 {
+			return nil, nil, errors.New("response is not signed")
 		}
-		return root, nil
+		envelope, err = root, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	assertion, err := sp.validateAssertion(validator, envelope)
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil, err
+	return envelope, assertion, nil
 }
 
-func (sp *SingleSignOnProfile) getValidationContext() (*dsig.ValidationContext, error) {
-	var certStore dsig.MemoryX509CertificateStore
-	for _, key := range sp.idpDescription.KeyDescriptors {
-		certData, err := base64.StdEncoding.DecodeString(key.KeyInfo.X509Data.X509Certificate.Data)
+// validateAssertion detaches the assertion from envelope, validating its signature if
+// required by the signature policy.
+func (sp *SingleSignOnProfile) validateAssertion(validator *signatureValidator, envelope *etree.Element) (*etree.Element, error) {
+	var assertion *etree.Element
+	err := etreeutils.NSFindIterate(envelope, samlNamespace, assertionTag, func(ctx etreeutils.NSContext, el *etree.Element) error {
+		if el.Parent() != envelope {
+			return errors.Errorf("assertion with unexpected parent: %s", el.Parent().Tag)
+		}
+		detached, err := etreeutils.NSDetatch(ctx, el)
 		if err != nil {
-			return nil, errors.Wrap(err, "decoding x509 cert")
+			return err
 		}
-		cert, err := x509.ParseCertificate(certData)
+		envelope.RemoveChild(el)
+		assertion = detached
+		return etreeutils.ErrTraversalHalted
+	})
+	if err != nil {
+		return nil, err
+	}
+	if assertion == nil {
+		assertion, err = sp.decryptAssertion(envelope)
 		if err != nil {
-			return nil, errors.Wrap(err, "parsing x509 cert")
+			return nil, err
+		}
+	}
+	if sp.signaturePolicy&WantAssertionsSigned == 0 {
+		return assertion, nil
+	}
+	signed, err := validator.Validate(assertion)
+	if err != nil {
+		return nil, errors.Wrap(err, "validating assertion signature")
+	}
+	return signed, nil
+}
+
+// decryptAssertion detaches and decrypts the encrypted assertion of envelope. The decrypted
+// assertion must not contain other assertions.
+func (sp *SingleSignOnProfile) decryptAssertion(envelope *etree.Element) (*etree.Element, error) {
+	var encrypted *etree.Element
+	for _, child := range envelope.ChildElements() {
+		if child.Tag == encryptedAssertionTag && child.NamespaceURI() == samlNamespace {
+			encrypted = child
 		}
-		certStore.Roots = append(certStore.Roots, cert)
 	}
-	return dsig.NewDefaultValidationContext(&certStore), nil
+	if encrypted == nil {
+		return nil, errors.New("response does not contain an assertion")
+	}
+	envelope.RemoveChild(encrypted)
+	if sp.serviceProvder.DecryptionKey == nil {
+		return nil, ErrDecryptionNotConfigured
+	}
+	plaintext, err := decryptElement(encrypted, sp.serviceProvder.DecryptionKey, sp.allowCBC)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypting assertion")
+	}
+	// a plaintext that is not an assertion fails like any other decryption failure, so that
+	// modified CBC ciphertexts can not be told apart
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(plaintext)
+	assertion := doc.Root()
+	if err != nil || assertion == nil || assertion.Tag != assertionTag || assertion.NamespaceURI() != samlNamespace {
+		return nil, errors.Wrap(ErrDecryptionFailed, "decrypting assertion")
+	}
+	err = checkWrapping(assertion)
+	if err != nil {
+		return nil, err
+	}
+	err = sp.algorithms.checkXMLSignatures(assertion)
+	if err != nil {
+		return nil, err
+	}
+	return assertion, nil
+}
+
+// checkWrapping rejects documents that could be used to wrap a signed element, those with
+// more than one assertion, plain or encrypted, or with duplicate IDs. Assertions are counted
+// regardless of namespace.
+func checkWrapping(root *etree.Element) error {
+	assertions := 0
+	ids := map[string]bool{}
+	var walk func(el *etree.Element) error
+	walk = func(el *etree.Element) error {
+		if el.Tag == assertionTag || el.Tag == encryptedAssertionTag {
+			assertions++
+			if assertions > 1 {
+				return errors.New("response contains more than one assertion")
+			}
+		}
+		if id := el.SelectAttrValue("ID", ""); id != "" {
+			if ids[id] {
+				return errors.Errorf("duplicate ID %q", id)
+			}
+			ids[id] = true
+		}
+		for _, child := range el.ChildElements() {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// decodeElement decodes el into v with encoding/xml.
+func decodeElement(el *etree.Element, v interface{}) error {
+	doc := etree.NewDocument()
+	doc.SetRoot(el.Copy())
+	var buff bytes.Buffer
+	_, err := doc.WriteTo(&buff)
+	if err != nil {
+		return err
+	}
+	return xml.NewDecoder(&buff).Decode(v)
+}
+
+func (sp *SingleSignOnProfile) getSignatureValidator(thisInstant time.Time) (*signatureValidator, error) {
+	return sp.certificates.newSignatureValidator(sp.idpDescription.KeyDescriptors, thisInstant)
 }
 
 func decodeAuthResponse(samlResponse string) (*Response, error) {
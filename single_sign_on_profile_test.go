@@ -1,13 +1,19 @@
 package saml
 
 import (
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/xml"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/beevik/etree"
+	"github.com/jonboulle/clockwork"
 	"github.com/murphybytes/saml/generated"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -89,6 +95,13 @@ func getFormAuthResponse(t *testing.T) string {
 	return unencoded
 }
 
+// allowSHA1 permits the RSA-SHA1 signature used by the OneLogin test data.
+func allowSHA1() func() interface{} {
+	signatureMethods := append([]string{SignatureMethodRSASHA1}, DefaultSignatureMethods...)
+	digestMethods := append([]string{DigestMethodSHA1}, DefaultDigestMethods...)
+	return WithAllowedAlgorithms(signatureMethods, digestMethods)
+}
+
 func getMockProvider(t *testing.T) *SingleSignOnProfile {
 	metadata, err := generated.Asset("test_data/metadata.xml")
 	require.Nil(t, err)
@@ -101,7 +114,7 @@ func getMockProvider(t *testing.T) *SingleSignOnProfile {
 			NameIDEmail,
 		},
 	}
-	return NewSingleSignOnProfile(sp, &entity.IDPSSODescriptor)
+	return NewSingleSignOnProfile(sp, &entity.IDPSSODescriptor, allowSHA1())
 }
 
 func TestPostBindingResponse(t *testing.T) {
@@ -111,13 +124,14 @@ func TestPostBindingResponse(t *testing.T) {
 	var entity EntityDescriptor
 	err = xml.Unmarshal(buff, &entity)
 	require.Nil(t, err)
+	// the audience in the test response was not filled in by the IDP
 	sp := &ServiceProvider{
-		IssuerURI: "uri:myserviceprovider",
+		IssuerURI: "{audience}",
 		NameIDFormats: []string{
 			NameIDEmail,
 		},
 	}
-	provider := NewSingleSignOnProfile(sp, &entity.IDPSSODescriptor)
+	provider := NewSingleSignOnProfile(sp, &entity.IDPSSODescriptor, allowSHA1())
 	requestInstant := time.Date(2017, 5, 29, 0, 6, 0, 0, time.UTC)
 	identity, err := provider.HandlePostResponse(unencoded, requestInstant)
 	require.Nil(t, err)
@@ -125,21 +139,72 @@ func TestPostBindingResponse(t *testing.T) {
 	assert.Equal(t, "john@kolide.co", identity.UserID)
 }
 
+func TestProfileClock(t *testing.T) {
+	unencoded := getFormAuthResponse(t)
+	buff, err := generated.Asset("test_data/metadata.xml")
+	require.Nil(t, err)
+	var entity EntityDescriptor
+	err = xml.Unmarshal(buff, &entity)
+	require.Nil(t, err)
+	sp := &ServiceProvider{
+		IssuerURI: "{audience}",
+	}
+	clock := clockwork.NewFakeClockAt(time.Date(2017, 5, 29, 0, 6, 0, 0, time.UTC))
+	provider := NewSingleSignOnProfile(sp, &entity.IDPSSODescriptor, allowSHA1(), WithClock(clock))
+
+	binding, err := provider.RedirectBinding()
+	require.Nil(t, err)
+	parsed, err := url.Parse(binding)
+	require.Nil(t, err)
+	inflated, err := inflate(parsed.Query().Get(RequestQueryKey))
+	require.Nil(t, err)
+	var request AuthnRequest
+	require.Nil(t, xml.Unmarshal([]byte(inflated), &request))
+	assert.Equal(t, "2017-05-29T00:06:00Z", request.IssueInstant.String())
+
+	identity, err := provider.Callback(unencoded)
+	require.Nil(t, err)
+	assert.Equal(t, "john@kolide.co", identity.UserID)
+
+	clock.Advance(time.Hour)
+	_, err = provider.Callback(unencoded)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrExpired))
+}
+
 func TestSignatureValidation(t *testing.T) {
 	unencoded := getFormAuthResponse(t)
 	provider := getMockProvider(t)
 	decoded, err := base64.StdEncoding.DecodeString(unencoded)
 	require.Nil(t, err)
-	doc, err := provider.validateSignature(decoded)
+	envelope, assertion, err := provider.validateSignature(decoded, testResponseInstant)
 	assert.Nil(t, err)
-	assert.NotNil(t, doc)
+	assert.NotNil(t, envelope)
+	assert.NotNil(t, assertion)
+}
+
+func TestSignatureValidationDisallowedAlgorithm(t *testing.T) {
+	metadata, err := generated.Asset("test_data/metadata.xml")
+	require.Nil(t, err)
+	var entity EntityDescriptor
+	err = xml.Unmarshal(metadata, &entity)
+	require.Nil(t, err)
+	provider := NewSingleSignOnProfile(&ServiceProvider{IssuerURI: "{audience}"}, &entity.IDPSSODescriptor)
+	_, err = provider.HandlePostResponse(getFormAuthResponse(t), time.Date(2017, 5, 29, 0, 6, 0, 0, time.UTC))
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrBadSignature))
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
 }
 
-func TestGetValidationContext(t *testing.T) {
+func TestGetSignatureValidator(t *testing.T) {
 	provider := getMockProvider(t)
-	context, err := provider.getValidationContext()
+	validator, err := provider.getSignatureValidator(testResponseInstant)
 	require.Nil(t, err)
-	assert.NotNil(t, context)
+	assert.Len(t, validator.certs, 1)
+
+	// the OneLogin certificate has expired
+	_, err = provider.getSignatureValidator(time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, ErrNoSigningCertificate, errors.Cause(err))
 }
 
 func TestDecodeAuthResponse(t *testing.T) {
@@ -148,3 +213,427 @@ func TestDecodeAuthResponse(t *testing.T) {
 	require.Nil(t, err)
 	assert.Equal(t, "R22cb13db51b271e2df86f6b2933a75229498a979", response.ID)
 }
+
+func TestValidateAudience(t *testing.T) {
+	provider := getMockProvider(t)
+	var response Response
+	err := provider.validateAudience(&response)
+	assert.Nil(t, err)
+
+	response.Assertion.Conditions.AudienceRestrictions = []AudienceRestriction{
+		{Audiences: []string{"uri:other", "uri:myserviceprovider"}},
+	}
+	err = provider.validateAudience(&response)
+	assert.Nil(t, err)
+
+	response.Assertion.Conditions.AudienceRestrictions = append(response.Assertion.Conditions.AudienceRestrictions,
+		AudienceRestriction{Audiences: []string{"uri:other"}})
+	err = provider.validateAudience(&response)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrWrongAudience))
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "uri:myserviceprovider", validationErr.Expected)
+	assert.Equal(t, "uri:other", validationErr.Actual)
+}
+
+func TestValidateRecipient(t *testing.T) {
+	provider := getMockProvider(t)
+	provider.serviceProvder.AssertionConsumerServiceURL = "https://sp.com/callback"
+	var response Response
+	err := provider.validateRecipient(&response)
+	assert.Nil(t, err)
+
+	response.Destination = "https://sp.com/callback"
+	response.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient = "https://sp.com/callback"
+	err = provider.validateRecipient(&response)
+	assert.Nil(t, err)
+
+	response.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient = "https://evil.com/callback"
+	err = provider.validateRecipient(&response)
+	assert.True(t, errors.Is(err, ErrWrongRecipient))
+
+	response.Destination = "https://evil.com/callback"
+	err = provider.validateRecipient(&response)
+	assert.True(t, errors.Is(err, ErrWrongDestination))
+}
+
+func TestHandlePostResponseValidationErrors(t *testing.T) {
+	provider := getMockProvider(t)
+	_, err := provider.HandlePostResponse("not base 64!", time.Now())
+	assert.True(t, errors.Is(err, ErrMalformed))
+	assert.False(t, errors.Is(err, ErrBadSignature))
+}
+
+func TestHandlePostResponseStatusFailure(t *testing.T) {
+	provider := getMockProvider(t)
+	// failure responses are commonly unsigned and carry no assertion
+	response := `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_abc" Version="2.0" IssueInstant="2017-05-29T00:06:42Z">
+	<samlp:Status>
+		<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Responder">
+			<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:NoPassive"/>
+		</samlp:StatusCode>
+		<samlp:StatusMessage>Passive authentication not possible</samlp:StatusMessage>
+	</samlp:Status>
+</samlp:Response>`
+	_, err := provider.HandlePostResponse(base64.StdEncoding.EncodeToString([]byte(response)), time.Now())
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrStatusFailure))
+	var se *StatusError
+	require.True(t, errors.As(err, &se))
+	assert.True(t, se.HasCode(NoPassive))
+	assert.Equal(t, "Passive authentication not possible", se.Message)
+}
+
+func TestHandlePostResponseIssuer(t *testing.T) {
+	thisInstant := time.Now()
+	tests := []struct {
+		name     string
+		entityID string
+		modify   func(*Response)
+		err      error
+	}{
+		{"expected issuer", "https://idp.example.com", func(*Response) {}, nil},
+		{"no entity ID", "", func(*Response) {}, nil},
+		{"wrong entity ID", "https://other.example.com", func(*Response) {}, ErrWrongIssuer},
+		{"response issuer omitted", "https://idp.example.com", func(r *Response) { r.Issuer.Url = "" }, nil},
+		{"wrong assertion issuer", "https://idp.example.com", func(r *Response) { r.Assertion.Issuer.Url = "https://evil.example.com" }, ErrWrongIssuer},
+		{"assertion differs from response", "", func(r *Response) { r.Assertion.Issuer.Url = "https://evil.example.com" }, ErrWrongIssuer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp, profile, request := getResponseTest(t, thisInstant, WithIDPEntityID(tt.entityID))
+			response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+			require.Nil(t, err)
+			tt.modify(response)
+			encoded, _ := postResponse(t, idp, request, response)
+			_, err = profile.HandlePostResponse(encoded, thisInstant)
+			if tt.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+		})
+	}
+}
+
+func TestHandlePostResponseReplay(t *testing.T) {
+	thisInstant := time.Now()
+	tracker := NewMemoryRequestTracker(time.Hour)
+	idp, profile, request := getResponseTest(t, thisInstant, WithRequestTracker(tracker))
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	encoded, _ := postResponse(t, idp, request, response)
+	_, err = profile.HandlePostResponse(encoded, thisInstant)
+	require.Nil(t, err)
+
+	// every later use of the assertion is rejected, including by profiles sharing the tracker
+	other := NewSingleSignOnProfile(profile.serviceProvder, profile.idpDescription, WithRequestTracker(tracker))
+	for _, p := range []*SingleSignOnProfile{profile, profile, other} {
+		_, err = p.HandlePostResponse(encoded, thisInstant.Add(time.Second))
+		assert.True(t, errors.Is(err, ErrReplay), "got %v", err)
+	}
+
+	// a rejected response does not use up the assertion
+	response, err = idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	encoded, _ = postResponse(t, idp, request, response)
+	_, err = profile.HandlePostResponse(encoded, thisInstant.Add(10*time.Minute))
+	assert.True(t, errors.Is(err, ErrExpired))
+	_, err = profile.HandlePostResponse(encoded, thisInstant)
+	assert.Nil(t, err)
+}
+
+const testAuthResponse = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_response" Version="2.0" IssueInstant="2017-05-29T00:06:42Z"><saml:Issuer>uri:myidp</saml:Issuer><samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status><saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_assertion" Version="2.0" IssueInstant="2017-05-29T00:06:42Z"><saml:Issuer>uri:myidp</saml:Issuer><saml:Subject><saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">john@kolide.co</saml:NameID></saml:Subject></saml:Assertion></samlp:Response>`
+
+// getSignedAuthResponse returns testAuthResponse signed by the key of idp.
+func getSignedAuthResponse(t *testing.T, idp *ServiceProvider, signAssertion, signResponse bool) []byte {
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(testAuthResponse)
+	require.Nil(t, err)
+	ctx, err := idp.getSigningContext()
+	require.Nil(t, err)
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	root := doc.Root()
+	if signAssertion {
+		assertion := root.SelectElement("Assertion")
+		signed, err := signEnveloped(ctx, assertion)
+		require.Nil(t, err)
+		root.InsertChildAt(assertion.Index(), signed)
+		root.RemoveChild(assertion)
+	}
+	if signResponse {
+		root, err = signEnveloped(ctx, root)
+		require.Nil(t, err)
+		doc.SetRoot(root)
+	}
+	raw, err := doc.WriteToBytes()
+	require.Nil(t, err)
+	return raw
+}
+
+func getKeyDescriptor(cert *x509.Certificate) KeyDescriptor {
+	return KeyDescriptor{
+		Use: "signing",
+		KeyInfo: KeyInfo{
+			X509Data: X509Data{
+				X509Certificates: []X509Certificate{
+					{Data: base64.StdEncoding.EncodeToString(cert.Raw)},
+				},
+			},
+		},
+	}
+}
+
+// getSigningIDPProfile returns a profile that trusts the signing certificate of idp.
+func getSigningIDPProfile(idp *ServiceProvider, opts ...func() interface{}) *SingleSignOnProfile {
+	descriptor := &IDPSSODescriptor{
+		KeyDescriptors: []KeyDescriptor{getKeyDescriptor(idp.SigningCertificate)},
+	}
+	sp := &ServiceProvider{IssuerURI: "uri:myserviceprovider"}
+	return NewSingleSignOnProfile(sp, descriptor, opts...)
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	tt := []struct {
+		name           string
+		policy         SignaturePolicy
+		signAssertion  bool
+		signResponse   bool
+		expectAccepted bool
+	}{
+		{"assertions signed assertion only", WantAssertionsSigned, true, false, true},
+		{"assertions signed response only", WantAssertionsSigned, false, true, false},
+		{"assertions signed both", WantAssertionsSigned, true, true, true},
+		{"response signed assertion only", WantResponseSigned, true, false, false},
+		{"response signed response only", WantResponseSigned, false, true, true},
+		{"both signed assertion only", WantBothSigned, true, false, false},
+		{"both signed response only", WantBothSigned, false, true, false},
+		{"both signed both", WantBothSigned, true, true, true},
+		{"nothing signed", WantAssertionsSigned, false, false, false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			profile := getSigningIDPProfile(idp, WithSignaturePolicy(tc.policy))
+			raw := getSignedAuthResponse(t, idp, tc.signAssertion, tc.signResponse)
+			_, validated, err := profile.validateSignature(raw, time.Now())
+			if !tc.expectAccepted {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			var assertion Assertion
+			err = decodeElement(validated, &assertion)
+			require.Nil(t, err)
+			assert.Equal(t, "john@kolide.co", assertion.Subject.NameID.Value)
+		})
+	}
+}
+
+func TestSignaturePolicyDefault(t *testing.T) {
+	profile := getSigningIDPProfile(getRSAServiceProvider(t))
+	assert.Equal(t, WantAssertionsSigned, profile.signaturePolicy)
+	profile = getSigningIDPProfile(getRSAServiceProvider(t), WithSignaturePolicy(0))
+	assert.Equal(t, WantAssertionsSigned, profile.signaturePolicy)
+}
+
+// testResponseInstant is shortly after testAuthResponse was issued.
+var testResponseInstant = time.Date(2017, 5, 29, 0, 7, 0, 0, time.UTC)
+
+func TestHandleSignedPostResponse(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	raw := getSignedAuthResponse(t, idp, true, true)
+	// the certificate of idp is not valid at testResponseInstant
+	profile := getSigningIDPProfile(idp, WithSignaturePolicy(WantBothSigned), WithCertificateValidity(false))
+	cb, err := profile.HandlePostResponse(base64.StdEncoding.EncodeToString(raw), testResponseInstant)
+	require.Nil(t, err)
+	assert.Equal(t, "john@kolide.co", cb.UserID)
+}
+
+// evilAssertion returns a copy of assertion naming the attacker, its signature is
+// removed unless keepSignature is set.
+func evilAssertion(assertion *etree.Element, keepSignature bool) *etree.Element {
+	evil := assertion.Copy()
+	evil.FindElement(".//NameID").SetText("attacker@evil.com")
+	if !keepSignature {
+		evil.RemoveChild(evil.SelectElement("Signature"))
+		evil.CreateAttr("ID", "_evil")
+	}
+	return evil
+}
+
+// Signature wrapping attacks, see "On Breaking SAML: Be Whoever You Want to Be",
+// Somorovsky et al. 2012.
+func TestSignatureWrapping(t *testing.T) {
+	idp := getRSAServiceProvider(t)
+	tt := []struct {
+		name         string
+		signResponse bool
+		policy       SignaturePolicy
+		wrap         func(doc *etree.Document)
+	}{
+		{
+			// evil response with the signed response inside its signature
+			name:         "XSW1",
+			signResponse: true,
+			policy:       WantResponseSigned,
+			wrap: func(doc *etree.Document) {
+				original := doc.Root()
+				evil := original.Copy()
+				evil.CreateAttr("ID", "_evil")
+				evil.RemoveChild(evil.SelectElement("Assertion"))
+				evil.AddChild(evilAssertion(original.SelectElement("Assertion"), false))
+				evil.SelectElement("Signature").AddChild(original.Copy())
+				doc.SetRoot(evil)
+			},
+		},
+		{
+			// evil response with the signed response as a sibling of its signature
+			name:         "XSW2",
+			signResponse: true,
+			policy:       WantResponseSigned,
+			wrap: func(doc *etree.Document) {
+				original := doc.Root()
+				evil := original.Copy()
+				evil.CreateAttr("ID", "_evil")
+				evil.RemoveChild(evil.SelectElement("Assertion"))
+				evil.AddChild(evilAssertion(original.SelectElement("Assertion"), false))
+				evil.InsertChildAt(evil.SelectElement("Signature").Index(), original.Copy())
+				doc.SetRoot(evil)
+			},
+		},
+		{
+			// evil assertion before the signed assertion
+			name: "XSW3",
+			wrap: func(doc *etree.Document) {
+				assertion := doc.Root().SelectElement("Assertion")
+				doc.Root().InsertChildAt(assertion.Index(), evilAssertion(assertion, false))
+			},
+		},
+		{
+			// evil assertion containing the signed assertion
+			name: "XSW4",
+			wrap: func(doc *etree.Document) {
+				assertion := doc.Root().SelectElement("Assertion")
+				evil := evilAssertion(assertion, false)
+				evil.AddChild(assertion.Copy())
+				doc.Root().InsertChildAt(assertion.Index(), evil)
+				doc.Root().RemoveChild(assertion)
+			},
+		},
+		{
+			// signed assertion modified, original copied to the end of the response
+			name: "XSW5",
+			wrap: func(doc *etree.Document) {
+				assertion := doc.Root().SelectElement("Assertion")
+				doc.Root().AddChild(assertion.Copy())
+				assertion.FindElement(".//NameID").SetText("attacker@evil.com")
+				assertion.CreateAttr("ID", "_evil")
+			},
+		},
+		{
+			// signed assertion modified, original copied inside its signature
+			name: "XSW6",
+			wrap: func(doc *etree.Document) {
+				assertion := doc.Root().SelectElement("Assertion")
+				original := assertion.Copy()
+				assertion.FindElement(".//NameID").SetText("attacker@evil.com")
+				assertion.CreateAttr("ID", "_evil")
+				assertion.SelectElement("Signature").AddChild(original)
+			},
+		},
+		{
+			// signed assertion moved to Extensions, evil assertion in its place
+			name: "XSW7",
+			wrap: func(doc *etree.Document) {
+				assertion := doc.Root().SelectElement("Assertion")
+				extensions := doc.Root().CreateElement("samlp:Extensions")
+				extensions.AddChild(assertion.Copy())
+				doc.Root().InsertChildAt(assertion.Index(), evilAssertion(assertion, false))
+				doc.Root().RemoveChild(assertion)
+			},
+		},
+		{
+			// evil assertion keeping the signature, original without signature in ds:Object
+			name: "XSW8",
+			wrap: func(doc *etree.Document) {
+				assertion := doc.Root().SelectElement("Assertion")
+				original := assertion.Copy()
+				original.RemoveChild(original.SelectElement("Signature"))
+				evil := evilAssertion(assertion, true)
+				evil.SelectElement("Signature").CreateElement("ds:Object").AddChild(original)
+				doc.Root().InsertChildAt(assertion.Index(), evil)
+				doc.Root().RemoveChild(assertion)
+			},
+		},
+		{
+			// signed assertion modified in place
+			name: "tampered assertion",
+			wrap: func(doc *etree.Document) {
+				doc.Root().FindElement(".//NameID").SetText("attacker@evil.com")
+			},
+		},
+		{
+			// second response element with a duplicate ID
+			name:         "duplicate ID",
+			signResponse: true,
+			policy:       WantResponseSigned,
+			wrap: func(doc *etree.Document) {
+				doc.Root().SelectElement("Status").CreateElement("samlp:StatusDetail").AddChild(doc.Root().SelectElement("Issuer").Copy())
+				doc.Root().SelectElement("Status").SelectElement("StatusDetail").SelectElement("Issuer").CreateAttr("ID", "_response")
+			},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := tc.policy
+			if policy == 0 {
+				policy = WantAssertionsSigned
+			}
+			doc := etree.NewDocument()
+			err := doc.ReadFromBytes(getSignedAuthResponse(t, idp, true, tc.signResponse))
+			require.Nil(t, err)
+			tc.wrap(doc)
+			raw, err := doc.WriteToBytes()
+			require.Nil(t, err)
+
+			// certificate dates are ignored so that only wrapping can fail validation
+			profile := getSigningIDPProfile(idp, WithSignaturePolicy(policy), WithCertificateValidity(false))
+			cb, err := profile.HandlePostResponse(base64.StdEncoding.EncodeToString(raw), testResponseInstant)
+			require.NotNil(t, err, "accepted identity %+v", cb)
+			assert.True(t, errors.Is(err, ErrBadSignature))
+		})
+	}
+}
+
+func TestHandlePostResponseConcurrentReplay(t *testing.T) {
+	thisInstant := time.Now()
+	idp, profile, request := getResponseTest(t, thisInstant)
+	response, err := idp.NewResponse(request, getTestPrincipal(), thisInstant)
+	require.Nil(t, err)
+	encoded, _ := postResponse(t, idp, request, response)
+
+	const copies = 20
+	errs := make(chan error, copies)
+	var wg sync.WaitGroup
+	for i := 0; i < copies; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := profile.HandlePostResponse(encoded, thisInstant)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	accepted := 0
+	for err := range errs {
+		if err == nil {
+			accepted++
+			continue
+		}
+		assert.True(t, errors.Is(err, ErrReplay), "got %v", err)
+	}
+	assert.Equal(t, 1, accepted)
+}
@@ -1,5 +1,11 @@
 package saml
 
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
 const (
 	// These are response status codes described in the core SAML spec section
 	// 3.2.2.1 See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
@@ -28,6 +34,9 @@ const (
 	UnsupportedBinding
 )
 
+// UnknownStatus is used for status code URIs that are not defined by the SAML spec.
+const UnknownStatus = -1
+
 var statusMap = map[string]int{
 	"urn:oasis:names:tc:SAML:2.0:status:Success":                  Success,
 	"urn:oasis:names:tc:SAML:2.0:status:Requester":                Requestor,
@@ -45,6 +54,7 @@ var statusMap = map[string]int{
 	"urn:oasis:names:tc:SAML:2.0:status:RequestDenied":            RequestDenied,
 	"urn:oasis:names:tc:SAML:2.0:status:RequestUnsupported":       RequestUnsupported,
 	"urn:oasis:names:tc:SAML:2.0:status:RequestVersionDeprecated": RequestVersionDeprecated,
+	"urn:oasis:names:tc:SAML:2.0:status:RequestVersionTooHigh":    RequestVersionTooHigh,
 	"urn:oasis:names:tc:SAML:2.0:status:RequestVersionTooLow":     RequestVersionTooLow,
 	"urn:oasis:names:tc:SAML:2.0:status:ResourceNotRecognized":    ResourceNotRecognized,
 	"urn:oasis:names:tc:SAML:2.0:status:TooManyResponses":         TooManyResponses,
@@ -53,6 +63,126 @@ var statusMap = map[string]int{
 	"urn:oasis:names:tc:SAML:2.0:status:UnsupportedBinding":       UnsupportedBinding,
 }
 
+var statusURIs = map[int]string{}
+
+func init() {
+	for uri, code := range statusMap {
+		statusURIs[code] = uri
+	}
+}
+
+// newStatus creates a status with code as the top level status code. If subCode is
+// supplied it is added as a second level status code.
+func newStatus(code int, subCode ...int) Status {
+	status := Status{
+		XMLName: xml.Name{
+			Local: "samlp:Status",
+		},
+		StatusCode: StatusCode{
+			XMLName: xml.Name{
+				Local: "samlp:StatusCode",
+			},
+			Value: statusURIs[code],
+		},
+	}
+	if len(subCode) > 0 {
+		status.StatusCode.StatusCode = &StatusCode{
+			XMLName: xml.Name{
+				Local: "samlp:StatusCode",
+			},
+			Value: statusURIs[subCode[0]],
+		}
+	}
+	return status
+}
+
+// statusCode returns the status code constant for uri, or UnknownStatus.
+func statusCode(uri string) int {
+	if code, ok := statusMap[uri]; ok {
+		return code
+	}
+	return UnknownStatus
+}
+
 func isStatusSuccess(status string) bool {
-	return statusMap[status] == Success
+	return statusCode(status) == Success
+}
+
+// isPartialLogout returns true if status is success with the second level code PartialLogout,
+// the responder could not end every session of the principal.
+func isPartialLogout(status Status) bool {
+	return isStatusSuccess(status.StatusCode.Value) && status.StatusCode.StatusCode != nil &&
+		statusCode(status.StatusCode.StatusCode.Value) == PartialLogout
+}
+
+// StatusError is the Err of a ValidationError with ReasonStatusFailure. It describes the
+// status returned by the IDP so that callers can tell, for example, NoPassive from
+// AuthnFailed.
+type StatusError struct {
+	// Code is the top level status code such as Requestor or Responder.
+	Code int
+	// SubCodes are the nested status codes, outermost first.
+	SubCodes []int
+	// URIs are the status code values sent by the IDP, the top level code first.
+	// Use these when a code is UnknownStatus.
+	URIs []string
+	// Message is the optional StatusMessage sent by the IDP.
+	Message string
+	// Detail is the raw content of the optional StatusDetail element.
+	Detail string
+}
+
+// newStatusError returns a StatusError describing status, or nil if the status is success.
+func newStatusError(status Status) *StatusError {
+	if isStatusSuccess(status.StatusCode.Value) {
+		return nil
+	}
+	se := &StatusError{
+		Code:    statusCode(status.StatusCode.Value),
+		URIs:    []string{status.StatusCode.Value},
+		Message: strings.TrimSpace(status.StatusMessage),
+	}
+	for sc := status.StatusCode.StatusCode; sc != nil; sc = sc.StatusCode {
+		se.SubCodes = append(se.SubCodes, statusCode(sc.Value))
+		se.URIs = append(se.URIs, sc.Value)
+	}
+	if status.StatusDetail != nil {
+		se.Detail = strings.TrimSpace(status.StatusDetail.Content)
+	}
+	return se
+}
+
+// HasCode returns true if code is the top level status code or one of the sub codes.
+func (e *StatusError) HasCode(code int) bool {
+	if e.Code == code {
+		return true
+	}
+	for _, sc := range e.SubCodes {
+		if sc == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *StatusError) Error() string {
+	msg := "status " + strings.Join(e.URIs, " ")
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+	return msg
+}
+
+// checkStatus returns a ValidationError wrapping a StatusError if status is not success.
+func checkStatus(status Status) error {
+	se := newStatusError(status)
+	if se == nil {
+		return nil
+	}
+	return &ValidationError{
+		Reason:   ReasonStatusFailure,
+		Expected: statusURIs[Success],
+		Actual:   status.StatusCode.Value,
+		Err:      se,
+	}
 }
@@ -0,0 +1,71 @@
+package saml
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsStatusSuccess(t *testing.T) {
+	assert.True(t, isStatusSuccess("urn:oasis:names:tc:SAML:2.0:status:Success"))
+	assert.False(t, isStatusSuccess("urn:oasis:names:tc:SAML:2.0:status:Responder"))
+	assert.False(t, isStatusSuccess("urn:example:status:Whatever"))
+	assert.False(t, isStatusSuccess(""))
+	assert.Equal(t, RequestVersionTooHigh, statusCode("urn:oasis:names:tc:SAML:2.0:status:RequestVersionTooHigh"))
+}
+
+const nestedStatus = `<samlp:Status xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">
+	<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Responder">
+		<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:NoPassive"/>
+	</samlp:StatusCode>
+	<samlp:StatusMessage> User is not signed in </samlp:StatusMessage>
+	<samlp:StatusDetail><Code>42</Code></samlp:StatusDetail>
+</samlp:Status>`
+
+func TestNewStatusError(t *testing.T) {
+	var status Status
+	err := xml.Unmarshal([]byte(nestedStatus), &status)
+	require.Nil(t, err)
+
+	se := newStatusError(status)
+	require.NotNil(t, se)
+	assert.Equal(t, Responder, se.Code)
+	assert.Equal(t, []int{NoPassive}, se.SubCodes)
+	assert.Equal(t, "User is not signed in", se.Message)
+	assert.Equal(t, "<Code>42</Code>", se.Detail)
+	assert.True(t, se.HasCode(NoPassive))
+	assert.False(t, se.HasCode(AuthnFailed))
+	assert.Contains(t, se.Error(), "User is not signed in")
+
+	assert.Nil(t, newStatusError(newStatus(Success, PartialLogout)))
+}
+
+func TestIsPartialLogout(t *testing.T) {
+	assert.True(t, isPartialLogout(newStatus(Success, PartialLogout)))
+	assert.False(t, isPartialLogout(newStatus(Success)))
+	assert.False(t, isPartialLogout(newStatus(Responder, PartialLogout)))
+}
+
+func TestNewStatusErrorUnknownCode(t *testing.T) {
+	status := newStatus(Success)
+	status.StatusCode.Value = "urn:example:status:Whatever"
+	se := newStatusError(status)
+	require.NotNil(t, se)
+	assert.Equal(t, UnknownStatus, se.Code)
+	assert.Equal(t, []string{"urn:example:status:Whatever"}, se.URIs)
+}
+
+func TestCheckStatus(t *testing.T) {
+	assert.Nil(t, checkStatus(newStatus(Success)))
+
+	err := checkStatus(newStatus(Requestor, AuthnFailed))
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrStatusFailure))
+	var se *StatusError
+	require.True(t, errors.As(err, &se))
+	assert.Equal(t, Requestor, se.Code)
+	assert.True(t, se.HasCode(AuthnFailed))
+}
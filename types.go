@@ -5,8 +5,7 @@ import (
 )
 
 const (
-	samlVersion    = "2.0"
-	samlTimeFormat = "2006-01-02T15:04:05Z"
+	samlVersion = "2.0"
 	// binding types
 	redirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
 	postBinding     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
@@ -23,21 +22,28 @@ const (
 	assertionTag          = "Assertion"
 )
 
-// EntityDescriptor specifies metadata for a single SAML entity.
+// EntityDescriptor specifies metadata for a single SAML entity. If ValidUntil
+// is present the metadata must not be used after that time. CacheDuration is an
+// xs:duration, the time the metadata may be cached for.
 type EntityDescriptor struct {
 	XMLName          xml.Name         `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	ID               string           `xml:"ID,attr,omitempty"`
 	EntityID         string           `xml:"entityID,attr"`
+	ValidUntil       DateTime         `xml:"validUntil,attr"`
+	CacheDuration    string           `xml:"cacheDuration,attr,omitempty"`
 	IDPSSODescriptor IDPSSODescriptor `xml:"IDPSSODescriptor"`
 }
 
 // IDPSSODescriptor contains information about the identity provider.
 type IDPSSODescriptor struct {
-	XMLName             xml.Name              `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
-	KeyDescriptors      []KeyDescriptor       `xml:"KeyDescriptor"`
-	SingleLogoutService []SingleLogoutService `xml:"SingleLogoutService"`
-	NameIDFormats       []NameIDFormat        `xml:"NameIDFormat"`
-	SingleSignOnService []SingleSignOnService `xml:"SingleSignOnService"`
-	Attributes          []Attribute           `xml:"Attribute"`
+	XMLName                    xml.Name              `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+	WantAuthnRequestsSigned    bool                  `xml:"WantAuthnRequestsSigned,attr,omitempty"`
+	ProtocolSupportEnumeration string                `xml:"protocolSupportEnumeration,attr,omitempty"`
+	KeyDescriptors             []KeyDescriptor       `xml:"KeyDescriptor"`
+	SingleLogoutService        []SingleLogoutService `xml:"SingleLogoutService"`
+	NameIDFormats              []NameIDFormat        `xml:"NameIDFormat"`
+	SingleSignOnService        []SingleSignOnService `xml:"SingleSignOnService"`
+	Attributes                 []Attribute           `xml:"Attribute"`
 }
 
 // KeyDescriptor element provides information about the cryptographic key(s) that an entity uses
@@ -63,22 +69,28 @@ type SingleSignOnService struct {
 }
 
 // SingleLogoutService contains parameters needed to connect to the IPD and logout.
+// If ResponseLocation is present, logout responses are sent there rather than to Location.
 type SingleLogoutService struct {
-	XMLName  xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleLogoutService"`
-	Binding  string   `xml:"Binding,attr"`
-	Location string   `xml:"Location,attr"`
+	XMLName          xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleLogoutService"`
+	Binding          string   `xml:"Binding,attr"`
+	Location         string   `xml:"Location,attr"`
+	ResponseLocation string   `xml:"ResponseLocation,attr,omitempty"`
 }
 
-// KeyInfo wrapper for crypto key
+// KeyInfo wrapper for crypto key. Keys may be supplied as X509 certificates or as a raw
+// public key in KeyValue. KeyName is informational only, it is not used to find keys.
 type KeyInfo struct {
-	XMLName  xml.Name `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
-	X509Data X509Data `xml:"X509Data"`
+	XMLName  xml.Name  `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
+	KeyNames []string  `xml:"http://www.w3.org/2000/09/xmldsig# KeyName,omitempty"`
+	KeyValue *KeyValue `xml:"KeyValue,omitempty"`
+	X509Data X509Data  `xml:"X509Data"`
 }
 
-// X509Data wraps the X509 cert.
+// X509Data wraps X509 certs. It may contain several certificates, for instance an IDP
+// rolling over to a new key, or a certificate chain.
 type X509Data struct {
-	XMLName         xml.Name        `xml:"http://www.w3.org/2000/09/xmldsig# X509Data"`
-	X509Certificate X509Certificate `xml:"X509Certificate"`
+	XMLName          xml.Name          `xml:"http://www.w3.org/2000/09/xmldsig# X509Data"`
+	X509Certificates []X509Certificate `xml:"X509Certificate"`
 }
 
 // X509Certificate the certificate that will be used to verify the signature of AuthnResponse
@@ -87,6 +99,33 @@ type X509Certificate struct {
 	Data    string   `xml:",chardata"`
 }
 
+// KeyValue contains a raw RSA or EC public key.
+type KeyValue struct {
+	XMLName     xml.Name     `xml:"http://www.w3.org/2000/09/xmldsig# KeyValue"`
+	RSAKeyValue *RSAKeyValue `xml:"RSAKeyValue,omitempty"`
+	ECKeyValue  *ECKeyValue  `xml:"ECKeyValue,omitempty"`
+}
+
+// RSAKeyValue is an RSA public key, Modulus and Exponent are base64 encoded big endian integers.
+type RSAKeyValue struct {
+	XMLName  xml.Name `xml:"http://www.w3.org/2000/09/xmldsig# RSAKeyValue"`
+	Modulus  string   `xml:"http://www.w3.org/2000/09/xmldsig# Modulus"`
+	Exponent string   `xml:"http://www.w3.org/2000/09/xmldsig# Exponent"`
+}
+
+// ECKeyValue is an elliptic curve public key, PublicKey is the base64 encoded uncompressed point.
+// See https://www.w3.org/TR/xmldsig-core1/#sec-ECKeyValue
+type ECKeyValue struct {
+	XMLName    xml.Name   `xml:"http://www.w3.org/2009/xmldsig11# ECKeyValue"`
+	NamedCurve NamedCurve `xml:"http://www.w3.org/2009/xmldsig11# NamedCurve"`
+	PublicKey  string     `xml:"http://www.w3.org/2009/xmldsig11# PublicKey"`
+}
+
+// NamedCurve identifies the curve of an ECKeyValue by OID, for example urn:oid:1.2.840.10045.3.1.7
+type NamedCurve struct {
+	URI string `xml:"URI,attr"`
+}
+
 // AttributeValue contains the attributes supported by the identity provider
 type AttributeValue struct {
 	XMLName xml.Name
@@ -98,8 +137,8 @@ type AttributeValue struct {
 type Attribute struct {
 	XMLName         xml.Name
 	Name            string           `xml:",attr"`
-	FriendlyName    string           `xml:",attr"`
-	NameFormat      string           `xml:",attr"`
+	FriendlyName    string           `xml:",attr,omitempty"`
+	NameFormat      string           `xml:",attr,omitempty"`
 	AttributeValues []AttributeValue `xml:"AttributeValue"`
 }
 
@@ -108,21 +147,26 @@ type Attribute struct {
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf Section 3.4.1
 type AuthnRequest struct {
 	XMLName                     xml.Name
-	SAMLP                       string                 `xml:"xmlns:samlp,attr"`
-	SAML                        string                 `xml:"xmlns:saml,attr"`
-	SAMLSIG                     string                 `xml:"xmlns:samlsig,attr,omitempty"`
-	ID                          string                 `xml:"ID,attr"`
-	Version                     string                 `xml:"Version,attr"`
-	ProtocolBinding             string                 `xml:"ProtocolBinding,attr"`
-	AssertionConsumerServiceURL string                 `xml:"AssertionConsumerServiceURL,attr"`
-	Destination                 string                 `xml:"Destination,attr"`
-	IssueInstant                string                 `xml:"IssueInstant,attr"`
-	ProviderName                string                 `xml:"ProviderName,attr"`
-	Issuer                      Issuer                 `xml:"Issuer"`
-	NameIDPolicy                *NameIDPolicy          `xml:"NameIDPolicy,omitempty"`
-	RequestedAuthnContext       *RequestedAuthnContext `xml:"RequestedAuthnContext,omitempty"`
-	Signature                   *Signature             `xml:"Signature,omitempty"`
-	originalString              string
+	SAMLP                       string `xml:"xmlns:samlp,attr"`
+	SAML                        string `xml:"xmlns:saml,attr"`
+	SAMLSIG                     string `xml:"xmlns:samlsig,attr,omitempty"`
+	ID                          string `xml:"ID,attr"`
+	Version                     string `xml:"Version,attr"`
+	ProtocolBinding             string `xml:"ProtocolBinding,attr"`
+	AssertionConsumerServiceURL string `xml:"AssertionConsumerServiceURL,attr"`
+	// AssertionConsumerServiceIndex selects an endpoint from the SP metadata instead of
+	// AssertionConsumerServiceURL.
+	AssertionConsumerServiceIndex string                 `xml:"AssertionConsumerServiceIndex,attr,omitempty"`
+	Destination                   string                 `xml:"Destination,attr"`
+	IssueInstant                  DateTime               `xml:"IssueInstant,attr"`
+	ProviderName                  string                 `xml:"ProviderName,attr"`
+	ForceAuthn                    bool                   `xml:"ForceAuthn,attr,omitempty"`
+	IsPassive                     bool                   `xml:"IsPassive,attr,omitempty"`
+	Issuer                        Issuer                 `xml:"Issuer"`
+	NameIDPolicy                  *NameIDPolicy          `xml:"NameIDPolicy,omitempty"`
+	RequestedAuthnContext         *RequestedAuthnContext `xml:"RequestedAuthnContext,omitempty"`
+	Signature                     *Signature             `xml:"Signature,omitempty"`
+	originalString                string
 }
 
 // LogoutRequest is sent to the IDP when the Service Provider initiates the logout request.  If the IDP initiates
@@ -132,14 +176,18 @@ type AuthnRequest struct {
 // See https://www.oasis-open.org/committees/download.php/35389/sstc-saml-profiles-errata-2.0-wd-06-diff.pdf Section 4.4.
 type LogoutRequest struct {
 	XMLName      xml.Name
-	SAMLP        string `xml:"xmlns:samlp,attr"`
-	SAML         string `xml:"xmlns:saml,attr"`
-	SAMLSIG      string `xml:"xmlns:samlsig,attr,omitempty"`
-	ID           string `xml:"ID,attr"`
-	IssueInstant string `xml:"IssueInstant,attr"`
-	Version      string `xml:"Version,attr"`
+	SAMLP        string   `xml:"xmlns:samlp,attr"`
+	SAML         string   `xml:"xmlns:saml,attr"`
+	SAMLSIG      string   `xml:"xmlns:samlsig,attr,omitempty"`
+	ID           string   `xml:"ID,attr"`
+	IssueInstant DateTime `xml:"IssueInstant,attr"`
+	NotOnOrAfter DateTime `xml:"NotOnOrAfter,attr"`
+	Version      string   `xml:"Version,attr"`
+	Destination  string   `xml:"Destination,attr,omitempty"`
+	Reason       string   `xml:"Reason,attr,omitempty"`
 	Issuer       Issuer
 	NameID       NameID
+	SessionIndex []string `xml:"SessionIndex,omitempty"`
 }
 
 // LogoutResponse this is either send to the Service Provider in response to
@@ -147,14 +195,16 @@ type LogoutRequest struct {
 // the the IDP initiates the logout request.
 type LogoutResponse struct {
 	XMLName      xml.Name
-	InResponseTo string `xml:"InResponseTo,attr"`
-	Version      string `xml:"Version,attr"`
-	IssueInstant string `xml:"IssueInstant,attr"`
-	SAMLP        string `xml:"xmlns:samlp,attr"`
-	SAMLSIG      string `xml:"xmlns:samlsig,attr,omitempty"`
-	ID           string `xml:"ID,attr"`
-	Issuer       Issuer `xml:"Issuer"`
-	Status       Status `xml:"Status"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Destination  string   `xml:"Destination,attr,omitempty"`
+	Version      string   `xml:"Version,attr"`
+	IssueInstant DateTime `xml:"IssueInstant,attr"`
+	SAMLP        string   `xml:"xmlns:samlp,attr"`
+	SAML         string   `xml:"xmlns:saml,attr,omitempty"`
+	SAMLSIG      string   `xml:"xmlns:samlsig,attr,omitempty"`
+	ID           string   `xml:"ID,attr"`
+	Issuer       Issuer   `xml:"Issuer"`
+	Status       Status   `xml:"Status"`
 }
 
 // Issuer the issuer of the assertion
@@ -192,7 +242,7 @@ type Signature struct {
 
 type AuthnContextClassRef struct {
 	XMLName   xml.Name
-	SAML      string `xml:"xmlns:saml,attr"`
+	SAML      string `xml:"xmlns:saml,attr,omitempty"`
 	Transport string `xml:",innerxml"`
 }
 
@@ -250,35 +300,36 @@ type Transform struct {
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf Section 3.3.3.
 type Response struct {
 	XMLName      xml.Name
-	SAMLP        string `xml:"xmlns:samlp,attr"`
-	SAML         string `xml:"xmlns:saml,attr"`
-	SAMLSIG      string `xml:"xmlns:samlsig,attr"`
-	Destination  string `xml:"Destination,attr"`
-	ID           string `xml:"ID,attr"`
-	Version      string `xml:"Version,attr"`
-	IssueInstant string `xml:"IssueInstant,attr"`
-	InResponseTo string `xml:"InResponseTo,attr"`
-
-	Assertion Assertion `xml:"Assertion"`
-	Signature Signature `xml:"Signature"`
-	Issuer    Issuer    `xml:"Issuer"`
-	Status    Status    `xml:"Status"`
+	SAMLP        string   `xml:"xmlns:samlp,attr"`
+	SAML         string   `xml:"xmlns:saml,attr"`
+	SAMLSIG      string   `xml:"xmlns:samlsig,attr,omitempty"`
+	Destination  string   `xml:"Destination,attr,omitempty"`
+	ID           string   `xml:"ID,attr"`
+	Version      string   `xml:"Version,attr"`
+	IssueInstant DateTime `xml:"IssueInstant,attr"`
+	InResponseTo string   `xml:"InResponseTo,attr,omitempty"`
+
+	Issuer    Issuer     `xml:"Issuer"`
+	Signature *Signature `xml:"Signature,omitempty"`
+	Status    Status     `xml:"Status"`
+	Assertion Assertion  `xml:"Assertion"`
 
 	originalString string
 }
 
 type Assertion struct {
 	XMLName            xml.Name
-	ID                 string `xml:"ID,attr"`
-	Version            string `xml:"Version,attr"`
-	XS                 string `xml:"xmlns:xs,attr"`
-	XSI                string `xml:"xmlns:xsi,attr"`
-	SAML               string `xml:"saml,attr"`
-	IssueInstant       string `xml:"IssueInstant,attr"`
-	Issuer             Issuer `xml:"Issuer"`
+	ID                 string   `xml:"ID,attr"`
+	Version            string   `xml:"Version,attr"`
+	XS                 string   `xml:"xmlns:xs,attr,omitempty"`
+	XSI                string   `xml:"xmlns:xsi,attr,omitempty"`
+	SAML               string   `xml:"xmlns:saml,attr,omitempty"`
+	IssueInstant       DateTime `xml:"IssueInstant,attr"`
+	Issuer             Issuer   `xml:"Issuer"`
 	Subject            Subject
 	Conditions         Conditions
-	AttributeStatement AttributeStatement
+	AuthnStatement     AuthnStatement
+	AttributeStatement *AttributeStatement `xml:"AttributeStatement"`
 }
 
 type Subject struct {
@@ -287,22 +338,34 @@ type Subject struct {
 	SubjectConfirmation SubjectConfirmation
 }
 
+// Conditions limit the validity of an assertion. Both NotBefore and NotOnOrAfter are optional.
 type Conditions struct {
-	XMLName      xml.Name
-	NotBefore    string `xml:",attr"`
-	NotOnOrAfter string `xml:",attr"`
+	XMLName              xml.Name
+	NotBefore            DateTime              `xml:",attr"`
+	NotOnOrAfter         DateTime              `xml:",attr"`
+	AudienceRestrictions []AudienceRestriction `xml:"AudienceRestriction"`
+}
+
+// AudienceRestriction limits the service providers that may rely on an assertion
+// to those listed.
+type AudienceRestriction struct {
+	XMLName   xml.Name
+	Audiences []string `xml:"Audience"`
 }
 
 type NameID struct {
-	XMLName xml.Name
-	Format  string `xml:",attr"`
-	Value   string `xml:",innerxml"`
+	XMLName         xml.Name
+	Format          string `xml:",attr"`
+	NameQualifier   string `xml:",attr,omitempty"`
+	SPNameQualifier string `xml:",attr,omitempty"`
+	Value           string `xml:",innerxml"`
 }
 
 type SubjectConfirmationData struct {
-	InResponseTo string `xml:",attr"`
-	NotOnOrAfter string `xml:",attr"`
-	Recipient    string `xml:",attr"`
+	XMLName      xml.Name
+	InResponseTo string   `xml:",attr,omitempty"`
+	NotOnOrAfter DateTime `xml:",attr"`
+	Recipient    string   `xml:",attr,omitempty"`
 }
 
 type SubjectConfirmation struct {
@@ -311,17 +374,47 @@ type SubjectConfirmation struct {
 	SubjectConfirmationData SubjectConfirmationData
 }
 
+// AuthnStatement describes the authentication of the subject by the IDP. If present
+// SessionNotOnOrAfter is the time the session at the service provider must end.
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf Section 2.7.2
+type AuthnStatement struct {
+	XMLName             xml.Name
+	AuthnInstant        DateTime `xml:",attr"`
+	SessionIndex        string   `xml:",attr,omitempty"`
+	SessionNotOnOrAfter DateTime `xml:",attr"`
+	AuthnContext        AuthnContext
+}
+
+// AuthnContext contains the class of authentication used by the IDP
+type AuthnContext struct {
+	XMLName              xml.Name
+	AuthnContextClassRef AuthnContextClassRef `xml:"AuthnContextClassRef"`
+}
+
 type AttributeStatement struct {
 	XMLName    xml.Name
 	Attributes []Attribute `xml:"Attribute"`
 }
 
+// Status is the status of a response. StatusMessage and StatusDetail are optional and
+// may be supplied by the IDP to explain a failure.
 type Status struct {
-	XMLName    xml.Name
-	StatusCode StatusCode `xml:"StatusCode"`
+	XMLName       xml.Name
+	StatusCode    StatusCode    `xml:"StatusCode"`
+	StatusMessage string        `xml:"StatusMessage,omitempty"`
+	StatusDetail  *StatusDetail `xml:"StatusDetail,omitempty"`
 }
 
-type StatusCode struct {
+// StatusDetail contains additional, IDP specific, information about a status.
+type StatusDetail struct {
 	XMLName xml.Name
-	Value   string `xml:",attr"`
+	Content string `xml:",innerxml"`
+}
+
+// StatusCode contains a status code URI. A second level status code providing more
+// detail may be nested inside the top level code.
+type StatusCode struct {
+	XMLName    xml.Name
+	Value      string      `xml:",attr"`
+	StatusCode *StatusCode `xml:"StatusCode,omitempty"`
 }
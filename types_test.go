@@ -22,8 +22,8 @@ func TestEntityDescriptor(t *testing.T) {
 	keyDescriptor := descriptor.IDPSSODescriptor.KeyDescriptors[0]
 	assert.Equal(t, "signing", keyDescriptor.Use)
 	expected := "MIIEFDCCAvygAw"
-	require.True(t, len(keyDescriptor.KeyInfo.X509Data.X509Certificate.Data) > len(expected))
-	assert.Equal(t, "MIIEFDCCAvygAw", keyDescriptor.KeyInfo.X509Data.X509Certificate.Data[:len(expected)])
+	require.True(t, len(keyDescriptor.KeyInfo.X509Data.X509Certificates[0].Data) > len(expected))
+	assert.Equal(t, "MIIEFDCCAvygAw", keyDescriptor.KeyInfo.X509Data.X509Certificates[0].Data[:len(expected)])
 	assert.Len(t, descriptor.IDPSSODescriptor.SingleSignOnService, 3)
 	assert.Len(t, descriptor.IDPSSODescriptor.SingleLogoutService, 1)
 }
@@ -40,8 +40,8 @@ func TestEntityDescriptorNoSingleLogout(t *testing.T) {
 	keyDescriptor := descriptor.IDPSSODescriptor.KeyDescriptors[0]
 	assert.Equal(t, "signing", keyDescriptor.Use)
 	expected := "MIIEFDCCAvygAw"
-	require.True(t, len(keyDescriptor.KeyInfo.X509Data.X509Certificate.Data) > len(expected))
-	assert.Equal(t, "MIIEFDCCAvygAw", keyDescriptor.KeyInfo.X509Data.X509Certificate.Data[:len(expected)])
+	require.True(t, len(keyDescriptor.KeyInfo.X509Data.X509Certificates[0].Data) > len(expected))
+	assert.Equal(t, "MIIEFDCCAvygAw", keyDescriptor.KeyInfo.X509Data.X509Certificates[0].Data[:len(expected)])
 	assert.Len(t, descriptor.IDPSSODescriptor.SingleSignOnService, 3)
 	assert.Len(t, descriptor.IDPSSODescriptor.SingleLogoutService, 0)
 }
@@ -65,3 +65,20 @@ func TestLogoutRequest(t *testing.T) {
 	require.Nil(t, err)
 	assert.Equal(t, lr.ID, decoded.ID)
 }
+
+func TestEntityDescriptorValidUntil(t *testing.T) {
+	metadata := `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.com"
+	validUntil="2027-01-02T03:04:05.1234567Z"><IDPSSODescriptor/></EntityDescriptor>`
+	var descriptor EntityDescriptor
+	err := xml.Unmarshal([]byte(metadata), &descriptor)
+	require.Nil(t, err)
+	assert.Equal(t, 2027, descriptor.ValidUntil.Year())
+	assert.Equal(t, 123456700, descriptor.ValidUntil.Nanosecond())
+
+	buff, err := generated.Asset("test_data/metadata.xml")
+	require.Nil(t, err)
+	var noValidUntil EntityDescriptor
+	err = xml.Unmarshal(buff, &noValidUntil)
+	require.Nil(t, err)
+	assert.True(t, noValidUntil.ValidUntil.IsZero())
+}
@@ -0,0 +1,120 @@
+package saml
+
+import (
+	"fmt"
+)
+
+// Reason identifies the validation check that a message failed.
+type Reason int
+
+const (
+	// ReasonMalformed the message could not be decoded or parsed
+	ReasonMalformed Reason = iota + 1
+	// ReasonBadSignature the message signature is missing or invalid
+	ReasonBadSignature
+	// ReasonExpired the message was used outside of its validity period
+	ReasonExpired
+	// ReasonWrongIssuer the message was not issued by the expected IDP
+	ReasonWrongIssuer
+	// ReasonWrongAudience the assertion is not intended for this service provider
+	ReasonWrongAudience
+	// ReasonWrongRecipient the assertion was delivered to the wrong endpoint
+	ReasonWrongRecipient
+	// ReasonWrongDestination the message was sent to the wrong endpoint
+	ReasonWrongDestination
+	// ReasonReplay the message does not answer an outstanding request, it may have
+	// been used before
+	ReasonReplay
+	// ReasonStatusFailure the IDP returned a status other than success
+	ReasonStatusFailure
+)
+
+var reasonNames = map[Reason]string{
+	ReasonMalformed:        "malformed message",
+	ReasonBadSignature:     "bad signature",
+	ReasonExpired:          "expired",
+	ReasonWrongIssuer:      "wrong issuer",
+	ReasonWrongAudience:    "wrong audience",
+	ReasonWrongRecipient:   "wrong recipient",
+	ReasonWrongDestination: "wrong destination",
+	ReasonReplay:           "replay",
+	ReasonStatusFailure:    "status failure",
+}
+
+func (r Reason) String() string {
+	if name, ok := reasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+// ValidationError is returned when a message from the IDP, or a service provider, fails validation. Reason
+// indicates which check failed, Expected and Actual contain the offending values when
+// they are relevant. Err is the underlying error, if any.
+//
+// Use errors.As to inspect a ValidationError, or errors.Is with one of the Err* values
+// below to test for a particular reason.
+type ValidationError struct {
+	Reason   Reason
+	Expected string
+	Actual   string
+	Err      error
+}
+
+var (
+	// ErrMalformed matches validation errors with ReasonMalformed
+	ErrMalformed = &ValidationError{Reason: ReasonMalformed}
+	// ErrBadSignature matches validation errors with ReasonBadSignature
+	ErrBadSignature = &ValidationError{Reason: ReasonBadSignature}
+	// ErrExpired matches validation errors with ReasonExpired
+	ErrExpired = &ValidationError{Reason: ReasonExpired}
+	// ErrWrongIssuer matches validation errors with ReasonWrongIssuer
+	ErrWrongIssuer = &ValidationError{Reason: ReasonWrongIssuer}
+	// ErrWrongAudience matches validation errors with ReasonWrongAudience
+	ErrWrongAudience = &ValidationError{Reason: ReasonWrongAudience}
+	// ErrWrongRecipient matches validation errors with ReasonWrongRecipient
+	ErrWrongRecipient = &ValidationError{Reason: ReasonWrongRecipient}
+	// ErrWrongDestination matches validation errors with ReasonWrongDestination
+	ErrWrongDestination = &ValidationError{Reason: ReasonWrongDestination}
+	// ErrReplay matches validation errors with ReasonReplay
+	ErrReplay = &ValidationError{Reason: ReasonReplay}
+	// ErrStatusFailure matches validation errors with ReasonStatusFailure
+	ErrStatusFailure = &ValidationError{Reason: ReasonStatusFailure}
+)
+
+func newValidationError(reason Reason, err error) *ValidationError {
+	return &ValidationError{
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+func newMismatchError(reason Reason, expected, actual string) *ValidationError {
+	return &ValidationError{
+		Reason:   reason,
+		Expected: expected,
+		Actual:   actual,
+	}
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Reason.String()
+	if e.Expected != "" || e.Actual != "" {
+		msg = fmt.Sprintf("%s: expected %q got %q", msg, e.Expected, e.Actual)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is a ValidationError with the same reason.
+func (e *ValidationError) Is(target error) bool {
+	t, ok := target.(*ValidationError)
+	return ok && t.Reason == e.Reason
+}
@@ -0,0 +1,29 @@
+package saml
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationError(t *testing.T) {
+	cause := errors.New("boom")
+	err := errors.Wrap(newValidationError(ReasonBadSignature, cause), "handling response")
+	assert.True(t, errors.Is(err, ErrBadSignature))
+	assert.False(t, errors.Is(err, ErrExpired))
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, "handling response: bad signature: boom", err.Error())
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, ReasonBadSignature, validationErr.Reason)
+}
+
+func TestValidationErrorMismatch(t *testing.T) {
+	err := newMismatchError(ReasonWrongIssuer, "https://idp.com", "https://evil.com")
+	assert.Equal(t, `wrong issuer: expected "https://idp.com" got "https://evil.com"`, err.Error())
+	assert.True(t, errors.Is(err, ErrWrongIssuer))
+	assert.Equal(t, "reason(100)", Reason(100).String())
+}